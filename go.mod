@@ -5,6 +5,7 @@ go 1.24
 require (
 	github.com/beeker1121/goque v2.1.0+incompatible
 	github.com/claes/cec v0.0.0-20240820185959-6db0712de894
+	github.com/fsnotify/fsnotify v1.9.0
 	github.com/godbus/dbus/v5 v5.1.0
 	github.com/micmonay/keybd_event v1.1.2
 	github.com/spf13/cobra v1.10.1
@@ -13,7 +14,6 @@ require (
 
 require (
 	github.com/cpuguy83/go-md2man/v2 v2.0.6 // indirect
-	github.com/fsnotify/fsnotify v1.9.0 // indirect
 	github.com/go-viper/mapstructure/v2 v2.4.0 // indirect
 	github.com/golang/snappy v0.0.0-20180518054509-2e65f85255db // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
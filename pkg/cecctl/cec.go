@@ -0,0 +1,940 @@
+package cecctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/claes/cec"
+)
+
+// CEC device type constants for SetActiveSource.
+// These correspond to the CEC logical device types defined in the spec.
+const (
+	CECDeviceTypeTV          = 0
+	CECDeviceTypeRecording   = 1
+	CECDeviceTypeTuner       = 3
+	CECDeviceTypePlayback    = 4 // most appropriate for a PC/media player
+	CECDeviceTypeAudioSystem = 5
+)
+
+// broadcastAddress is the logical address every device on the bus listens to.
+const broadcastAddress = 15
+
+// Logical addresses of the TV and the audio system. Unlike the device type
+// constants above, these are where frames meant for them are sent.
+const (
+	cecAddressTV          = 0
+	cecAddressAudioSystem = 5
+)
+
+// errNoConnection is returned when a command is attempted while no CEC
+// connection is held, e.g. after a failed reopen.
+var errNoConnection = errors.New("no CEC connection")
+
+// Causes of a power command failure, wrapped in the addressError of each
+// failed address so callers can pick a recovery, see powerFailureNeedsRestart.
+var (
+	// errCommandRejected: the adapter answers but the device did not accept
+	// the command. Reconnecting or restarting does not help.
+	errCommandRejected = errors.New("command rejected")
+	// errConnectionLost: the connection stopped answering and the command
+	// still failed on a fresh one, which libcec only recovers from with a
+	// process restart.
+	errConnectionLost = errors.New("connection lost")
+	// errAdapterGone: the connection stopped answering and could not be
+	// reopened, e.g. the adapter was unplugged.
+	errAdapterGone = errors.New("CEC adapter unavailable")
+)
+
+// powerFailureNeedsRestart reports whether a PowerOn or Standby failure came
+// from the connection rather than from the devices, so that restarting the
+// process may recover from it.
+func powerFailureNeedsRestart(err error) bool {
+	return errors.Is(err, errConnectionLost) || errors.Is(err, errAdapterGone)
+}
+
+// errPowerNotConfirmed is returned when, with confirmation enabled, a device
+// acknowledged a power command but still reports the opposite power status.
+var errPowerNotConfirmed = errors.New("power status not confirmed")
+
+// addressError records a power command failure for a single logical address.
+type addressError struct {
+	Address int
+	Err     error
+}
+
+func (e *addressError) Error() string {
+	return fmt.Sprintf("address %d: %v", e.Address, e.Err)
+}
+
+func (e *addressError) Unwrap() error {
+	return e.Err
+}
+
+// failedAddresses extracts the addresses that failed from an error returned
+// by PowerOn or Standby.
+func failedAddresses(err error) []int {
+	var failed []int
+	var collect func(error)
+	collect = func(err error) {
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, e := range joined.Unwrap() {
+				collect(e)
+			}
+			return
+		}
+		var addrErr *addressError
+		if errors.As(err, &addrErr) {
+			failed = append(failed, addrErr.Address)
+		}
+	}
+	if err != nil {
+		collect(err)
+	}
+	return failed
+}
+
+// withoutAddress returns addresses without address.
+func withoutAddress(addresses []int, address int) []int {
+	var rest []int
+	for _, addr := range addresses {
+		if addr != address {
+			rest = append(rest, addr)
+		}
+	}
+	return rest
+}
+
+// powerCommandRetryDelay is the pause between two attempts of the same power
+// command, giving a TV in the middle of a transition time to settle.
+const powerCommandRetryDelay = 500 * time.Millisecond
+
+// defaultInitiator is the logical address raw frames are sent from. libcec
+// registers this controller as a recording device, which takes address 1.
+const defaultInitiator = 1
+
+// maxOSDNameLength is the longest OSD name allowed by the CEC spec.
+const maxOSDNameLength = 14
+
+// reconnectOSDMessage is shown on the TV after a reopen with
+// SetReconnectOSD. OSD strings are limited to 13 characters.
+const reconnectOSDMessage = "Reconnected"
+
+// primaryRestoreInterval is how often the primary adapter is tried again
+// while running on the fallback one, see SetFallbackAdapter.
+const primaryRestoreInterval = 30 * time.Second
+
+// busReadyPollInterval is how often WaitReady pings the adapter.
+const busReadyPollInterval = 100 * time.Millisecond
+
+// CEC is a connection to a CEC adapter that sends power and volume commands
+// and reopens the adapter when it stops answering. Key presses received from
+// the remote are sent on the channel given to NewCEC.
+type CEC struct {
+	adapter    string
+	retries    int
+	deviceName string
+
+	commandRetries    int
+	commandRetryDelay time.Duration
+	clock             Clock
+	initiator         int             // logical address commands are sent from, see SetInitiator
+	ctx               context.Context // stops reopen and retry waits on shutdown, see SetContext
+	confirmPower      bool            // check the power status after power commands, see SetConfirmPower
+	reconnectOSD      bool            // show a message on the TV after a reopen, see SetReconnectOSD
+	activeSourceType  int             // device type claimed by OneTouchPlay, see SetActiveSourceType
+	fallbackAdapter   string          // adapter used when adapter cannot be reopened, see SetFallbackAdapter
+	onFallback        bool            // conn is on fallbackAdapter, and the primary is being restored
+	restoreStop       chan struct{}   // closed by Close to stop restorePrimary
+	restoring         sync.WaitGroup  // the restorePrimary goroutine
+	inStandby         bool            // devices were last put to standby, pauses KeepActiveSource
+	commandGap        time.Duration   // pause between the addresses of a power command, see SetCommandGap
+
+	conn      CECConnection
+	connMu    sync.RWMutex
+	cecOpener func(string, string) (CECConnection, error)
+
+	keyPresses    chan *cec.KeyPress
+	commands      chan *cec.Command // optional raw frame stream, see SetCommandsChan
+	powerCommands map[int]string    // power command per address, see SetPowerCommands
+	powerOnMethod string            // power command of the other addresses, see SetPowerOnMethod
+}
+
+// NewCEC opens the adapter with the given backend, CECBackendLibcec or
+// CECBackendCECClient, see cecOpenerFor for hdmiPort.
+func NewCEC(backend string, adapter string, hdmiPort int, deviceName string, connectionRetries int, commandRetries int, keyPresses chan *cec.KeyPress) (*CEC, error) {
+	opener, err := cecOpenerFor(backend, hdmiPort)
+	if err != nil {
+		return nil, err
+	}
+	return newCECWithOpener(adapter, deviceName, connectionRetries, commandRetries, keyPresses, opener)
+}
+
+func newCECWithOpener(adapter string, deviceName string, connectionRetries int, commandRetries int, keyPresses chan *cec.KeyPress, opener func(string, string) (CECConnection, error)) (*CEC, error) {
+	if connectionRetries < 1 {
+		slog.Warn("Connection retries must be at least 1, setting to 1")
+		connectionRetries = 1
+	}
+	if commandRetries < 1 {
+		slog.Warn("Power command retries must be at least 1, setting to 1")
+		commandRetries = 1
+	}
+
+	conn, err := opener(adapter, deviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetKeyPressesChan(keyPresses)
+
+	return &CEC{
+		conn:       conn,
+		adapter:    adapter,
+		retries:    connectionRetries,
+		deviceName: deviceName,
+		keyPresses: keyPresses,
+		cecOpener:  opener,
+
+		commandRetries:    commandRetries,
+		commandRetryDelay: powerCommandRetryDelay,
+		clock:             realClock{},
+		initiator:         defaultInitiator,
+		activeSourceType:  CECDeviceTypePlayback,
+		ctx:               context.Background(),
+	}, nil
+}
+
+func (c *CEC) reopen() error {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	if c.conn != nil {
+		slog.Warn("CEC Connection lost, reopening...")
+		c.conn.Close()
+		c.conn = nil
+	}
+
+	for i := 0; i < c.retries; i++ {
+		if err := c.ctx.Err(); err != nil {
+			return fmt.Errorf("gave up reopening CEC connection: %w", err)
+		}
+		conn, err := c.cecOpener(c.adapter, c.deviceName)
+		if err != nil {
+			slog.Error("Failed to open CEC connection", "attempt", i+1, "error", err)
+			continue
+		}
+
+		c.attach(conn)
+		c.onFallback = false
+		slog.Info("CEC connection re-established")
+		c.showReconnectOSD()
+		return nil
+	}
+
+	if c.fallbackAdapter != "" {
+		if err := c.openFallback(); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: failed to open CEC connection after %d attempts", errAdapterGone, c.retries)
+}
+
+// attach makes conn the connection commands are sent on and key presses are
+// read from; c.connMu must be held for writing.
+func (c *CEC) attach(conn CECConnection) {
+	c.conn = conn
+	c.conn.SetKeyPressesChan(c.keyPresses)
+	if c.commands != nil {
+		c.conn.SetCommandsChan(c.commands)
+	}
+}
+
+// showReconnectOSD shows reconnectOSDMessage on the TV when enabled with
+// SetReconnectOSD; c.connMu must be held.
+func (c *CEC) showReconnectOSD() {
+	if c.reconnectOSD {
+		// Set OSD String, displayed for the TV's default time.
+		c.conn.Transmit(formatCommand(c.initiator, cecAddressTV, 0x64, append([]byte{0x00}, reconnectOSDMessage...)...))
+	}
+}
+
+// openFallback switches to the fallback adapter once the primary one could
+// not be reopened, and starts restoring the primary in the background;
+// c.connMu must be held for writing.
+func (c *CEC) openFallback() error {
+	conn, err := c.cecOpener(c.fallbackAdapter, c.deviceName)
+	if err != nil {
+		slog.Error("Failed to open the fallback CEC adapter", "cec-fallback-adapter", c.fallbackAdapter, "error", err)
+		return err
+	}
+	c.attach(conn)
+	slog.Warn("Primary CEC adapter unavailable, switched to the fallback adapter", "cec-adapter", c.adapter, "cec-fallback-adapter", c.fallbackAdapter)
+	c.showReconnectOSD()
+	if !c.onFallback {
+		c.onFallback = true
+		c.restoreStop = make(chan struct{})
+		c.restoring.Add(1)
+		go c.restorePrimary(c.restoreStop)
+	}
+	return nil
+}
+
+// restorePrimary tries to open the primary adapter every
+// primaryRestoreInterval while running on the fallback one, and switches
+// back to it once it opens. It returns right away once stop is closed.
+func (c *CEC) restorePrimary(stop <-chan struct{}) {
+	defer c.restoring.Done()
+	for {
+		select {
+		case <-c.context().Done():
+			return
+		case <-stop:
+			return
+		case <-c.clock.After(primaryRestoreInterval):
+		}
+		c.connMu.RLock()
+		onFallback := c.onFallback
+		c.connMu.RUnlock()
+		if !onFallback {
+			return
+		}
+
+		conn, err := c.cecOpener(c.adapter, c.deviceName)
+		if err != nil {
+			slog.Debug("Primary CEC adapter still unavailable", "cec-adapter", c.adapter, "error", err)
+			continue
+		}
+		c.connMu.Lock()
+		if !c.onFallback {
+			// Restored by a reopen, or closed, meanwhile.
+			c.connMu.Unlock()
+			conn.Close()
+			return
+		}
+		fallback := c.conn
+		c.attach(conn)
+		c.onFallback = false
+		c.connMu.Unlock()
+		if fallback != nil {
+			fallback.Close()
+		}
+		slog.Info("Primary CEC adapter restored", "cec-adapter", c.adapter)
+		return
+	}
+}
+
+// Power commands, selectable per address with power-commands for devices the
+// libcec commands do not wake or put to sleep.
+const (
+	powerCommandDefault     = "poweron"     // libcec PowerOn and Standby
+	powerCommandImageViewOn = "imageviewon" // Image View On, libcec Standby
+	powerCommandTextViewOn  = "textviewon"  // Text View On, libcec Standby
+	powerCommandUserPower   = "userpower"   // Power On/Off Function user controls
+
+	powerCommandPowerOnImageViewOn = "poweron+imageviewon" // libcec PowerOn then Image View On, libcec Standby
+)
+
+// validPowerCommand reports whether command is a known power command.
+func validPowerCommand(command string) bool {
+	switch command {
+	case powerCommandDefault, powerCommandImageViewOn, powerCommandTextViewOn, powerCommandUserPower, powerCommandPowerOnImageViewOn:
+		return true
+	}
+	return false
+}
+
+// validPowerOnMethod reports whether method can be used with --power-on-method,
+// which only changes how devices are powered on.
+func validPowerOnMethod(method string) bool {
+	switch method {
+	case powerCommandDefault, powerCommandImageViewOn, powerCommandTextViewOn, powerCommandPowerOnImageViewOn:
+		return true
+	}
+	return false
+}
+
+// powerFrames returns the raw frames implementing a power command, or nil
+// when the libcec PowerOn/Standby should be used.
+func powerFrames(command string, isPowerOn bool, initiator, address int) []string {
+	switch {
+	case command == powerCommandImageViewOn && isPowerOn:
+		return []string{formatCommand(initiator, address, 0x04)}
+	case command == powerCommandTextViewOn && isPowerOn:
+		return []string{formatCommand(initiator, address, 0x0D)}
+	case command == powerCommandUserPower:
+		key := byte(0x6C) // Power Off Function
+		if isPowerOn {
+			key = 0x6D // Power On Function
+		}
+		// User Control Pressed followed by User Control Released.
+		return []string{
+			formatCommand(initiator, address, 0x44, key),
+			formatCommand(initiator, address, 0x45),
+		}
+	}
+	return nil
+}
+
+// SetContext makes reopen and the power command retries give up once ctx is
+// done, so a shutdown does not wait for the whole retry budget.
+func (c *CEC) SetContext(ctx context.Context) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	c.ctx = ctx
+}
+
+// context returns the context set with SetContext.
+func (c *CEC) context() context.Context {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+	return c.ctx
+}
+
+// SetConfirmPower makes power commands ask the device for its power status
+// after it acknowledged the command, and retry when it has not changed.
+// Devices that do not report their power status are trusted.
+func (c *CEC) SetConfirmPower(confirm bool) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	c.confirmPower = confirm
+}
+
+// SetCommandGap sets the pause between the addresses of a power command, for
+// slow devices that drop commands sent back to back. Zero sends them at once.
+func (c *CEC) SetCommandGap(gap time.Duration) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	c.commandGap = gap
+}
+
+// SetReconnectOSD makes a successful reopen show a short message on the TV,
+// so a dropped connection does not go unnoticed by the person watching.
+func (c *CEC) SetReconnectOSD(enabled bool) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	c.reconnectOSD = enabled
+}
+
+// SetFallbackAdapter sets the adapter switched to when the primary one cannot
+// be reopened. The primary is then tried again in the background and used
+// again as soon as it opens. Empty disables failover.
+func (c *CEC) SetFallbackAdapter(adapter string) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	c.fallbackAdapter = adapter
+}
+
+// OnFallback reports whether commands currently go through the fallback
+// adapter.
+func (c *CEC) OnFallback() bool {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+	return c.onFallback
+}
+
+// SetActiveSourceType sets the device type OneTouchPlay claims the active
+// source as.
+func (c *CEC) SetActiveSourceType(deviceType int) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	c.activeSourceType = deviceType
+}
+
+// SetInitiator sets the logical address the commands built by this package
+// (power-commands frames, SetMute) claim to come from, e.g. 0 to pretend to
+// be the TV. Commands sent through libcec always use the adapter's address.
+func (c *CEC) SetInitiator(address int) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	c.initiator = address
+}
+
+// commandInitiator returns the initiator set with SetInitiator.
+func (c *CEC) commandInitiator() int {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+	return c.initiator
+}
+
+// SetPowerCommands selects, per address, the command used to power the
+// device on and off. Addresses not listed use the libcec commands.
+func (c *CEC) SetPowerCommands(commands map[int]string) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	c.powerCommands = commands
+}
+
+// SetPowerOnMethod selects the power command used for the addresses not set
+// with SetPowerCommands, e.g. powerCommandImageViewOn for displays that stay
+// blank after a plain power on. Standby always goes through libcec with the
+// methods allowed here.
+func (c *CEC) SetPowerOnMethod(method string) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	c.powerOnMethod = method
+}
+
+// powerCall calls the appropriate power function while holding the read lock,
+// ensuring the connection is not replaced concurrently by reopen().
+func (c *CEC) powerCall(isPowerOn bool, address int) error {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+	if c.conn == nil {
+		return errNoConnection
+	}
+	command, ok := c.powerCommands[address]
+	if !ok {
+		command = c.powerOnMethod
+	}
+	if command == powerCommandPowerOnImageViewOn {
+		command = powerCommandDefault
+		if isPowerOn {
+			if err := c.conn.PowerOn(address); err != nil {
+				return err
+			}
+			command = powerCommandImageViewOn
+		}
+	}
+	if frames := powerFrames(command, isPowerOn, c.initiator, address); frames != nil {
+		for _, frame := range frames {
+			slog.Debug("Sending raw CEC command", "command", frame)
+			c.conn.Transmit(frame)
+		}
+		return nil
+	}
+	if isPowerOn {
+		return c.conn.PowerOn(address)
+	}
+	return c.conn.Standby(address)
+}
+
+// connectionAlive reports whether the current connection's adapter answers.
+func (c *CEC) connectionAlive() bool {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+	return c.conn != nil && c.conn.ConnectionAlive()
+}
+
+// sendPower tries a power command up to commandRetries times on the current
+// connection, pausing between attempts. It gives up early when the adapter no
+// longer answers since only a reopen can help then.
+func (c *CEC) sendPower(isPowerOn bool, address int) error {
+	var err error
+	for attempt := 1; attempt <= c.commandRetries; attempt++ {
+		err = c.powerCall(isPowerOn, address)
+		slog.Debug("Power command sent", "address", address, "on", isPowerOn, "attempt", attempt, "acknowledged", err == nil)
+		if err == nil {
+			if err = c.checkPowerStatus(isPowerOn, address); err == nil {
+				return nil
+			}
+		} else if !c.connectionAlive() {
+			return err
+		}
+		if attempt < c.commandRetries {
+			slog.Debug("Power command failed, retrying", "address", address, "attempt", attempt, "error", err)
+			select {
+			case <-c.clock.After(c.commandRetryDelay):
+			case <-c.context().Done():
+				return err
+			}
+		}
+	}
+	return err
+}
+
+// checkPowerStatus confirms, when enabled with SetConfirmPower, that the
+// device reached the requested power state. It waits commandRetryDelay first
+// so the device has time to start its transition, and returns the context's
+// error if it is done before the status could be checked.
+func (c *CEC) checkPowerStatus(isPowerOn bool, address int) error {
+	c.connMu.RLock()
+	confirm := c.confirmPower
+	c.connMu.RUnlock()
+	if !confirm {
+		return nil
+	}
+	ctx := c.context()
+	select {
+	case <-c.clock.After(c.commandRetryDelay):
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %w", errPowerNotConfirmed, ctx.Err())
+	}
+
+	c.connMu.RLock()
+	status := ""
+	if c.conn != nil {
+		status = c.conn.PowerStatus(address)
+	}
+	c.connMu.RUnlock()
+	slog.Debug("Power status after power command", "address", address, "on", isPowerOn, "status", status)
+
+	switch status {
+	case "":
+		// Not every device answers power status requests.
+		return nil
+	case "on", "starting":
+		if isPowerOn {
+			return nil
+		}
+	case "standby", "shutting down":
+		if !isPowerOn {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: device reports %q", errPowerNotConfirmed, status)
+}
+
+// power sends the command to every address, even if some of them fail. The
+// returned error joins one addressError per failed address so callers can
+// tell a partial failure from a total one, see failedAddresses.
+func (c *CEC) power(isPowerOn bool, addresses ...int) error {
+	c.connMu.Lock()
+	c.inStandby = !isPowerOn
+	gap := c.commandGap
+	c.connMu.Unlock()
+
+	var errs []error
+	for i, addr := range addresses {
+		if i > 0 && gap > 0 {
+			select {
+			case <-c.clock.After(gap):
+			case <-c.context().Done():
+				for _, rest := range addresses[i:] {
+					errs = append(errs, &addressError{Address: rest, Err: c.context().Err()})
+				}
+				return errors.Join(errs...)
+			}
+		}
+		err := c.sendPower(isPowerOn, addr)
+		if err == nil {
+			continue
+		}
+		// The command went out but the context ended before its power status
+		// could be confirmed: neither a rejection nor a lost connection.
+		if ctxErr := c.context().Err(); ctxErr != nil && errors.Is(err, ctxErr) {
+			errs = append(errs, &addressError{Address: addr, Err: err})
+			continue
+		}
+		// The adapter still answers, so the device rejected the command:
+		// reconnecting would not help.
+		if c.connectionAlive() {
+			errs = append(errs, &addressError{Address: addr, Err: fmt.Errorf("%w: %w", errCommandRejected, err)})
+			continue
+		}
+		if err := c.reopen(); err != nil {
+			// Without a connection the remaining addresses are unreachable too.
+			for _, rest := range addresses[i:] {
+				errs = append(errs, &addressError{Address: rest, Err: err})
+			}
+			break
+		}
+		if err := c.sendPower(isPowerOn, addr); err != nil {
+			errs = append(errs, &addressError{Address: addr, Err: fmt.Errorf("%w: failed again after reopening: %w", errConnectionLost, err)})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// PowerOn powers on the devices at the given logical addresses. The error
+// joins the failures of each address.
+func (c *CEC) PowerOn(addresses ...int) error {
+	return c.power(true, addresses...)
+}
+
+// Standby puts the devices at the given logical addresses to standby. The
+// error joins the failures of each address.
+func (c *CEC) Standby(addresses ...int) error {
+	return c.power(false, addresses...)
+}
+
+// StandbyOnExit puts addresses to standby while the process exits, once the
+// context set with SetContext is done: the retries and reopens get their own
+// deadline of timeout instead, so they are not cut short like Standby's.
+func (c *CEC) StandbyOnExit(timeout time.Duration, addresses ...int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	c.SetContext(ctx)
+	return c.Standby(addresses...)
+}
+
+// parsePhysicalAddress parses a CEC physical address such as "2.0.0.0", the
+// HDMI port path from the TV, into its 16-bit form.
+func parsePhysicalAddress(s string) (uint16, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 4 {
+		return 0, fmt.Errorf("physical address must look like 2.0.0.0 (got %q)", s)
+	}
+	var addr uint16
+	for _, part := range parts {
+		n, err := strconv.ParseUint(part, 16, 4)
+		if err != nil || len(part) != 1 {
+			return 0, fmt.Errorf("physical address must look like 2.0.0.0 (got %q)", s)
+		}
+		addr = addr<<4 | uint16(n)
+	}
+	return addr, nil
+}
+
+// SetStreamPath asks the TV to switch to the HDMI input at the given physical
+// address with a broadcast "Set Stream Path", whatever device is there.
+func (c *CEC) SetStreamPath(physicalAddress uint16) error {
+	return c.SendCommand(formatCommand(c.commandInitiator(), broadcastAddress, 0x86, byte(physicalAddress>>8), byte(physicalAddress)))
+}
+
+// StandbyAll puts every device on the bus to standby with a broadcast, not
+// only the configured ones. It reopens the connection like Standby.
+func (c *CEC) StandbyAll() error {
+	return c.power(false, broadcastAddress)
+}
+
+// opcodeRequestActiveSource is broadcast by TVs asking the active source to
+// announce itself, e.g. when they power on.
+const opcodeRequestActiveSource = 0x85
+
+// answerActiveSourceRequests forwards every frame of commands to out and
+// answers each "Request Active Source" by claiming the active source, so the
+// TV switches to this device. Like KeepActiveSource, it leaves the TV alone
+// once devices are put to standby, e.g. to watch another input. out is closed
+// once commands is.
+func answerActiveSourceRequests(commands <-chan *cec.Command, out chan<- *cec.Command, c *CEC, deviceType int) {
+	defer close(out)
+	for cmd := range commands {
+		if cmd != nil && cmd.OpcodeSet != 0 && cmd.Opcode == opcodeRequestActiveSource {
+			if c.standby() {
+				slog.Debug("Devices in standby, not answering active source request")
+			} else if c.SetActiveSource(deviceType) {
+				slog.Info("Answered active source request", "deviceType", deviceType)
+			} else {
+				slog.Warn("Failed to answer active source request")
+			}
+		}
+		out <- cmd
+	}
+}
+
+// SetActiveSource broadcasts to the CEC network that this device is the active
+// source, causing the TV to switch its input accordingly.
+func (c *CEC) SetActiveSource(deviceType int) bool {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+	if c.conn == nil {
+		return false
+	}
+	return c.conn.SetActiveSource(deviceType)
+}
+
+// standby reports whether devices were last put to standby rather than
+// powered on.
+func (c *CEC) standby() bool {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+	return c.inStandby
+}
+
+// KeepActiveSource claims the active source for deviceType again every
+// interval until ctx is done, for TVs that drift back to their tuner. Nothing
+// is sent once devices are put to standby, until they are powered on again.
+func (c *CEC) KeepActiveSource(ctx context.Context, interval time.Duration, deviceType int) {
+	ticker := c.clock.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+		if c.standby() {
+			continue
+		}
+		if c.SetActiveSource(deviceType) {
+			slog.Debug("Active source re-asserted", "deviceType", deviceType)
+		} else {
+			slog.Debug("Failed to re-assert active source", "deviceType", deviceType)
+		}
+	}
+}
+
+// OneTouchPlay performs the CEC "One Touch Play" sequence: it makes sure the
+// adapter answers, reopening it otherwise, wakes the TV with "Image View On"
+// and claims the active source so the TV switches its input to this device.
+// Failures are reported as an addressError for the TV, like PowerOn does.
+func (c *CEC) OneTouchPlay() error {
+	c.connMu.Lock()
+	c.inStandby = false
+	c.connMu.Unlock()
+
+	if !c.connectionAlive() {
+		if err := c.reopen(); err != nil {
+			return &addressError{Address: cecAddressTV, Err: err}
+		}
+	}
+	c.connMu.RLock()
+	deviceType := c.activeSourceType
+	c.connMu.RUnlock()
+
+	if err := c.SendCommand(formatCommand(c.commandInitiator(), cecAddressTV, 0x04)); err != nil {
+		return &addressError{Address: cecAddressTV, Err: fmt.Errorf("%w: %w", errConnectionLost, err)}
+	}
+	if !c.SetActiveSource(deviceType) {
+		return &addressError{Address: cecAddressTV, Err: fmt.Errorf("%w: active source not claimed", errCommandRejected)}
+	}
+	return nil
+}
+
+// volumeCall runs a volume command on the current connection while holding
+// the read lock. Volume keys are best effort so no reopen is attempted.
+func (c *CEC) volumeCall(name string, call func(CECConnection) error) error {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+	if c.conn == nil {
+		return errNoConnection
+	}
+	if err := call(c.conn); err != nil {
+		return fmt.Errorf("failed to send %s: %w", name, err)
+	}
+	return nil
+}
+
+// VolumeUp asks the CEC audio system (or the TV speakers) to raise the volume.
+func (c *CEC) VolumeUp() error {
+	return c.volumeCall("volume up", CECConnection.VolumeUp)
+}
+
+// VolumeDown asks the CEC audio system (or the TV speakers) to lower the volume.
+func (c *CEC) VolumeDown() error {
+	return c.volumeCall("volume down", CECConnection.VolumeDown)
+}
+
+// Mute toggles mute on the CEC audio system (or the TV speakers).
+func (c *CEC) Mute() error {
+	return c.volumeCall("mute", CECConnection.Mute)
+}
+
+// User control codes that set mute explicitly, unlike the Mute key which
+// toggles it.
+const (
+	userControlMuteFunction          = 0x65
+	userControlRestoreVolumeFunction = 0x66
+)
+
+// SetMute mutes or unmutes the CEC audio system explicitly. Setting the same
+// state twice is harmless, so callers do not need to track the current one.
+func (c *CEC) SetMute(muted bool) error {
+	key := byte(userControlRestoreVolumeFunction)
+	if muted {
+		key = userControlMuteFunction
+	}
+	// User Control Pressed followed by User Control Released.
+	initiator := c.commandInitiator()
+	if err := c.SendCommand(formatCommand(initiator, cecAddressAudioSystem, 0x44, key)); err != nil {
+		return fmt.Errorf("failed to send set mute: %w", err)
+	}
+	return c.SendCommand(formatCommand(initiator, cecAddressAudioSystem, 0x45))
+}
+
+// SendCommand transmits a raw CEC frame on the current connection, see
+// CECConnection.Transmit for the format.
+func (c *CEC) SendCommand(command string) error {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+	if c.conn == nil {
+		return errNoConnection
+	}
+	slog.Debug("Sending raw CEC command", "command", command)
+	c.conn.Transmit(command)
+	return nil
+}
+
+// formatCommand encodes a CEC frame as the colon separated hex string
+// expected by Transmit.
+func formatCommand(initiator, destination int, opcode byte, params ...byte) string {
+	parts := make([]string, 0, 2+len(params))
+	parts = append(parts, fmt.Sprintf("%X%X", initiator&0xF, destination&0xF), fmt.Sprintf("%02X", opcode))
+	for _, p := range params {
+		parts = append(parts, fmt.Sprintf("%02X", p))
+	}
+	return strings.Join(parts, ":")
+}
+
+// SetOSDName pushes a new name for this device to the TV with a "Set OSD
+// Name" message, so its source list updates without reopening the adapter.
+// The name is also kept for future reopens.
+func (c *CEC) SetOSDName(name string) error {
+	if name == "" || len(name) > maxOSDNameLength {
+		return fmt.Errorf("OSD name must be 1 to %d characters (got %q)", maxOSDNameLength, name)
+	}
+	for _, r := range name {
+		if r < 0x20 || r > 0x7E {
+			return fmt.Errorf("OSD name must be printable ASCII (got %q)", name)
+		}
+	}
+
+	c.connMu.Lock()
+	c.deviceName = name
+	c.connMu.Unlock()
+
+	slog.Info("Setting OSD name", "name", name)
+	return c.SendCommand(formatCommand(c.commandInitiator(), cecAddressTV, 0x47, []byte(name)...))
+}
+
+// WaitReady polls the adapter until it answers or the timeout expires, so the
+// first command after opening is not sent while the bus is still negotiating.
+// It reports whether the adapter became ready.
+func (c *CEC) WaitReady(ctx context.Context, timeout time.Duration) bool {
+	expired := c.clock.After(timeout)
+	ticker := c.clock.NewTicker(busReadyPollInterval)
+	defer ticker.Stop()
+	for {
+		if c.connectionAlive() {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-expired:
+			return false
+		case <-ticker.C():
+		}
+	}
+}
+
+// SetCommandsChan delivers every raw frame received to ch, on the current
+// connection and on the ones opened by later reopens. See
+// CECConnection.SetCommandsChan.
+func (c *CEC) SetCommandsChan(ch chan *cec.Command) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	c.commands = ch
+	if c.conn != nil {
+		c.conn.SetCommandsChan(ch)
+	}
+}
+
+// Connected reports whether a CEC connection is currently held.
+func (c *CEC) Connected() bool {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+	return c.conn != nil
+}
+
+// Close closes the connection to the adapter.
+func (c *CEC) Close() {
+	c.connMu.Lock()
+	c.onFallback = false
+	if c.restoreStop != nil {
+		close(c.restoreStop)
+		c.restoreStop = nil
+	}
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+	c.connMu.Unlock()
+	// Outside the lock, which restorePrimary takes to switch adapters.
+	c.restoring.Wait()
+}
@@ -0,0 +1,1121 @@
+package cecctl
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/claes/cec"
+)
+
+// MockCECConnection is a mock implementation of CECConnection for testing.
+// Semantics follow standard Go: nil = success, non-nil = failure.
+type MockCECConnection struct {
+	PowerOnFunc          func(address int) error
+	StandbyFunc          func(address int) error
+	SetActiveSourceFunc  func(deviceType int) bool
+	ConnectionAliveFunc  func() bool
+	PowerStatusFunc      func(address int) string
+	CloseFunc            func()
+	PowerOnCalls         []int
+	StandbyCalls         []int
+	SetActiveSourceCalls []int
+	Transmitted          []string
+	Commands             chan *cec.Command
+	CloseCalled          bool
+}
+
+func (m *MockCECConnection) PowerOn(address int) error {
+	m.PowerOnCalls = append(m.PowerOnCalls, address)
+	if m.PowerOnFunc != nil {
+		return m.PowerOnFunc(address)
+	}
+	return nil
+}
+
+func (m *MockCECConnection) Standby(address int) error {
+	m.StandbyCalls = append(m.StandbyCalls, address)
+	if m.StandbyFunc != nil {
+		return m.StandbyFunc(address)
+	}
+	return nil
+}
+
+func (m *MockCECConnection) SetActiveSource(deviceType int) bool {
+	m.SetActiveSourceCalls = append(m.SetActiveSourceCalls, deviceType)
+	if m.SetActiveSourceFunc != nil {
+		return m.SetActiveSourceFunc(deviceType)
+	}
+	return true
+}
+
+// ConnectionAlive defaults to false so that failures are treated as a lost
+// connection unless a test opts in.
+func (m *MockCECConnection) ConnectionAlive() bool {
+	if m.ConnectionAliveFunc != nil {
+		return m.ConnectionAliveFunc()
+	}
+	return false
+}
+
+func (m *MockCECConnection) PowerStatus(address int) string {
+	if m.PowerStatusFunc != nil {
+		return m.PowerStatusFunc(address)
+	}
+	return ""
+}
+
+func (m *MockCECConnection) Close() {
+	m.CloseCalled = true
+	if m.CloseFunc != nil {
+		m.CloseFunc()
+	}
+}
+
+func (m *MockCECConnection) VolumeUp() error   { return nil }
+func (m *MockCECConnection) VolumeDown() error { return nil }
+func (m *MockCECConnection) Mute() error       { return nil }
+
+func (m *MockCECConnection) Transmit(command string) {
+	m.Transmitted = append(m.Transmitted, command)
+}
+
+func (m *MockCECConnection) SetCommandsChan(ch chan *cec.Command) {
+	m.Commands = ch
+}
+
+func (m *MockCECConnection) SetKeyPressesChan(chan *cec.KeyPress) {}
+
+// newTestCEC creates a CEC instance with the given mock connection, bypassing cec.Open.
+func newTestCEC(conn CECConnection, opener func(string, string) (CECConnection, error)) *CEC {
+	if opener == nil {
+		opener = func(string, string) (CECConnection, error) {
+			return nil, errors.New("no opener configured")
+		}
+	}
+	return &CEC{
+		conn:       conn,
+		retries:    3,
+		adapter:    "test",
+		deviceName: "test",
+		cecOpener:  opener,
+		keyPresses: make(chan *cec.KeyPress, 1),
+
+		commandRetries: 1,
+		clock:          realClock{},
+		initiator:      defaultInitiator,
+		ctx:            context.Background(),
+	}
+}
+
+func TestCECConnection_Interface(t *testing.T) {
+	var _ CECConnection = (*MockCECConnection)(nil)
+}
+
+func TestMockCECConnection_PowerOn(t *testing.T) {
+	mock := &MockCECConnection{}
+	if err := mock.PowerOn(0); err != nil {
+		t.Errorf("Expected nil (success), got %v", err)
+	}
+	if len(mock.PowerOnCalls) != 1 || mock.PowerOnCalls[0] != 0 {
+		t.Errorf("Expected PowerOn called with address 0, got %v", mock.PowerOnCalls)
+	}
+}
+
+func TestMockCECConnection_Standby(t *testing.T) {
+	mock := &MockCECConnection{}
+	if err := mock.Standby(1); err != nil {
+		t.Errorf("Expected nil (success), got %v", err)
+	}
+	if len(mock.StandbyCalls) != 1 || mock.StandbyCalls[0] != 1 {
+		t.Errorf("Expected Standby called with address 1, got %v", mock.StandbyCalls)
+	}
+}
+
+func TestMockCECConnection_Close(t *testing.T) {
+	mock := &MockCECConnection{}
+	if mock.CloseCalled {
+		t.Error("Expected CloseCalled to be false initially")
+	}
+	mock.Close()
+	if !mock.CloseCalled {
+		t.Error("Expected CloseCalled to be true after calling Close()")
+	}
+}
+
+func TestMockCECConnection_SetActiveSource(t *testing.T) {
+	mock := &MockCECConnection{}
+	if !mock.SetActiveSource(CECDeviceTypePlayback) {
+		t.Error("Expected SetActiveSource to return true by default")
+	}
+	if len(mock.SetActiveSourceCalls) != 1 || mock.SetActiveSourceCalls[0] != CECDeviceTypePlayback {
+		t.Errorf("Expected SetActiveSource called with %d, got %v", CECDeviceTypePlayback, mock.SetActiveSourceCalls)
+	}
+}
+
+func TestCECSetActiveSource_Success(t *testing.T) {
+	mock := &MockCECConnection{}
+	c := newTestCEC(mock, nil)
+	if !c.SetActiveSource(CECDeviceTypePlayback) {
+		t.Error("Expected SetActiveSource to succeed")
+	}
+	if len(mock.SetActiveSourceCalls) != 1 {
+		t.Errorf("Expected 1 SetActiveSource call, got %d", len(mock.SetActiveSourceCalls))
+	}
+}
+
+func TestCECSetActiveSource_Failure(t *testing.T) {
+	mock := &MockCECConnection{
+		SetActiveSourceFunc: func(deviceType int) bool { return false },
+	}
+	c := newTestCEC(mock, nil)
+	if c.SetActiveSource(CECDeviceTypePlayback) {
+		t.Error("Expected SetActiveSource to return false")
+	}
+}
+
+func TestMockCECConnection_CustomFunctions(t *testing.T) {
+	powerOnCalled, standbyCalled, closeCalled := false, false, false
+
+	mock := &MockCECConnection{
+		PowerOnFunc: func(address int) error { powerOnCalled = true; return nil },
+		StandbyFunc: func(address int) error { standbyCalled = true; return errors.New("standby failed") },
+		CloseFunc:   func() { closeCalled = true },
+	}
+
+	mock.PowerOn(5)
+	if !powerOnCalled {
+		t.Error("Expected custom PowerOnFunc to be called")
+	}
+	mock.Standby(6)
+	if !standbyCalled {
+		t.Error("Expected custom StandbyFunc to be called")
+	}
+	mock.Close()
+	if !closeCalled {
+		t.Error("Expected custom CloseFunc to be called")
+	}
+}
+
+func TestCECRetryLogic(t *testing.T) {
+	testCases := []struct{ input, expected int }{
+		{0, 1}, {-1, 1}, {1, 1}, {5, 5}, {10, 10},
+	}
+	for _, tc := range testCases {
+		mock := &MockCECConnection{}
+		c, err := newCECWithOpener("", "", tc.input, 1, make(chan *cec.KeyPress, 1),
+			func(string, string) (CECConnection, error) { return mock, nil })
+		if err != nil {
+			t.Fatalf("Input %d: unexpected error: %v", tc.input, err)
+		}
+		if c.retries != tc.expected {
+			t.Errorf("Input %d: expected retries %d, got %d", tc.input, tc.expected, c.retries)
+		}
+	}
+}
+
+func TestCECPower_SuccessOnFirstCall(t *testing.T) {
+	c := newTestCEC(&MockCECConnection{}, nil)
+	if err := c.PowerOn(0); err != nil {
+		t.Errorf("Expected success, got %v", err)
+	}
+}
+
+func TestCECPower_ReopenOnFirstCallFailure(t *testing.T) {
+	newMock := &MockCECConnection{}
+	mock := &MockCECConnection{
+		PowerOnFunc: func(address int) error { return errors.New("connection lost") },
+	}
+	c := newTestCEC(mock, func(string, string) (CECConnection, error) { return newMock, nil })
+
+	if err := c.PowerOn(0); err != nil {
+		t.Errorf("Expected success after reopen, got %v", err)
+	}
+	if len(newMock.PowerOnCalls) != 1 {
+		t.Errorf("Expected 1 call on new mock after reopen, got %d", len(newMock.PowerOnCalls))
+	}
+}
+
+func TestCECPower_FallbackAdapter(t *testing.T) {
+	var mu sync.Mutex
+	primaryUp := false
+	primary := &MockCECConnection{}
+	fallbackClosed := make(chan struct{})
+	fallback := &MockCECConnection{CloseFunc: func() { close(fallbackClosed) }}
+	lost := &MockCECConnection{PowerOnFunc: func(address int) error { return errors.New("connection lost") }}
+	c := newTestCEC(lost, func(adapter, deviceName string) (CECConnection, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case adapter == "/dev/ttyACM1":
+			return fallback, nil
+		case primaryUp:
+			return primary, nil
+		}
+		return nil, errors.New("adapter unplugged")
+	})
+	clock := newFakeClock()
+	c.clock = clock
+	c.SetFallbackAdapter("/dev/ttyACM1")
+
+	if err := c.PowerOn(0); err != nil {
+		t.Fatalf("Expected the command sent through the fallback adapter, got %v", err)
+	}
+	if len(fallback.PowerOnCalls) != 1 || !c.OnFallback() {
+		t.Fatalf("Expected the fallback adapter in use, got %v calls", fallback.PowerOnCalls)
+	}
+
+	// The primary is tried again in the background until it opens.
+	clock.waitForWaiters(t, 1)
+	clock.Advance(primaryRestoreInterval)
+	clock.waitForWaiters(t, 1)
+	if !c.OnFallback() {
+		t.Fatal("Expected to stay on the fallback adapter while the primary is unavailable")
+	}
+	mu.Lock()
+	primaryUp = true
+	mu.Unlock()
+	clock.Advance(primaryRestoreInterval)
+	select {
+	case <-fallbackClosed:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the fallback adapter closed once the primary is back")
+	}
+	if c.OnFallback() {
+		t.Error("Expected the primary adapter restored")
+	}
+	if err := c.PowerOn(0); err != nil || len(primary.PowerOnCalls) != 1 {
+		t.Errorf("Expected the command sent through the primary adapter, got %v and %v", err, primary.PowerOnCalls)
+	}
+}
+
+func TestCECClose_StopsPrimaryRestore(t *testing.T) {
+	lost := &MockCECConnection{PowerOnFunc: func(address int) error { return errors.New("connection lost") }}
+	c := newTestCEC(lost, func(adapter, deviceName string) (CECConnection, error) {
+		if adapter == "/dev/ttyACM1" {
+			return &MockCECConnection{}, nil
+		}
+		return nil, errors.New("adapter unplugged")
+	})
+	clock := newFakeClock()
+	c.clock = clock
+	c.SetFallbackAdapter("/dev/ttyACM1")
+
+	if err := c.PowerOn(0); err != nil || !c.OnFallback() {
+		t.Fatalf("Expected the fallback adapter in use, got %v", err)
+	}
+
+	// SetContext races the restore goroutine, which reads the context on
+	// every attempt.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			c.SetContext(context.Background())
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		clock.waitForWaiters(t, 1)
+		clock.Advance(primaryRestoreInterval)
+	}
+	<-done
+
+	closed := make(chan struct{})
+	go func() {
+		c.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Close to stop the primary restore without waiting for the next attempt")
+	}
+}
+
+func TestCECPower_ReopenShowsOSD(t *testing.T) {
+	newMock := &MockCECConnection{}
+	mock := &MockCECConnection{
+		PowerOnFunc: func(address int) error { return errors.New("connection lost") },
+	}
+	c := newTestCEC(mock, func(string, string) (CECConnection, error) { return newMock, nil })
+	c.SetReconnectOSD(true)
+
+	if err := c.PowerOn(0); err != nil {
+		t.Fatalf("Expected success after reopen, got %v", err)
+	}
+	// Set OSD String "Reconnected" to the TV.
+	want := "10:64:00:52:65:63:6F:6E:6E:65:63:74:65:64"
+	if len(newMock.Transmitted) != 1 || newMock.Transmitted[0] != want {
+		t.Errorf("Expected %s on the new connection, got %v", want, newMock.Transmitted)
+	}
+	if len(mock.Transmitted) != 0 {
+		t.Errorf("Expected nothing sent on the lost connection, got %v", mock.Transmitted)
+	}
+}
+
+func TestCECPower_ReopenOSDFromInitiator(t *testing.T) {
+	newMock := &MockCECConnection{}
+	mock := &MockCECConnection{
+		PowerOnFunc: func(address int) error { return errors.New("connection lost") },
+	}
+	c := newTestCEC(mock, func(string, string) (CECConnection, error) { return newMock, nil })
+	c.SetReconnectOSD(true)
+	c.SetInitiator(4)
+
+	if err := c.PowerOn(0); err != nil {
+		t.Fatalf("Expected success after reopen, got %v", err)
+	}
+	if len(newMock.Transmitted) != 1 || !strings.HasPrefix(newMock.Transmitted[0], "40:64:") {
+		t.Errorf("Expected the OSD string sent from address 4, got %v", newMock.Transmitted)
+	}
+}
+
+func TestCEC_OneTouchPlay(t *testing.T) {
+	mock := &MockCECConnection{ConnectionAliveFunc: func() bool { return true }}
+	c := newTestCEC(mock, nil)
+	c.SetActiveSourceType(CECDeviceTypeRecording)
+
+	if err := c.OneTouchPlay(); err != nil {
+		t.Fatalf("OneTouchPlay failed: %v", err)
+	}
+	// Image View On to the TV, then Active Source.
+	if len(mock.Transmitted) != 1 || mock.Transmitted[0] != "10:04" {
+		t.Errorf("Expected Image View On to the TV, got %v", mock.Transmitted)
+	}
+	if len(mock.SetActiveSourceCalls) != 1 || mock.SetActiveSourceCalls[0] != CECDeviceTypeRecording {
+		t.Errorf("Expected the active source claimed as a recording device, got %v", mock.SetActiveSourceCalls)
+	}
+
+	mock.SetActiveSourceFunc = func(int) bool { return false }
+	err := c.OneTouchPlay()
+	if !errors.Is(err, errCommandRejected) || powerFailureNeedsRestart(err) {
+		t.Errorf("Expected a rejected command, got %v", err)
+	}
+	if failed := failedAddresses(err); len(failed) != 1 || failed[0] != cecAddressTV {
+		t.Errorf("Expected the TV reported as failed, got %v", failed)
+	}
+}
+
+func TestCEC_OneTouchPlayReopens(t *testing.T) {
+	newMock := &MockCECConnection{}
+	c := newTestCEC(&MockCECConnection{}, func(string, string) (CECConnection, error) { return newMock, nil })
+
+	if err := c.OneTouchPlay(); err != nil {
+		t.Fatalf("OneTouchPlay failed: %v", err)
+	}
+	if len(newMock.Transmitted) != 1 || len(newMock.SetActiveSourceCalls) != 1 {
+		t.Errorf("Expected One Touch Play on the reopened connection, got %v and %v", newMock.Transmitted, newMock.SetActiveSourceCalls)
+	}
+
+	c = newTestCEC(&MockCECConnection{}, func(string, string) (CECConnection, error) { return nil, errors.New("reopen failed") })
+	if err := c.OneTouchPlay(); !errors.Is(err, errAdapterGone) {
+		t.Errorf("Expected errAdapterGone when reopen fails, got %v", err)
+	}
+}
+
+func TestCECPower_ReopenFails(t *testing.T) {
+	mock := &MockCECConnection{
+		PowerOnFunc: func(address int) error { return errors.New("connection lost") },
+	}
+	c := newTestCEC(mock, func(string, string) (CECConnection, error) {
+		return nil, errors.New("reopen failed")
+	})
+	err := c.PowerOn(0)
+	if !errors.Is(err, errAdapterGone) {
+		t.Errorf("Expected errAdapterGone when reopen fails, got %v", err)
+	}
+	if !powerFailureNeedsRestart(err) {
+		t.Error("Expected an unavailable adapter to need a restart")
+	}
+}
+
+func TestCECPower_ReopenStopsOnCancel(t *testing.T) {
+	mock := &MockCECConnection{
+		PowerOnFunc: func(address int) error { return errors.New("connection lost") },
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	c := newTestCEC(mock, func(string, string) (CECConnection, error) {
+		attempts++
+		cancel() // SIGTERM arrives during the first attempt
+		return nil, errors.New("reopen failed")
+	})
+	c.retries = 10
+	c.SetContext(ctx)
+
+	err := c.PowerOn(0, 1)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the error to wrap context.Canceled, got %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("Expected reopen to stop after 1 attempt, got %d", attempts)
+	}
+}
+
+func TestCEC_StandbyOnExit(t *testing.T) {
+	failures := 1
+	mock := &MockCECConnection{
+		StandbyFunc: func(address int) error {
+			if failures > 0 {
+				failures--
+				return errors.New("busy")
+			}
+			return nil
+		},
+		ConnectionAliveFunc: func() bool { return true },
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // SIGTERM received
+	c := newTestCEC(mock, nil)
+	c.commandRetries = 2
+	c.commandRetryDelay = time.Millisecond
+	c.SetContext(ctx)
+
+	// The retry still happens although the daemon context is done.
+	if err := c.StandbyOnExit(time.Second, 0); err != nil {
+		t.Errorf("Expected standby to succeed on retry, got %v", err)
+	}
+	if len(mock.StandbyCalls) != 2 {
+		t.Errorf("Expected 2 standby attempts, got %v", mock.StandbyCalls)
+	}
+}
+
+func TestCECPower_SecondCallFailsAfterReopen(t *testing.T) {
+	failingMock := &MockCECConnection{
+		PowerOnFunc: func(address int) error { return errors.New("still failing after reopen") },
+	}
+	mock := &MockCECConnection{
+		PowerOnFunc: func(address int) error { return errors.New("initial failure") },
+	}
+	c := newTestCEC(mock, func(string, string) (CECConnection, error) { return failingMock, nil })
+	err := c.PowerOn(0)
+	if !errors.Is(err, errConnectionLost) {
+		t.Errorf("Expected errConnectionLost when both calls fail, got %v", err)
+	}
+	if !powerFailureNeedsRestart(err) {
+		t.Error("Expected a lost connection to need a restart")
+	}
+}
+
+func TestCECStandby_SuccessOnFirstCall(t *testing.T) {
+	c := newTestCEC(&MockCECConnection{}, nil)
+	if err := c.Standby(0); err != nil {
+		t.Errorf("Expected success, got %v", err)
+	}
+}
+
+func TestCECPower_MultipleAddresses(t *testing.T) {
+	mock := &MockCECConnection{}
+	c := newTestCEC(mock, nil)
+	if err := c.PowerOn(0, 1, 2); err != nil {
+		t.Errorf("Expected success for multiple addresses, got %v", err)
+	}
+	if len(mock.PowerOnCalls) != 3 {
+		t.Errorf("Expected 3 PowerOn calls, got %d", len(mock.PowerOnCalls))
+	}
+}
+
+func TestCECPower_CommandGap(t *testing.T) {
+	mock := &MockCECConnection{}
+	c := newTestCEC(mock, nil)
+	clock := newFakeClock()
+	c.clock = clock
+	c.SetCommandGap(100 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() { done <- c.PowerOn(0, 1, 2) }()
+	for want := 1; want <= 2; want++ {
+		clock.waitForWaiters(t, 1)
+		if calls := len(mock.PowerOnCalls); calls != want {
+			t.Fatalf("Expected %d PowerOn call(s) before the gap, got %d", want, calls)
+		}
+		clock.Advance(100 * time.Millisecond)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Expected success, got %v", err)
+	}
+	if len(mock.PowerOnCalls) != 3 {
+		t.Errorf("Expected 3 PowerOn calls, got %v", mock.PowerOnCalls)
+	}
+}
+
+func TestCECPower_CommandGapStopsOnCancel(t *testing.T) {
+	mock := &MockCECConnection{}
+	c := newTestCEC(mock, nil)
+	c.clock = newFakeClock()
+	c.SetCommandGap(time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	c.SetContext(ctx)
+	cancel()
+
+	err := c.Standby(0, 1, 2)
+	if failed := failedAddresses(err); !reflect.DeepEqual(failed, []int{1, 2}) {
+		t.Errorf("Expected the addresses after the gap to fail, got %v (%v)", failed, err)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a cancellation error, got %v", err)
+	}
+}
+
+func TestCECConnectionWrapper_InterfaceCompliance(t *testing.T) {
+	var conn CECConnection = &MockCECConnection{}
+	if err := conn.PowerOn(0); err != nil {
+		t.Errorf("Expected nil from mock PowerOn, got %v", err)
+	}
+}
+
+func TestCECPower_PartialFailure(t *testing.T) {
+	failFive := func(address int) error {
+		if address == 5 {
+			return errors.New("rejected")
+		}
+		return nil
+	}
+	mock := &MockCECConnection{PowerOnFunc: failFive}
+	reopened := &MockCECConnection{PowerOnFunc: failFive}
+	c := newTestCEC(mock, func(string, string) (CECConnection, error) { return reopened, nil })
+
+	err := c.PowerOn(0, 5, 1)
+	if err == nil {
+		t.Fatal("Expected error when one address fails")
+	}
+	failed := failedAddresses(err)
+	if len(failed) != 1 || failed[0] != 5 {
+		t.Errorf("Expected failed addresses [5], got %v", failed)
+	}
+	if len(reopened.PowerOnCalls) != 2 || reopened.PowerOnCalls[0] != 5 || reopened.PowerOnCalls[1] != 1 {
+		t.Errorf("Expected remaining addresses to be attempted after failure, got %v", reopened.PowerOnCalls)
+	}
+}
+
+func TestCECPower_ReopenFailureFailsRemainingAddresses(t *testing.T) {
+	mock := &MockCECConnection{
+		PowerOnFunc: func(address int) error {
+			if address == 1 {
+				return errors.New("connection lost")
+			}
+			return nil
+		},
+	}
+	c := newTestCEC(mock, func(string, string) (CECConnection, error) {
+		return nil, errors.New("reopen failed")
+	})
+
+	failed := failedAddresses(c.PowerOn(0, 1, 2))
+	if len(failed) != 2 || failed[0] != 1 || failed[1] != 2 {
+		t.Errorf("Expected failed addresses [1 2], got %v", failed)
+	}
+}
+
+func TestFailedAddresses_Nil(t *testing.T) {
+	if failed := failedAddresses(nil); len(failed) != 0 {
+		t.Errorf("Expected no failed addresses for nil error, got %v", failed)
+	}
+}
+
+func TestCECPower_CommandRetriesWithoutReopen(t *testing.T) {
+	attempts := 0
+	mock := &MockCECConnection{
+		PowerOnFunc: func(address int) error {
+			attempts++
+			if attempts < 3 {
+				return errors.New("busy")
+			}
+			return nil
+		},
+		ConnectionAliveFunc: func() bool { return true },
+	}
+	reopened := false
+	c := newTestCEC(mock, func(string, string) (CECConnection, error) {
+		reopened = true
+		return &MockCECConnection{}, nil
+	})
+	c.commandRetries = 3
+
+	if err := c.PowerOn(0); err != nil {
+		t.Errorf("Expected success on third attempt, got %v", err)
+	}
+	if reopened {
+		t.Error("Expected no reopen when a retry succeeds")
+	}
+	if len(mock.PowerOnCalls) != 3 {
+		t.Errorf("Expected 3 PowerOn attempts, got %d", len(mock.PowerOnCalls))
+	}
+}
+
+func TestCECPower_RejectedCommandDoesNotReopen(t *testing.T) {
+	mock := &MockCECConnection{
+		PowerOnFunc:         func(address int) error { return errors.New("rejected") },
+		ConnectionAliveFunc: func() bool { return true },
+	}
+	reopened := false
+	c := newTestCEC(mock, func(string, string) (CECConnection, error) {
+		reopened = true
+		return &MockCECConnection{}, nil
+	})
+	c.commandRetries = 2
+
+	err := c.PowerOn(0)
+	if err == nil {
+		t.Fatal("Expected error when the device keeps rejecting the command")
+	}
+	if reopened {
+		t.Error("Expected no reopen while the adapter is alive")
+	}
+	if len(mock.PowerOnCalls) != 2 {
+		t.Errorf("Expected 2 attempts, got %d", len(mock.PowerOnCalls))
+	}
+	if failed := failedAddresses(err); len(failed) != 1 || failed[0] != 0 {
+		t.Errorf("Expected failed addresses [0], got %v", failed)
+	}
+	if !errors.Is(err, errCommandRejected) || powerFailureNeedsRestart(err) {
+		t.Errorf("Expected a rejected command not to need a restart, got %v", err)
+	}
+}
+
+func TestCECPower_ConfirmPowerStatus(t *testing.T) {
+	statuses := []string{"standby", "starting"}
+	mock := &MockCECConnection{
+		PowerStatusFunc: func(address int) string {
+			status := statuses[0]
+			statuses = statuses[1:]
+			return status
+		},
+		ConnectionAliveFunc: func() bool { return true },
+	}
+	c := newTestCEC(mock, nil)
+	c.commandRetries = 3
+	c.SetConfirmPower(true)
+
+	if err := c.PowerOn(0); err != nil {
+		t.Errorf("Expected success once the device reports starting, got %v", err)
+	}
+	if len(mock.PowerOnCalls) != 2 {
+		t.Errorf("Expected the command to be resent once, got %d attempts", len(mock.PowerOnCalls))
+	}
+}
+
+func TestCECPower_ConfirmPowerStatusFails(t *testing.T) {
+	mock := &MockCECConnection{
+		PowerStatusFunc:     func(address int) string { return "on" },
+		ConnectionAliveFunc: func() bool { return true },
+	}
+	reopened := false
+	c := newTestCEC(mock, func(string, string) (CECConnection, error) {
+		reopened = true
+		return &MockCECConnection{}, nil
+	})
+	c.commandRetries = 2
+	c.SetConfirmPower(true)
+
+	err := c.Standby(0)
+	if !errors.Is(err, errPowerNotConfirmed) {
+		t.Fatalf("Expected errPowerNotConfirmed, got %v", err)
+	}
+	if reopened {
+		t.Error("Expected no reopen while the adapter is alive")
+	}
+	if len(mock.StandbyCalls) != 2 {
+		t.Errorf("Expected 2 attempts, got %d", len(mock.StandbyCalls))
+	}
+}
+
+func TestCECPower_ConfirmPowerStatusCanceled(t *testing.T) {
+	statusRequests := 0
+	mock := &MockCECConnection{
+		PowerStatusFunc: func(address int) string {
+			statusRequests++
+			return "on"
+		},
+		ConnectionAliveFunc: func() bool { return true },
+	}
+	c := newTestCEC(mock, nil)
+	c.clock = newFakeClock()
+	c.commandRetries = 2
+	c.commandRetryDelay = time.Second
+	c.SetConfirmPower(true)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.SetContext(ctx)
+
+	err := c.Standby(0)
+	if !errors.Is(err, errPowerNotConfirmed) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the confirmation cut short by the context, got %v", err)
+	}
+	if errors.Is(err, errCommandRejected) {
+		t.Errorf("Expected an unconfirmed command not reported as rejected, got %v", err)
+	}
+	if len(mock.StandbyCalls) != 1 || statusRequests != 0 {
+		t.Errorf("Expected 1 attempt and no status request, got %d and %d", len(mock.StandbyCalls), statusRequests)
+	}
+}
+
+func TestCECPower_ConfirmPowerStatusUnknown(t *testing.T) {
+	mock := &MockCECConnection{ConnectionAliveFunc: func() bool { return true }}
+	c := newTestCEC(mock, nil)
+	c.commandRetries = 3
+	c.SetConfirmPower(true)
+
+	if err := c.Standby(0); err != nil {
+		t.Errorf("Expected devices without a power status to be trusted, got %v", err)
+	}
+	if len(mock.StandbyCalls) != 1 {
+		t.Errorf("Expected 1 attempt, got %d", len(mock.StandbyCalls))
+	}
+}
+
+func TestCECPower_LostConnectionReopensImmediately(t *testing.T) {
+	mock := &MockCECConnection{
+		PowerOnFunc: func(address int) error { return errors.New("connection lost") },
+	}
+	newMock := &MockCECConnection{}
+	c := newTestCEC(mock, func(string, string) (CECConnection, error) { return newMock, nil })
+	c.commandRetries = 3
+
+	if err := c.PowerOn(0); err != nil {
+		t.Errorf("Expected success after reopen, got %v", err)
+	}
+	if len(mock.PowerOnCalls) != 1 {
+		t.Errorf("Expected no retries on a dead adapter, got %d attempts", len(mock.PowerOnCalls))
+	}
+	if len(newMock.PowerOnCalls) != 1 {
+		t.Errorf("Expected 1 attempt after reopen, got %d", len(newMock.PowerOnCalls))
+	}
+}
+
+func TestCECWaitReady_BecomesReady(t *testing.T) {
+	pings := 0
+	mock := &MockCECConnection{
+		ConnectionAliveFunc: func() bool {
+			pings++
+			return pings >= 3
+		},
+	}
+	c := newTestCEC(mock, nil)
+
+	if !c.WaitReady(context.Background(), time.Second) {
+		t.Fatal("Expected WaitReady to succeed once the adapter answers")
+	}
+	if pings != 3 {
+		t.Errorf("Expected 3 pings, got %d", pings)
+	}
+}
+
+func TestCECWaitReady_Timeout(t *testing.T) {
+	c := newTestCEC(&MockCECConnection{}, nil)
+
+	start := time.Now()
+	if c.WaitReady(context.Background(), 250*time.Millisecond) {
+		t.Fatal("Expected WaitReady to time out when the adapter never answers")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("WaitReady took too long to give up: %s", elapsed)
+	}
+}
+
+func TestCECWaitReady_TimeoutFakeClock(t *testing.T) {
+	pings := 0
+	c := newTestCEC(&MockCECConnection{ConnectionAliveFunc: func() bool { pings++; return false }}, nil)
+	clock := newFakeClock()
+	c.clock = clock
+
+	done := make(chan bool)
+	go func() { done <- c.WaitReady(context.Background(), time.Second) }()
+	clock.waitForWaiters(t, 2)
+
+	// Polls every busReadyPollInterval until the timeout.
+	for i := 0; i < 10; i++ {
+		clock.Advance(busReadyPollInterval)
+	}
+	select {
+	case ready := <-done:
+		if ready {
+			t.Error("Expected WaitReady to time out")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected WaitReady to return once the timeout passed")
+	}
+	if pings < 2 {
+		t.Errorf("Expected the adapter to be polled while waiting, got %d pings", pings)
+	}
+}
+
+func TestFormatCommand(t *testing.T) {
+	if got := formatCommand(1, 0, 0x47, 'P', 'C'); got != "10:47:50:43" {
+		t.Errorf("Expected 10:47:50:43, got %s", got)
+	}
+	if got := formatCommand(1, 15, 0x36); got != "1F:36" {
+		t.Errorf("Expected 1F:36, got %s", got)
+	}
+}
+
+func TestCECSetOSDName(t *testing.T) {
+	mock := &MockCECConnection{}
+	c := newTestCEC(mock, nil)
+
+	if err := c.SetOSDName("Kodi"); err != nil {
+		t.Fatalf("SetOSDName failed: %v", err)
+	}
+	if len(mock.Transmitted) != 1 || mock.Transmitted[0] != "10:47:4B:6F:64:69" {
+		t.Errorf("Expected a Set OSD Name frame to the TV, got %v", mock.Transmitted)
+	}
+	if c.deviceName != "Kodi" {
+		t.Errorf("Expected the device name to be kept for reopens, got %q", c.deviceName)
+	}
+
+	// Sent from the address set with --cec-initiator.
+	c.SetInitiator(4)
+	if err := c.SetOSDName("Kodi"); err != nil {
+		t.Fatalf("SetOSDName failed: %v", err)
+	}
+	if got := mock.Transmitted[len(mock.Transmitted)-1]; got != "40:47:4B:6F:64:69" {
+		t.Errorf("Expected the frame sent from address 4, got %s", got)
+	}
+}
+
+func TestCECPowerCommands(t *testing.T) {
+	mock := &MockCECConnection{}
+	c := newTestCEC(mock, nil)
+	c.SetPowerCommands(map[int]string{0: powerCommandImageViewOn, 5: powerCommandUserPower})
+
+	if err := c.PowerOn(0, 4, 5); err != nil {
+		t.Fatalf("PowerOn failed: %v", err)
+	}
+	if err := c.Standby(0, 5); err != nil {
+		t.Fatalf("Standby failed: %v", err)
+	}
+
+	expected := []string{"10:04", "15:44:6D", "15:45", "15:44:6C", "15:45"}
+	if !reflect.DeepEqual(mock.Transmitted, expected) {
+		t.Errorf("Expected raw frames %v, got %v", expected, mock.Transmitted)
+	}
+	// Address 4 has no power command and standby has no Image View On
+	// counterpart: both go through libcec.
+	if !reflect.DeepEqual(mock.PowerOnCalls, []int{4}) || !reflect.DeepEqual(mock.StandbyCalls, []int{0}) {
+		t.Errorf("Expected libcec PowerOn for 4 and Standby for 0, got %v and %v", mock.PowerOnCalls, mock.StandbyCalls)
+	}
+}
+
+func TestCECPowerOnMethod(t *testing.T) {
+	mock := &MockCECConnection{}
+	c := newTestCEC(mock, nil)
+	c.SetPowerCommands(map[int]string{5: powerCommandDefault})
+	c.SetPowerOnMethod(powerCommandPowerOnImageViewOn)
+
+	if err := c.PowerOn(0, 5); err != nil {
+		t.Fatalf("PowerOn failed: %v", err)
+	}
+	if err := c.Standby(0); err != nil {
+		t.Fatalf("Standby failed: %v", err)
+	}
+
+	// Address 5 keeps its own power command.
+	if !reflect.DeepEqual(mock.Transmitted, []string{"10:04"}) {
+		t.Errorf("Expected Image View On for 0 only, got %v", mock.Transmitted)
+	}
+	if !reflect.DeepEqual(mock.PowerOnCalls, []int{0, 5}) || !reflect.DeepEqual(mock.StandbyCalls, []int{0}) {
+		t.Errorf("Expected libcec PowerOn for 0 and 5 and Standby for 0, got %v and %v", mock.PowerOnCalls, mock.StandbyCalls)
+	}
+}
+
+func TestCECSetInitiator(t *testing.T) {
+	mock := &MockCECConnection{}
+	c := newTestCEC(mock, nil)
+	c.SetPowerCommands(map[int]string{5: powerCommandImageViewOn})
+	c.SetInitiator(cecAddressTV)
+
+	if err := c.PowerOn(5); err != nil {
+		t.Fatalf("PowerOn failed: %v", err)
+	}
+	if err := c.SetMute(true); err != nil {
+		t.Fatalf("SetMute failed: %v", err)
+	}
+	expected := []string{"05:04", "05:44:65", "05:45"}
+	if !reflect.DeepEqual(mock.Transmitted, expected) {
+		t.Errorf("Expected frames from the TV address %v, got %v", expected, mock.Transmitted)
+	}
+}
+
+func TestParsePhysicalAddress(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected uint16
+		wantErr  bool
+	}{
+		{"2.0.0.0", 0x2000, false},
+		{"1.4.0.0", 0x1400, false},
+		{"f.0.0.1", 0xF001, false},
+		{"2.0.0", 0, true},
+		{"2.0.0.0.0", 0, true},
+		{"20.0.0.0", 0, true},
+		{"g.0.0.0", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		addr, err := parsePhysicalAddress(tt.input)
+		if (err != nil) != tt.wantErr || addr != tt.expected {
+			t.Errorf("parsePhysicalAddress(%q) = %#x, %v; expected %#x, error=%v", tt.input, addr, err, tt.expected, tt.wantErr)
+		}
+	}
+}
+
+func TestAnswerActiveSourceRequests(t *testing.T) {
+	mock := &MockCECConnection{}
+	commands := make(chan *cec.Command, 4)
+	out := make(chan *cec.Command, 4)
+	commands <- &cec.Command{OpcodeSet: 1, Opcode: 0x85, CommandString: "0F:85"}
+	commands <- &cec.Command{OpcodeSet: 1, Opcode: 0x89, CommandString: "01:89:91"}
+	close(commands)
+
+	answerActiveSourceRequests(commands, out, newTestCEC(mock, nil), CECDeviceTypePlayback)
+	if !reflect.DeepEqual(mock.SetActiveSourceCalls, []int{CECDeviceTypePlayback}) {
+		t.Errorf("Expected one active source claim, got %v", mock.SetActiveSourceCalls)
+	}
+	var forwarded []string
+	for cmd := range out {
+		forwarded = append(forwarded, cmd.CommandString)
+	}
+	if !reflect.DeepEqual(forwarded, []string{"0F:85", "01:89:91"}) {
+		t.Errorf("Expected every frame to be forwarded, got %v", forwarded)
+	}
+}
+
+func TestAnswerActiveSourceRequests_InStandby(t *testing.T) {
+	mock := &MockCECConnection{}
+	c := newTestCEC(mock, nil)
+	// After power:off-all, the TV switched on to watch another input.
+	if err := c.StandbyAll(); err != nil {
+		t.Fatalf("StandbyAll failed: %v", err)
+	}
+	commands := make(chan *cec.Command, 1)
+	out := make(chan *cec.Command, 1)
+	commands <- &cec.Command{OpcodeSet: 1, Opcode: 0x85, CommandString: "0F:85"}
+	close(commands)
+
+	answerActiveSourceRequests(commands, out, c, CECDeviceTypePlayback)
+	if len(mock.SetActiveSourceCalls) != 0 {
+		t.Errorf("Expected no active source claim in standby, got %v", mock.SetActiveSourceCalls)
+	}
+	if cmd := <-out; cmd == nil || cmd.CommandString != "0F:85" {
+		t.Errorf("Expected the request to be forwarded, got %v", cmd)
+	}
+}
+
+func TestCECSetStreamPath(t *testing.T) {
+	mock := &MockCECConnection{}
+	c := newTestCEC(mock, nil)
+
+	if err := c.SetStreamPath(0x2000); err != nil {
+		t.Fatalf("SetStreamPath failed: %v", err)
+	}
+	if !reflect.DeepEqual(mock.Transmitted, []string{"1F:86:20:00"}) {
+		t.Errorf("Expected a broadcast Set Stream Path, got %v", mock.Transmitted)
+	}
+}
+
+func TestCECStandbyAll(t *testing.T) {
+	mock := &MockCECConnection{}
+	c := newTestCEC(mock, nil)
+
+	if err := c.StandbyAll(); err != nil {
+		t.Fatalf("StandbyAll failed: %v", err)
+	}
+	if !reflect.DeepEqual(mock.StandbyCalls, []int{broadcastAddress}) {
+		t.Errorf("Expected a broadcast standby, got %v", mock.StandbyCalls)
+	}
+}
+
+func TestCECSetMute(t *testing.T) {
+	mock := &MockCECConnection{}
+	c := newTestCEC(mock, nil)
+
+	if err := c.SetMute(true); err != nil {
+		t.Fatalf("SetMute(true) failed: %v", err)
+	}
+	if err := c.SetMute(false); err != nil {
+		t.Fatalf("SetMute(false) failed: %v", err)
+	}
+	expected := []string{"15:44:65", "15:45", "15:44:66", "15:45"}
+	if !reflect.DeepEqual(mock.Transmitted, expected) {
+		t.Errorf("Expected %v, got %v", expected, mock.Transmitted)
+	}
+
+	c = newTestCEC(nil, nil)
+	if err := c.SetMute(true); !errors.Is(err, errNoConnection) {
+		t.Errorf("Expected errNoConnection without a connection, got %v", err)
+	}
+}
+
+func TestCECSetOSDName_Invalid(t *testing.T) {
+	mock := &MockCECConnection{}
+	c := newTestCEC(mock, nil)
+
+	for _, name := range []string{"", "A name that is too long", "Télé"} {
+		if err := c.SetOSDName(name); err == nil {
+			t.Errorf("Expected error for OSD name %q", name)
+		}
+	}
+	if len(mock.Transmitted) != 0 {
+		t.Errorf("Expected nothing transmitted for invalid names, got %v", mock.Transmitted)
+	}
+	if c.deviceName != "test" {
+		t.Errorf("Expected the device name to be unchanged, got %q", c.deviceName)
+	}
+}
+
+func TestCEC_KeepActiveSource(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	claimed := make(chan int, 4)
+	mock := &MockCECConnection{SetActiveSourceFunc: func(deviceType int) bool {
+		claimed <- deviceType
+		return true
+	}}
+	c := newTestCEC(mock, nil)
+	clock := newFakeClock()
+	c.clock = clock
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.KeepActiveSource(ctx, time.Minute, CECDeviceTypePlayback)
+	}()
+
+	clock.waitForWaiters(t, 1)
+	clock.Advance(time.Minute)
+	select {
+	case deviceType := <-claimed:
+		if deviceType != CECDeviceTypePlayback {
+			t.Errorf("Expected the playback device type claimed, got %d", deviceType)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected active source claimed after the interval")
+	}
+
+	// Devices in standby: the TV must not be switched back on.
+	c.Standby(0)
+	clock.Advance(time.Minute)
+	select {
+	case <-claimed:
+		t.Fatal("Expected no claim while devices are in standby")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	c.PowerOn(0)
+	clock.Advance(time.Minute)
+	select {
+	case <-claimed:
+	case <-time.After(time.Second):
+		t.Fatal("Expected claims to resume once devices are powered on")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the keepalive to stop with the context")
+	}
+}
@@ -0,0 +1,413 @@
+package cecctl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/claes/cec"
+)
+
+// CEC backends, selected with --cec-backend.
+const (
+	CECBackendLibcec    = "libcec"     // the libcec Go bindings
+	CECBackendCECClient = "cec-client" // a cec-client process driven over its stdin and stdout
+)
+
+// cecOpenerFor returns the function opening a connection with the given
+// backend. hdmiPort, when not 0, is the TV port the adapter is plugged into,
+// see --hdmi-port; only the cec-client backend can set it.
+func cecOpenerFor(backend string, hdmiPort int) (func(adapter, deviceName string) (CECConnection, error), error) {
+	switch backend {
+	case CECBackendLibcec, "":
+		return func(adapter, deviceName string) (CECConnection, error) {
+			conn, err := cec.Open(adapter, deviceName)
+			if err != nil {
+				return nil, err
+			}
+			return &CECConnectionWrapper{Connection: conn}, nil
+		}, nil
+	case CECBackendCECClient:
+		return func(adapter, deviceName string) (CECConnection, error) {
+			return openCECClient(adapter, deviceName, hdmiPort)
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown CEC backend %q", backend)
+	}
+}
+
+const (
+	// cecClientOpenTimeout bounds the wait for cec-client to open the adapter.
+	cecClientOpenTimeout = 15 * time.Second
+	// cecClientReplyTimeout bounds the wait for the answer to a command.
+	cecClientReplyTimeout = 3 * time.Second
+	// cecClientReady is printed by cec-client once the adapter is open.
+	cecClientReady = "waiting for input"
+	// maxHDMIPort is the highest port a physical address can hold.
+	maxHDMIPort = 15
+)
+
+// cecClientPowerStatuses maps the power status printed by cec-client to the
+// CECConnection.PowerStatus values.
+var cecClientPowerStatuses = map[string]string{
+	"on":                               "on",
+	"standby":                          "standby",
+	"in transition from standby to on": "starting",
+	"in transition from on to standby": "shutting down",
+}
+
+// cecClientConnection is a CECConnection backed by the cec-client binary, for
+// setups without the libcec development files. Commands are written to its
+// stdin and key presses are decoded from the traffic it logs on stdout.
+type cecClientConnection struct {
+	mu    sync.Mutex // serializes commands, so each reply reaches its caller
+	stdin io.WriteCloser
+	wait  func() error
+
+	// replies receives the stdout lines that are not CEC traffic.
+	replies chan string
+	done    chan struct{} // closed once stdout is closed, i.e. cec-client exited
+
+	chMu       sync.Mutex
+	keyPresses chan *cec.KeyPress
+	commands   chan *cec.Command
+	pressed    int       // key code of the last User Control Pressed, -1 after a release
+	pressedAt  time.Time // when pressed was received, for the release duration
+	address    int       // logical address libcec claimed, see sent
+}
+
+// openCECClient starts cec-client on the adapter, or the first one found
+// when adapter is empty, and waits until it is ready. A non-zero hdmiPort
+// replaces the physical address libcec detects with the one of that TV port.
+func openCECClient(adapter, deviceName string, hdmiPort int) (CECConnection, error) {
+	path, err := exec.LookPath("cec-client")
+	if err != nil {
+		return nil, fmt.Errorf("cec-client not found in PATH: %w", err)
+	}
+	cmd := exec.Command(path, cecClientArgs(adapter, deviceName, hdmiPort)...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start cec-client: %w", err)
+	}
+
+	// cec-client quits on "q"; kill it when it does not.
+	wait := func() error {
+		kill := time.AfterFunc(cecClientReplyTimeout, func() { cmd.Process.Kill() })
+		defer kill.Stop()
+		return cmd.Wait()
+	}
+	c := newCECClientConnection(stdin, stdout, wait)
+	if err := c.waitReady(cecClientOpenTimeout); err != nil {
+		cmd.Process.Kill()
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// cecClientArgs returns the cec-client arguments opening adapter as a
+// recording device named deviceName, like the libcec bindings do, so raw
+// frames sent from defaultInitiator come from an address this device owns.
+func cecClientArgs(adapter, deviceName string, hdmiPort int) []string {
+	// -d 8 logs the traffic, which carries the key presses.
+	args := []string{"-d", "8", "-t", "r", "-o", deviceName}
+	if hdmiPort != 0 {
+		// Port of the TV (base device 0), so the physical address is
+		// derived from it instead of detected.
+		args = append(args, "-b", "0", "-p", strconv.Itoa(hdmiPort))
+	}
+	if adapter != "" {
+		args = append(args, adapter)
+	}
+	return args
+}
+
+// newCECClientConnection drives a cec-client process through its stdin and
+// stdout. wait is called on Close to reap the process, it may be nil.
+func newCECClientConnection(stdin io.WriteCloser, stdout io.Reader, wait func() error) *cecClientConnection {
+	c := &cecClientConnection{
+		stdin:   stdin,
+		wait:    wait,
+		replies: make(chan string, 64),
+		done:    make(chan struct{}),
+		pressed: -1,
+		address: defaultInitiator,
+	}
+	go c.read(stdout)
+	return c
+}
+
+// read dispatches the output of cec-client until it exits.
+func (c *cecClientConnection) read(stdout io.Reader) {
+	defer close(c.done)
+	lines := bufio.NewScanner(stdout)
+	for lines.Scan() {
+		line := lines.Text()
+		if frame, received, ok := parseCECClientTraffic(line); ok {
+			if received {
+				c.dispatch(frame)
+			} else {
+				c.sent(frame)
+			}
+			continue
+		}
+		select {
+		case c.replies <- line:
+		default:
+			// Nobody is waiting for a reply: drop the oldest line.
+			select {
+			case <-c.replies:
+			default:
+			}
+			c.replies <- line
+		}
+	}
+}
+
+// parseCECClientTraffic extracts the frame of a cec-client traffic line such
+// as "TRAFFIC: [  1234]	>> 01:44:41". received is true for frames received
+// (">>") and false for the ones this device sent ("<<").
+func parseCECClientTraffic(line string) (frame []byte, received bool, ok bool) {
+	if !strings.HasPrefix(line, "TRAFFIC:") {
+		return nil, false, false
+	}
+	_, hexFrame, received := strings.Cut(line, ">>")
+	if !received {
+		if _, hexFrame, ok = strings.Cut(line, "<<"); !ok {
+			return nil, false, false
+		}
+	}
+	parts := strings.Split(strings.TrimSpace(hexFrame), ":")
+	frame = make([]byte, 0, len(parts))
+	for _, part := range parts {
+		b, err := strconv.ParseUint(part, 16, 8)
+		if err != nil {
+			return nil, false, false
+		}
+		frame = append(frame, byte(b))
+	}
+	return frame, received, len(frame) > 0
+}
+
+// sent learns the logical address libcec claimed from the Report Physical
+// Address it broadcasts once the address is allocated; the frames sent with
+// tx may use any initiator.
+func (c *cecClientConnection) sent(frame []byte) {
+	if len(frame) < 2 || frame[1] != 0x84 {
+		return
+	}
+	c.chMu.Lock()
+	defer c.chMu.Unlock()
+	c.address = int(frame[0] >> 4)
+}
+
+// dispatch delivers a received frame like libcec does: User Control
+// Pressed/Released sent to this device become key presses, and every frame
+// goes to the commands channel when one is set. A press of another key
+// releases the key held, as with libcec.
+func (c *cecClientConnection) dispatch(frame []byte) {
+	c.chMu.Lock()
+	keyPresses, commands := c.keyPresses, c.commands
+	var kps []*cec.KeyPress
+	// Keys the TV forwards to another device, e.g. volume keys to an
+	// amplifier, are not for us.
+	if len(frame) >= 2 && int(frame[0]&0x0F) == c.address {
+		switch frame[1] {
+		case 0x44: // User Control Pressed
+			if len(frame) >= 3 {
+				if c.pressed >= 0 && c.pressed != int(frame[2]) {
+					kps = append(kps, c.release())
+				}
+				c.pressed, c.pressedAt = int(frame[2]), time.Now()
+				kps = append(kps, &cec.KeyPress{KeyCode: c.pressed})
+			}
+		case 0x45: // User Control Released
+			if c.pressed >= 0 {
+				kps = append(kps, c.release())
+			}
+		}
+	}
+	c.chMu.Unlock()
+
+	if keyPresses != nil {
+		for _, kp := range kps {
+			keyPresses <- kp
+		}
+	}
+	if commands != nil {
+		commands <- cecClientCommand(frame)
+	}
+}
+
+// release returns the release of the key held, with its hold duration, and
+// forgets it; c.chMu must be held.
+func (c *cecClientConnection) release() *cec.KeyPress {
+	duration := int(time.Since(c.pressedAt).Milliseconds())
+	kp := &cec.KeyPress{KeyCode: c.pressed, Duration: max(duration, 1)}
+	c.pressed = -1
+	return kp
+}
+
+// cecClientCommand builds the cec.Command libcec would deliver for frame.
+func cecClientCommand(frame []byte) *cec.Command {
+	parts := make([]string, len(frame))
+	for i, b := range frame {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	cmd := &cec.Command{
+		Initiator:     uint32(frame[0] >> 4),
+		Destination:   uint32(frame[0] & 0xF),
+		CommandString: strings.Join(parts, ":"),
+	}
+	if len(frame) >= 2 {
+		cmd.OpcodeSet = 1
+		cmd.Opcode = int(frame[1])
+		cmd.Parameters.Size = len(frame) - 2
+	}
+	return cmd
+}
+
+// waitReady waits for cec-client to report that the adapter is open.
+func (c *cecClientConnection) waitReady(timeout time.Duration) error {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case line := <-c.replies:
+			if strings.Contains(line, cecClientReady) {
+				return nil
+			}
+			slog.Debug("cec-client output", "line", line)
+		case <-c.done:
+			return errors.New("cec-client exited before opening the adapter")
+		case <-deadline:
+			return fmt.Errorf("cec-client did not open the adapter within %s", timeout)
+		}
+	}
+}
+
+// send writes a command to cec-client; c.mu must be held.
+func (c *cecClientConnection) send(command string) error {
+	select {
+	case <-c.done:
+		return errors.New("cec-client is not running")
+	default:
+	}
+	slog.Debug("Sending cec-client command", "command", command)
+	if _, err := io.WriteString(c.stdin, command+"\n"); err != nil {
+		return fmt.Errorf("failed to write to cec-client: %w", err)
+	}
+	return nil
+}
+
+func (c *cecClientConnection) command(command string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.send(command)
+}
+
+func (c *cecClientConnection) PowerOn(address int) error {
+	return c.command(fmt.Sprintf("on %X", address))
+}
+
+func (c *cecClientConnection) Standby(address int) error {
+	return c.command(fmt.Sprintf("standby %X", address))
+}
+
+// SetActiveSource claims the active source as the device cec-client
+// registered; cec-client has a single device type, so deviceType is unused.
+func (c *cecClientConnection) SetActiveSource(deviceType int) bool {
+	return c.command("as") == nil
+}
+
+func (c *cecClientConnection) VolumeUp() error {
+	return c.command("volup")
+}
+
+func (c *cecClientConnection) VolumeDown() error {
+	return c.command("voldown")
+}
+
+func (c *cecClientConnection) Mute() error {
+	return c.command("mute")
+}
+
+func (c *cecClientConnection) Transmit(command string) {
+	if err := c.command("tx " + command); err != nil {
+		slog.Warn("Failed to transmit CEC frame", "command", command, "error", err)
+	}
+}
+
+func (c *cecClientConnection) SetKeyPressesChan(ch chan *cec.KeyPress) {
+	c.chMu.Lock()
+	defer c.chMu.Unlock()
+	c.keyPresses = ch
+}
+
+func (c *cecClientConnection) SetCommandsChan(ch chan *cec.Command) {
+	c.chMu.Lock()
+	defer c.chMu.Unlock()
+	c.commands = ch
+}
+
+// ConnectionAlive reports whether cec-client is still running; it exits when
+// it loses the adapter.
+func (c *cecClientConnection) ConnectionAlive() bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (c *cecClientConnection) PowerStatus(address int) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Drop the output printed since the last command.
+	for len(c.replies) > 0 {
+		<-c.replies
+	}
+	if err := c.send(fmt.Sprintf("pow %X", address)); err != nil {
+		return ""
+	}
+	deadline := time.After(cecClientReplyTimeout)
+	for {
+		select {
+		case line := <-c.replies:
+			if _, status, ok := strings.Cut(line, "power status:"); ok {
+				return cecClientPowerStatuses[strings.TrimSpace(status)]
+			}
+		case <-c.done:
+			return ""
+		case <-deadline:
+			return ""
+		}
+	}
+}
+
+// Close asks cec-client to quit and waits for it to exit.
+func (c *cecClientConnection) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.send("q")
+	c.stdin.Close()
+	if c.wait != nil {
+		if err := c.wait(); err != nil {
+			slog.Debug("cec-client exited", "error", err)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package cecctl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/claes/cec"
+)
+
+// fakeCECClient stands in for a cec-client process: lines written to out are
+// its stdout, and the commands it receives are read from in.
+type fakeCECClient struct {
+	out *io.PipeWriter
+	in  *bufio.Scanner
+}
+
+func newFakeCECClient(t *testing.T) (*cecClientConnection, *fakeCECClient) {
+	t.Helper()
+	stdinR, stdinW := io.Pipe()
+	stdoutR, stdoutW := io.Pipe()
+	conn := newCECClientConnection(stdinW, stdoutR, nil)
+	fake := &fakeCECClient{out: stdoutW, in: bufio.NewScanner(stdinR)}
+	t.Cleanup(func() {
+		stdoutW.Close()
+		stdinR.Close()
+	})
+	return conn, fake
+}
+
+func (f *fakeCECClient) print(t *testing.T, line string) {
+	t.Helper()
+	if _, err := fmt.Fprintln(f.out, line); err != nil {
+		t.Fatalf("Failed to write cec-client output: %v", err)
+	}
+}
+
+func (f *fakeCECClient) expect(t *testing.T, want string) {
+	t.Helper()
+	if !f.in.Scan() {
+		t.Fatalf("Expected command %q, cec-client input closed", want)
+	}
+	if got := f.in.Text(); got != want {
+		t.Fatalf("Expected command %q, got %q", want, got)
+	}
+}
+
+func TestParseCECClientTraffic(t *testing.T) {
+	tests := []struct {
+		line     string
+		want     []byte
+		received bool
+	}{
+		{"TRAFFIC: [          431]\t>> 01:44:41", []byte{0x01, 0x44, 0x41}, true},
+		{"TRAFFIC: [          431]\t>> 0f:85", []byte{0x0F, 0x85}, true},
+		{"TRAFFIC: [          431]\t<< 10:04", []byte{0x10, 0x04}, false},
+		{"DEBUG:   [          431]\t>> TV (0) -> Playback 1 (4): user control pressed (44)", nil, false},
+		{"power status: on", nil, false},
+	}
+	for _, tt := range tests {
+		got, received, ok := parseCECClientTraffic(tt.line)
+		if ok != (tt.want != nil) || string(got) != string(tt.want) || received != tt.received {
+			t.Errorf("parseCECClientTraffic(%q) = %v, %v, %v; expected %v, %v", tt.line, got, received, ok, tt.want, tt.received)
+		}
+	}
+}
+
+func TestCECClientConnection_Keys(t *testing.T) {
+	conn, fake := newFakeCECClient(t)
+	keyPresses := make(chan *cec.KeyPress, 2)
+	commands := make(chan *cec.Command, 2)
+	conn.SetKeyPressesChan(keyPresses)
+	conn.SetCommandsChan(commands)
+
+	fake.print(t, "TRAFFIC: [  100]\t>> 01:44:41")
+	fake.print(t, "TRAFFIC: [  200]\t>> 01:45")
+	for _, wantRelease := range []bool{false, true} {
+		select {
+		case kp := <-keyPresses:
+			if kp.KeyCode != 0x41 || (kp.Duration != 0) != wantRelease {
+				t.Errorf("Unexpected key press %+v (release %v)", kp, wantRelease)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timeout waiting for a key press")
+		}
+	}
+	cmd := <-commands
+	if cmd.OpcodeSet != 1 || cmd.Opcode != 0x44 || cmd.CommandString != "01:44:41" || cmd.Parameters.Size != 1 {
+		t.Errorf("Unexpected command %+v", cmd)
+	}
+}
+
+func TestCECClientConnection_PressReleasesHeldKey(t *testing.T) {
+	conn, fake := newFakeCECClient(t)
+	keyPresses := make(chan *cec.KeyPress, 8)
+	conn.SetKeyPressesChan(keyPresses)
+
+	// Blue held, repeated, then Up pressed without Blue's release.
+	fake.print(t, "TRAFFIC: [  100]\t>> 01:44:71")
+	fake.print(t, "TRAFFIC: [  150]\t>> 01:44:71")
+	fake.print(t, "TRAFFIC: [  200]\t>> 01:44:01")
+	fake.print(t, "TRAFFIC: [  300]\t>> 01:45")
+	want := []struct {
+		code    int
+		release bool
+	}{{0x71, false}, {0x71, false}, {0x71, true}, {0x01, false}, {0x01, true}}
+	for _, w := range want {
+		select {
+		case kp := <-keyPresses:
+			if kp.KeyCode != w.code || (kp.Duration != 0) != w.release {
+				t.Errorf("Expected key 0x%02X (release %v), got %+v", w.code, w.release, kp)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timeout waiting for a key press")
+		}
+	}
+}
+
+func TestCECClientConnection_KeysForOtherDevices(t *testing.T) {
+	conn, fake := newFakeCECClient(t)
+	keyPresses := make(chan *cec.KeyPress, 4)
+	conn.SetKeyPressesChan(keyPresses)
+
+	// The TV forwarding a volume key to the amplifier.
+	fake.print(t, "TRAFFIC: [  100]\t>> 05:44:41")
+	fake.print(t, "TRAFFIC: [  110]\t>> 05:45")
+	// libcec claimed address 2, e.g. because 1 was taken.
+	fake.print(t, "TRAFFIC: [  150]\t<< 2f:84:20:00:01")
+	fake.print(t, "TRAFFIC: [  200]\t>> 01:44:00")
+	fake.print(t, "TRAFFIC: [  300]\t>> 02:44:01")
+	select {
+	case kp := <-keyPresses:
+		if kp.KeyCode != 0x01 || kp.Duration != 0 {
+			t.Errorf("Expected only the press sent to address 2, got %+v", kp)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for a key press")
+	}
+	select {
+	case kp := <-keyPresses:
+		t.Errorf("Unexpected key press %+v", kp)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestCECClientConnection_Commands(t *testing.T) {
+	conn, fake := newFakeCECClient(t)
+
+	go conn.PowerOn(0)
+	fake.expect(t, "on 0")
+	go conn.Standby(5)
+	fake.expect(t, "standby 5")
+	go conn.Transmit("10:04")
+	fake.expect(t, "tx 10:04")
+
+	status := make(chan string)
+	go func() { status <- conn.PowerStatus(0) }()
+	fake.expect(t, "pow 0")
+	fake.print(t, "power status: in transition from standby to on")
+	if got := <-status; got != "starting" {
+		t.Errorf("Expected power status %q, got %q", "starting", got)
+	}
+
+	if !conn.ConnectionAlive() {
+		t.Error("Expected the connection alive while cec-client runs")
+	}
+	fake.out.Close()
+	<-conn.done
+	if conn.ConnectionAlive() {
+		t.Error("Expected the connection dead once cec-client exited")
+	}
+	if err := conn.PowerOn(0); err == nil {
+		t.Error("Expected an error once cec-client exited")
+	}
+}
+
+func TestCECClientConnection_WaitReady(t *testing.T) {
+	conn, fake := newFakeCECClient(t)
+	go fmt.Fprint(fake.out, "opening a connection to the CEC adapter...\nwaiting for input\n")
+	if err := conn.waitReady(time.Second); err != nil {
+		t.Errorf("waitReady failed: %v", err)
+	}
+
+	conn, fake = newFakeCECClient(t)
+	fake.out.Close()
+	if err := conn.waitReady(time.Second); err == nil {
+		t.Error("Expected an error when cec-client exits")
+	}
+}
+
+func TestCECClientArgs(t *testing.T) {
+	got := cecClientArgs("/dev/ttyACM0", "HTPC", 0)
+	if want := []string{"-d", "8", "-t", "r", "-o", "HTPC", "/dev/ttyACM0"}; !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	// The HDMI port sets the physical address, relative to the TV.
+	got = cecClientArgs("", "HTPC", 2)
+	if want := []string{"-d", "8", "-t", "r", "-o", "HTPC", "-b", "0", "-p", "2"}; !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,146 @@
+package cecctl
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock that only moves when Advance is called. Timers and
+// tickers fire from Advance, so timing logic can be tested without sleeping.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+type fakeWaiter struct {
+	clock   *fakeClock
+	at      time.Time
+	period  time.Duration // 0 for a one-shot timer
+	ch      chan time.Time
+	stopped bool
+}
+
+func (w *fakeWaiter) C() <-chan time.Time { return w.ch }
+
+func (w *fakeWaiter) Stop() {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+	w.stopped = true
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1_000_000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	return c.add(d, 0).ch
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) Ticker {
+	return c.add(d, d)
+}
+
+func (c *fakeClock) add(d, period time.Duration) *fakeWaiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := &fakeWaiter{clock: c, at: c.now.Add(d), period: period, ch: make(chan time.Time, 1)}
+	if d <= 0 {
+		w.ch <- c.now
+		return w
+	}
+	c.waiters = append(c.waiters, w)
+	return w
+}
+
+// Advance moves the clock forward and fires every timer and ticker due. Like
+// time.Ticker, a ticker drops ticks its reader is not keeping up with.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.stopped {
+			continue
+		}
+		if !w.at.After(c.now) {
+			select {
+			case w.ch <- c.now:
+			default:
+			}
+			if w.period == 0 {
+				continue
+			}
+			for !w.at.After(c.now) {
+				w.at = w.at.Add(w.period)
+			}
+		}
+		pending = append(pending, w)
+	}
+	c.waiters = pending
+}
+
+// waitForWaiters blocks until n timers or tickers are pending, i.e. until
+// the goroutine under test is waiting on the clock.
+func (c *fakeClock) waitForWaiters(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		c.mu.Lock()
+		count := 0
+		for _, w := range c.waiters {
+			if !w.stopped {
+				count++
+			}
+		}
+		c.mu.Unlock()
+		if count >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timeout waiting for %d pending timers, got %d", n, count)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	c := newFakeClock()
+	start := c.Now()
+	timer := c.After(time.Second)
+	ticker := c.NewTicker(400 * time.Millisecond)
+
+	c.Advance(500 * time.Millisecond)
+	select {
+	case <-timer:
+		t.Fatal("Expected the timer not to fire before its deadline")
+	default:
+	}
+	select {
+	case <-ticker.C():
+	default:
+		t.Fatal("Expected the ticker to fire after one period")
+	}
+
+	c.Advance(500 * time.Millisecond)
+	if got := <-timer; !got.Equal(start.Add(time.Second)) {
+		t.Errorf("Expected the timer to fire at %v, got %v", start.Add(time.Second), got)
+	}
+	<-ticker.C()
+
+	ticker.Stop()
+	c.Advance(time.Second)
+	select {
+	case <-ticker.C():
+		t.Error("Expected a stopped ticker not to fire")
+	default:
+	}
+}
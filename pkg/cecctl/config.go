@@ -0,0 +1,907 @@
+package cecctl
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	configFilePath       = "/etc/cec-controller.yaml"
+	queueDirEnvVar       = "CEC_QUEUE_DIR"
+	restartRetriesEnvVar = "CEC_RESTART_RETRIES"
+)
+
+// minMaxIdleRestart is the shortest --max-idle-restart accepted; the idle
+// watchdog checks a quarter of it.
+const minMaxIdleRestart = time.Second
+
+// loadConfig loads configuration from file and environment variables.
+// CLI flags take precedence over config file, which takes precedence over defaults.
+func loadConfig() (*Config, error) {
+	cfg := &Config{}
+
+	viper.SetConfigFile(configFilePath)
+	viper.SetConfigType("yaml")
+
+	if err := viper.ReadInConfig(); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("Error reading config file", "path", configFilePath, "error", err)
+		}
+	}
+
+	cfg.CECAdapter = viper.GetString("cec-adapter")
+	cfg.CECFallbackAdapter = viper.GetString("cec-fallback-adapter")
+	cfg.HDMIPort = viper.GetInt("hdmi-port")
+	cfg.CECBackend = strings.ToLower(viper.GetString("cec-backend"))
+	cfg.DeviceName = viper.GetString("device-name")
+	cfg.Debug = viper.GetBool("debug")
+	cfg.LogLevel = strings.ToLower(viper.GetString("log-level"))
+	cfg.LogFile = viper.GetString("log-file")
+	cfg.LogSyslog = viper.GetBool("log-syslog")
+	cfg.WatchConfig = viper.GetBool("watch-config")
+	cfg.NoPowerEvents = viper.GetBool("no-power-events")
+	cfg.NoSleepEvents = viper.GetBool("no-sleep-events")
+	cfg.NoResumeEvents = viper.GetBool("no-resume-events")
+	cfg.NoShutdownEvents = viper.GetBool("no-shutdown-events")
+	cfg.StandbyAllOnShutdown = viper.GetBool("standby-all-on-shutdown")
+	cfg.StandbyOnExit = viper.GetBool("standby-on-exit")
+	cfg.UnknownPowerEvents = viper.GetString("unknown-power-events")
+	cfg.QuietHours = viper.GetString("quiet-hours")
+	cfg.ResumeInput = viper.GetString("resume-input")
+	cfg.ResumeOneTouchPlay = viper.GetBool("resume-one-touch-play")
+	cfg.ConnectionRetries = viper.GetInt("retries")
+	cfg.PowerCommandRetries = viper.GetInt("power-command-retries")
+	cfg.ReconnectOSD = viper.GetBool("reconnect-osd")
+	cfg.ConfirmPower = viper.GetBool("confirm-power")
+	cfg.CommandGap = time.Duration(viper.GetInt("cec-command-gap-ms")) * time.Millisecond
+	cfg.SetActiveSource = viper.GetBool("set-active-source")
+	cfg.ActiveSourceKeepalive = viper.GetDuration("active-source-keepalive")
+	cfg.ActiveSourceDeviceType = viper.GetInt("active-source-type")
+	cfg.ClaimActiveSource = viper.GetBool("claim-active-source")
+	cfg.CECInitiator = viper.GetInt("cec-initiator")
+	cfg.DBusAddress = viper.GetString("dbus-address")
+	cfg.ControlSocket = viper.GetString("control-socket")
+	cfg.TVSpeakers = viper.GetBool("tv-speakers")
+	cfg.VolumeAccel = viper.GetBool("volume-accel")
+	cfg.KeyBackend = viper.GetString("key-backend")
+	cfg.TargetTTY = viper.GetString("target-tty")
+	cfg.RemotePreset = viper.GetString("remote-preset")
+	cfg.NumberMode = viper.GetString("number-mode")
+	cfg.AllowNoKeyboard = viper.GetBool("allow-no-keyboard")
+
+	// Handle keymap overrides
+	if keyMapConfig := viper.Get("keymap"); keyMapConfig != nil {
+		switch v := keyMapConfig.(type) {
+		case map[string]interface{}:
+			cfg.KeyMapOverrides = parseKeyMapFromMap(v)
+		case []interface{}:
+			var keyMapArgs []string
+			for _, item := range v {
+				if str, ok := item.(string); ok {
+					keyMapArgs = append(keyMapArgs, str)
+				}
+			}
+			cfg.KeyMapOverrides = parseKeyMapFlags(keyMapArgs)
+		case []string:
+			cfg.KeyMapOverrides = parseKeyMapFlags(v)
+		}
+	}
+
+	// Handle the keymap file: its mappings apply under the inline keymap
+	cfg.KeyMapFile = viper.GetString("keymap-file")
+	var keyMapFile *keyMapFile
+	if cfg.KeyMapFile != "" {
+		var err error
+		keyMapFile, err = loadKeyMapFile(cfg.KeyMapFile)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			slog.Warn("Keymap file not found, ignoring it", "path", cfg.KeyMapFile)
+		case err != nil:
+			return nil, fmt.Errorf("reading keymap file %s: %w", cfg.KeyMapFile, err)
+		default:
+			cfg.KeyMapOverrides = mergeKeyMaps(keyMapFile.KeyMap, cfg.KeyMapOverrides)
+		}
+	}
+
+	// Handle keymap profiles: a map of profile name to keymap overrides
+	if profilesConfig, ok := viper.Get("keymap-profiles").(map[string]interface{}); ok {
+		cfg.KeyMapProfiles = make(map[string]map[string][]int, len(profilesConfig))
+		for name, profile := range profilesConfig {
+			profileMap, ok := profile.(map[string]interface{})
+			if !ok {
+				slog.Warn("Invalid keymap profile, expected a map", "profile", name)
+				continue
+			}
+			cfg.KeyMapProfiles[name] = parseKeyMapFromMap(profileMap)
+		}
+	}
+	cfg.KeyMapProfile = viper.GetString("keymap-profile")
+
+	// Handle key actions: a map of CEC key name to action
+	if actionsConfig := viper.Get("key-actions"); actionsConfig != nil {
+		switch v := actionsConfig.(type) {
+		case map[string]interface{}:
+			cfg.KeyActions = parseKeyActionsFromMap(v)
+		case []interface{}:
+			var actionArgs []string
+			for _, item := range v {
+				if str, ok := item.(string); ok {
+					actionArgs = append(actionArgs, str)
+				}
+			}
+			cfg.KeyActions = parseKeyActionFlags(actionArgs)
+		case []string:
+			cfg.KeyActions = parseKeyActionFlags(v)
+		}
+	}
+	// Profiles and actions of a grouped keymap file, under the configured ones.
+	if keyMapFile != nil {
+		for name, fileProfile := range keyMapFile.Profiles {
+			if cfg.KeyMapProfiles == nil {
+				cfg.KeyMapProfiles = make(map[string]map[string][]int, len(keyMapFile.Profiles))
+			}
+			cfg.KeyMapProfiles[name] = mergeKeyMaps(fileProfile, cfg.KeyMapProfiles[name])
+		}
+		if len(keyMapFile.Actions) > 0 {
+			cfg.KeyActions = mergeKeyActions(keyMapFile.Actions, cfg.KeyActions)
+		}
+	}
+	// Handle the shift layer: a modifier key, and the mappings and actions
+	// used while it is held
+	if layerConfig, ok := viper.Get("layer-shift").(map[string]interface{}); ok && len(layerConfig) > 0 {
+		cfg.LayerShift = &ShiftLayer{}
+		if key, ok := layerConfig["key"]; ok {
+			cfg.LayerShift.Key = fmt.Sprint(key)
+		}
+		if keyMapConfig, ok := layerConfig["keymap"].(map[string]interface{}); ok {
+			cfg.LayerShift.KeyMap = parseKeyMapFromMap(keyMapConfig)
+		}
+		if actionsConfig, ok := layerConfig["actions"].(map[string]interface{}); ok {
+			cfg.LayerShift.Actions = parseKeyActionsFromMap(actionsConfig)
+		}
+	}
+	cfg.IgnoreKeys = parseCECKeys(viper.GetStringSlice("ignore-keys"))
+	cfg.AllowKeys = parseCECKeys(viper.GetStringSlice("allow-keys"))
+	cfg.UnmappedWarnInterval = viper.GetDuration("unmapped-warn-interval")
+	cfg.DoublePressWindow = viper.GetDuration("double-press-window")
+	cfg.ScrollAmount = viper.GetInt("scroll-amount")
+	cfg.LogKeys = viper.GetBool("log-keys")
+	cfg.EventHistorySize = viper.GetInt("event-history-size")
+	cfg.MaxIdleRestart = viper.GetDuration("max-idle-restart")
+	cfg.OnReady = viper.GetString("on-ready")
+	cfg.OnReadyTimeout = viper.GetDuration("on-ready-timeout")
+	cfg.StartupEvent = strings.ToLower(viper.GetString("startup-event"))
+	if cfg.StartupEvent == "" {
+		cfg.StartupEvent = StartupEventOn
+	}
+	cfg.StartupSettle = time.Duration(viper.GetInt("startup-settle-ms")) * time.Millisecond
+
+	// Handle power devices
+	if devicesConfig := viper.Get("devices"); devicesConfig != nil {
+		switch v := devicesConfig.(type) {
+		case []interface{}:
+			var deviceStrs []string
+			for _, item := range v {
+				switch val := item.(type) {
+				case string:
+					deviceStrs = append(deviceStrs, val)
+				case int:
+					deviceStrs = append(deviceStrs, strconv.Itoa(val))
+				case int64:
+					deviceStrs = append(deviceStrs, strconv.FormatInt(val, 10))
+				}
+			}
+			cfg.PowerDevices = parseDevices(deviceStrs)
+		case []string:
+			cfg.PowerDevices = parseDevices(v)
+		case string:
+			cfg.PowerDevices = parseDevices([]string{v})
+		}
+	}
+
+	// Handle device aliases and per-device power commands
+	cfg.DeviceAliases = addressMapFromConfig("device-aliases", "device alias")
+	cfg.PowerCommands = addressMapFromConfig("power-commands", "power command")
+	cfg.PowerOnMethod = strings.ToLower(viper.GetString("power-on-method"))
+	for addr, command := range cfg.PowerCommands {
+		cfg.PowerCommands[addr] = strings.ToLower(command)
+	}
+
+	// Queue directory: env var takes precedence (set by RestartProcess)
+	if cfg.QueueDir = os.Getenv(queueDirEnvVar); cfg.QueueDir == "" {
+		cfg.QueueDir = viper.GetString("queue-dir")
+	}
+
+	// A restarted process reuses the queue of the previous one: recover from a
+	// corrupt store automatically, otherwise it would restart in a loop.
+	cfg.RecoverQueue = viper.GetBool("recover-queue") || os.Getenv(queueDirEnvVar) != ""
+	cfg.KeepQueue = viper.GetBool("keep-queue")
+	cfg.QueueDeleteOnRestart = viper.GetBool("queue-delete-on-restart")
+
+	// Restart retries: env var takes precedence (decremented by previous process on restart)
+	if retriesStr := os.Getenv(restartRetriesEnvVar); retriesStr != "" {
+		if retries, err := strconv.Atoi(retriesStr); err == nil {
+			cfg.RestartRetries = retries
+		} else {
+			slog.Warn("Invalid CEC_RESTART_RETRIES value", "value", retriesStr, "error", err)
+			cfg.RestartRetries = viper.GetInt("restart-retries")
+		}
+	} else {
+		cfg.RestartRetries = viper.GetInt("restart-retries")
+	}
+
+	// Apply defaults for unset values
+	if cfg.ConnectionRetries == 0 {
+		cfg.ConnectionRetries = 5
+	}
+	if cfg.Debug {
+		cfg.LogLevel = "debug"
+	}
+	if cfg.LogLevel == "" {
+		cfg.LogLevel = "info"
+	}
+	if cfg.KeyBackend == "" {
+		cfg.KeyBackend = KeyBackendUinput
+	}
+	if cfg.CECBackend == "" {
+		cfg.CECBackend = CECBackendLibcec
+	}
+	if cfg.RemotePreset == "" {
+		cfg.RemotePreset = RemotePresetDesktop
+	}
+	if cfg.NumberMode == "" {
+		cfg.NumberMode = NumberModeDirect
+	}
+	// 0 is the unset value, see the scroll-amount flag.
+	if cfg.ScrollAmount == 0 {
+		cfg.ScrollAmount = defaultScrollAmount
+	}
+	if cfg.UnknownPowerEvents == "" {
+		cfg.UnknownPowerEvents = UnknownPowerEventsIgnore
+	}
+	if cfg.PowerCommandRetries == 0 {
+		cfg.PowerCommandRetries = 1
+	}
+	if cfg.DeviceName == "" {
+		cfg.DeviceName, _ = os.Hostname()
+	}
+	if len(cfg.PowerDevices) == 0 && !cfg.NoPowerEvents {
+		cfg.PowerDevices = []int{0}
+	}
+	if cfg.NoPowerEvents || len(cfg.PowerDevices) == 0 {
+		cfg.NoPowerEvents = true
+	}
+	if cfg.QueueDir == "" {
+		var err error
+		if cfg.QueueDir, err = defaultQueueDir(); err != nil {
+			return nil, err
+		}
+		if cfg.KeepQueue && filepath.Dir(cfg.QueueDir) == filepath.Clean(os.TempDir()) {
+			slog.Warn("keep-queue without queue-dir leaves a new temporary directory behind on every run", "dir", cfg.QueueDir)
+		}
+	}
+	if cfg.RestartRetries == 0 {
+		cfg.RestartRetries = 3
+	}
+	if cfg.ActiveSourceDeviceType == 0 {
+		cfg.ActiveSourceDeviceType = CECDeviceTypePlayback
+	}
+
+	return cfg, nil
+}
+
+// defaultQueueDir creates the queue directory used when none is configured:
+// $XDG_RUNTIME_DIR/cec-controller when XDG_RUNTIME_DIR is set, so the queue
+// lives with the rest of the runtime state, or else a new temporary
+// directory.
+func defaultQueueDir() (string, error) {
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		dir := filepath.Join(runtimeDir, "cec-controller")
+		err := os.MkdirAll(dir, 0o700)
+		if err == nil {
+			return dir, nil
+		}
+		slog.Warn("Cannot create queue directory in XDG_RUNTIME_DIR, using a temporary directory", "dir", dir, "error", err)
+	}
+	return os.MkdirTemp("", "cec-queue-*")
+}
+
+// discardTempQueueDir removes the default queue directory loadConfig creates
+// when none is configured, for commands that never open the queue. A queue
+// left there by a running daemon is not empty and is kept.
+func discardTempQueueDir(cfg *Config) {
+	if os.Getenv(queueDirEnvVar) != "" || viper.GetString("queue-dir") != "" {
+		return
+	}
+	if err := os.Remove(cfg.QueueDir); err != nil {
+		slog.Debug("Failed to remove temporary queue directory", "dir", cfg.QueueDir, "error", err)
+	}
+	cfg.QueueDir = ""
+}
+
+// validateConfig checks that all config values are within acceptable ranges.
+func validateConfig(cfg *Config) error {
+	if cfg.LogLevel != "" {
+		if _, err := parseLogLevel(cfg.LogLevel); err != nil {
+			return err
+		}
+	}
+	if cfg.ConnectionRetries < 1 {
+		return fmt.Errorf("--retries must be at least 1 (got %d)", cfg.ConnectionRetries)
+	}
+	if cfg.PowerCommandRetries < 1 {
+		return fmt.Errorf("--power-command-retries must be at least 1 (got %d)", cfg.PowerCommandRetries)
+	}
+	if cfg.RestartRetries < 0 {
+		return fmt.Errorf("--restart-retries must be non-negative (got %d)", cfg.RestartRetries)
+	}
+	for cecKey, action := range cfg.KeyActions {
+		if keyCodeByName(strings.TrimSuffix(cecKey, doublePressSuffix)) == -1 {
+			return fmt.Errorf("key-actions: unknown CEC key %q", cecKey)
+		}
+		if !validKeyAction(action) {
+			return fmt.Errorf("key-actions: unknown action %q for key %q", action, cecKey)
+		}
+	}
+	if layer := cfg.LayerShift; layer != nil {
+		if keyCodeByName(layer.Key) == -1 {
+			return fmt.Errorf("layer-shift: unknown CEC key %q for the modifier", layer.Key)
+		}
+		for cecKey, action := range layer.Actions {
+			if keyCodeByName(cecKey) == -1 {
+				return fmt.Errorf("layer-shift: unknown CEC key %q in actions", cecKey)
+			}
+			if !validKeyAction(action) {
+				return fmt.Errorf("layer-shift: unknown action %q for key %q", action, cecKey)
+			}
+		}
+	}
+	if cfg.CECInitiator < -1 || cfg.CECInitiator > 15 {
+		return fmt.Errorf("--cec-initiator must be a logical address between 0 and 15, or -1 (got %d)", cfg.CECInitiator)
+	}
+	for addr, command := range cfg.PowerCommands {
+		if addr < 0 || addr > 15 {
+			return fmt.Errorf("power-commands: invalid device address %d, must be between 0 and 15", addr)
+		}
+		if !validPowerCommand(command) {
+			return fmt.Errorf("power-commands: unknown command %q for device %d (poweron, imageviewon, textviewon, poweron+imageviewon or userpower)", command, addr)
+		}
+	}
+	if cfg.PowerOnMethod != "" && !validPowerOnMethod(cfg.PowerOnMethod) {
+		return fmt.Errorf("--power-on-method must be poweron, imageviewon, textviewon or poweron+imageviewon (got %q)", cfg.PowerOnMethod)
+	}
+	if _, err := parseQuietHours(cfg.QuietHours); err != nil {
+		return err
+	}
+	if cfg.ResumeInput != "" {
+		if _, err := parsePhysicalAddress(cfg.ResumeInput); err != nil {
+			return fmt.Errorf("--resume-input: %w", err)
+		}
+	}
+	if cfg.OnReady != "" && cfg.OnReadyTimeout <= 0 {
+		return fmt.Errorf("--on-ready-timeout must be positive (got %s)", cfg.OnReadyTimeout)
+	}
+	if cfg.MaxIdleRestart < 0 || (cfg.MaxIdleRestart > 0 && cfg.MaxIdleRestart < minMaxIdleRestart) {
+		// A bare number in the config file is read as nanoseconds.
+		return fmt.Errorf("--max-idle-restart must be 0 or at least %s, e.g. 6h (got %s)", minMaxIdleRestart, cfg.MaxIdleRestart)
+	}
+	if cfg.CommandGap < 0 {
+		return fmt.Errorf("--cec-command-gap-ms must be non-negative (got %d)", cfg.CommandGap.Milliseconds())
+	}
+	if cfg.StartupSettle < 0 {
+		return fmt.Errorf("--startup-settle-ms must be non-negative (got %d)", cfg.StartupSettle.Milliseconds())
+	}
+	if cfg.EventHistorySize < 0 {
+		return fmt.Errorf("--event-history-size must be non-negative (got %d)", cfg.EventHistorySize)
+	}
+	if cfg.ActiveSourceKeepalive < 0 {
+		return fmt.Errorf("--active-source-keepalive must be non-negative (got %s)", cfg.ActiveSourceKeepalive)
+	}
+	if cfg.DoublePressWindow < 0 {
+		return fmt.Errorf("--double-press-window must be non-negative (got %s)", cfg.DoublePressWindow)
+	}
+	if cfg.ScrollAmount < 0 {
+		return fmt.Errorf("--scroll-amount must be non-negative (got %d)", cfg.ScrollAmount)
+	}
+	if cfg.UnmappedWarnInterval < 0 {
+		return fmt.Errorf("--unmapped-warn-interval must be non-negative (got %s)", cfg.UnmappedWarnInterval)
+	}
+	switch cfg.CECBackend {
+	case "", CECBackendLibcec, CECBackendCECClient:
+	default:
+		return fmt.Errorf("--cec-backend must be %q or %q (got %q)", CECBackendLibcec, CECBackendCECClient, cfg.CECBackend)
+	}
+	if cfg.HDMIPort < 0 || cfg.HDMIPort > maxHDMIPort {
+		return fmt.Errorf("--hdmi-port must be between 1 and %d, or 0 to detect it (got %d)", maxHDMIPort, cfg.HDMIPort)
+	}
+	if cfg.HDMIPort != 0 && cfg.CECBackend != CECBackendCECClient {
+		return fmt.Errorf("--hdmi-port requires --cec-backend %s: the libcec bindings cannot set it", CECBackendCECClient)
+	}
+	if cfg.CECFallbackAdapter != "" && cfg.CECFallbackAdapter == cfg.CECAdapter {
+		return fmt.Errorf("--cec-fallback-adapter must differ from --cec-adapter (got %q for both)", cfg.CECAdapter)
+	}
+	switch cfg.KeyBackend {
+	case "", KeyBackendUinput, KeyBackendYdotool:
+	default:
+		return fmt.Errorf("--key-backend must be %q or %q (got %q)", KeyBackendUinput, KeyBackendYdotool, cfg.KeyBackend)
+	}
+	if cfg.TargetTTY != "" && cfg.KeyBackend == KeyBackendYdotool {
+		return fmt.Errorf("--target-tty replaces the uinput key backend and cannot be used with --key-backend %s", KeyBackendYdotool)
+	}
+	switch cfg.StartupEvent {
+	case "", StartupEventOn, StartupEventResume, StartupEventNone:
+	default:
+		return fmt.Errorf("--startup-event must be %q, %q or %q (got %q)", StartupEventOn, StartupEventResume, StartupEventNone, cfg.StartupEvent)
+	}
+	switch cfg.UnknownPowerEvents {
+	case "", UnknownPowerEventsIgnore, UnknownPowerEventsStandby:
+	default:
+		return fmt.Errorf("--unknown-power-events must be %q or %q (got %q)", UnknownPowerEventsIgnore, UnknownPowerEventsStandby, cfg.UnknownPowerEvents)
+	}
+	switch cfg.NumberMode {
+	case "", NumberModeDirect, NumberModeChannel:
+	default:
+		return fmt.Errorf("--number-mode must be %q or %q (got %q)", NumberModeDirect, NumberModeChannel, cfg.NumberMode)
+	}
+	if cfg.RemotePreset != "" && !validRemotePreset(cfg.RemotePreset) {
+		return fmt.Errorf("--remote-preset must be %q, %q or %q (got %q)", RemotePresetDesktop, RemotePresetKodi, RemotePresetAndroidTV, cfg.RemotePreset)
+	}
+	if cfg.KeyMapProfile != "" && cfg.KeyMapProfile != defaultProfile {
+		if _, ok := cfg.KeyMapProfiles[cfg.KeyMapProfile]; !ok {
+			return fmt.Errorf("--keymap-profile %q is not defined in keymap-profiles", cfg.KeyMapProfile)
+		}
+	}
+	validDeviceTypes := map[int]bool{
+		CECDeviceTypeTV: true, CECDeviceTypeRecording: true,
+		CECDeviceTypeTuner: true, CECDeviceTypePlayback: true,
+		CECDeviceTypeAudioSystem: true,
+	}
+	if !validDeviceTypes[cfg.ActiveSourceDeviceType] {
+		return fmt.Errorf("--active-source-type must be one of 0,1,3,4,5 (got %d)", cfg.ActiveSourceDeviceType)
+	}
+	return nil
+}
+
+// quietHours is a daily local time window, in minutes since midnight. The
+// window may cross midnight, in which case End is before Start.
+type quietHours struct {
+	Start, End int
+}
+
+// Contains reports whether t falls inside the window, using t's location.
+func (q *quietHours) Contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	if q.Start < q.End {
+		return m >= q.Start && m < q.End
+	}
+	return m >= q.Start || m < q.End
+}
+
+// parseQuietHours parses a "HH:MM-HH:MM" window. An empty string disables
+// quiet hours and returns nil.
+func parseQuietHours(window string) (*quietHours, error) {
+	if window == "" {
+		return nil, nil
+	}
+	start, end, ok := strings.Cut(window, "-")
+	if !ok {
+		return nil, fmt.Errorf("--quiet-hours must look like 23:00-07:00 (got %q)", window)
+	}
+	var q quietHours
+	for _, part := range []struct {
+		value string
+		dest  *int
+	}{{start, &q.Start}, {end, &q.End}} {
+		t, err := time.Parse("15:04", strings.TrimSpace(part.value))
+		if err != nil {
+			return nil, fmt.Errorf("--quiet-hours must look like 23:00-07:00 (got %q)", window)
+		}
+		*part.dest = t.Hour()*60 + t.Minute()
+	}
+	if q.Start == q.End {
+		return nil, fmt.Errorf("--quiet-hours start and end must differ (got %q)", window)
+	}
+	return &q, nil
+}
+
+// powerEventSources returns the logind signals to forward. --no-power-events
+// disables the listener altogether, so it is not considered here.
+func (cfg *Config) powerEventSources() PowerEventSources {
+	return PowerEventSources{
+		Sleep:    !cfg.NoSleepEvents,
+		Resume:   !cfg.NoResumeEvents,
+		Shutdown: !cfg.NoShutdownEvents,
+	}
+}
+
+// parseLogLevel maps a --log-level value to its slog level.
+func parseLogLevel(level string) (slog.Level, error) {
+	switch level {
+	case "error":
+		return slog.LevelError, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "info", "":
+		return slog.LevelInfo, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	default:
+		return slog.LevelInfo, fmt.Errorf("--log-level must be one of error, warn, info, debug (got %q)", level)
+	}
+}
+
+func parseKeyMapFromMap(keyMapConfig map[string]interface{}) map[string][]int {
+	m := make(map[string][]int)
+	for cecKey, value := range keyMapConfig {
+		var linuxCodesStr string
+		switch v := value.(type) {
+		case string:
+			linuxCodesStr = v
+		default:
+			slog.Warn("Invalid keymap value type", "key", cecKey, "value", value)
+			continue
+		}
+
+		codes := strings.Split(linuxCodesStr, "+")
+		var linuxCodes []int
+		valid := true
+		for _, codeStr := range codes {
+			code, err := strconv.Atoi(codeStr)
+			if err != nil {
+				slog.Warn("Invalid keymap entry, skipping", "key", cecKey, "value", linuxCodesStr, "badCode", codeStr)
+				valid = false
+				break
+			}
+			linuxCodes = append(linuxCodes, code)
+		}
+		if valid {
+			m[cecKey] = linuxCodes
+		}
+	}
+	return m
+}
+
+// keyMapFile holds what a keymap file defines. A flat file only fills KeyMap;
+// a YAML file grouped in sections (see keyMapFileSections) can also define
+// profiles and key actions.
+type keyMapFile struct {
+	KeyMap   map[string][]int
+	Profiles map[string]map[string][]int
+	Actions  map[string]string
+}
+
+// Sections of a grouped keymap file, mirroring the keymap, keymap-profiles
+// and key-actions configuration keys.
+const (
+	keyMapSectionKeymap   = "keymap"
+	keyMapSectionProfiles = "profiles"
+	keyMapSectionActions  = "actions"
+)
+
+var keyMapFileSections = []string{keyMapSectionKeymap, keyMapSectionProfiles, keyMapSectionActions}
+
+// grouped reports whether the file has to be written in sections to keep
+// its profiles and actions.
+func (f *keyMapFile) grouped() bool {
+	return len(f.Profiles) > 0 || len(f.Actions) > 0
+}
+
+// loadKeyMapFile reads key mappings from a file. A .csv file holds one
+// <cec>,<linux> mapping per line, with blank lines and # comments ignored;
+// any other file is read like the keymap section of the config file, or in
+// sections when it has any of keyMapFileSections at its top level.
+func loadKeyMapFile(path string) (*keyMapFile, error) {
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var entries []string
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			cecKey, linuxCodes, ok := strings.Cut(line, ",")
+			if !ok {
+				slog.Warn("Invalid keymap file entry", "path", path, "entry", line)
+				continue
+			}
+			entries = append(entries, strings.TrimSpace(cecKey)+":"+strings.TrimSpace(linuxCodes))
+		}
+		return &keyMapFile{KeyMap: parseKeyMapFlags(entries)}, nil
+	}
+
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	return parseKeyMapFileSettings(v.AllSettings())
+}
+
+// parseKeyMapFileSettings parses the content of a YAML keymap file, either a
+// flat keymap or grouped in sections. A grouped file may not mix sections and
+// mappings at its top level, so a typo in a section name is not taken for a
+// CEC key.
+func parseKeyMapFileSettings(settings map[string]interface{}) (*keyMapFile, error) {
+	grouped := false
+	for _, section := range keyMapFileSections {
+		if _, ok := settings[section]; ok {
+			grouped = true
+		}
+	}
+	if !grouped {
+		return &keyMapFile{KeyMap: parseKeyMapFromMap(settings)}, nil
+	}
+
+	file := &keyMapFile{}
+	for name, value := range settings {
+		if value == nil {
+			// An empty section.
+			continue
+		}
+		section, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("section %q must be a map", name)
+		}
+		switch name {
+		case keyMapSectionKeymap:
+			file.KeyMap = parseKeyMapFromMap(section)
+		case keyMapSectionProfiles:
+			file.Profiles = make(map[string]map[string][]int, len(section))
+			for profile, mappings := range section {
+				profileMap, ok := mappings.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("profile %q must map CEC keys to Linux key codes", profile)
+				}
+				file.Profiles[profile] = parseKeyMapFromMap(profileMap)
+			}
+		case keyMapSectionActions:
+			file.Actions = parseKeyActionsFromMap(section)
+		default:
+			return nil, fmt.Errorf("unknown section %q, expected one of %s", name, strings.Join(keyMapFileSections, ", "))
+		}
+	}
+	return file, nil
+}
+
+// mergeKeyMaps returns base overlaid with overrides. Keys are compared by CEC
+// code, so "Select" in overrides replaces "select" in base.
+func mergeKeyMaps(base, overrides map[string][]int) map[string][]int {
+	overridden := make(map[int]bool, len(overrides))
+	for cecKey := range overrides {
+		if code := keyCodeByName(cecKey); code != -1 {
+			overridden[code] = true
+		}
+	}
+	merged := make(map[string][]int, len(base)+len(overrides))
+	for cecKey, linuxCodes := range base {
+		if !overridden[keyCodeByName(cecKey)] {
+			merged[cecKey] = linuxCodes
+		}
+	}
+	for cecKey, linuxCodes := range overrides {
+		merged[cecKey] = linuxCodes
+	}
+	return merged
+}
+
+// mergeKeyActions returns base overlaid with overrides. Keys are compared like
+// in mergeKeyMaps, a double press entry only replacing a double press entry.
+func mergeKeyActions(base, overrides map[string]string) map[string]string {
+	type actionKey struct {
+		code   int
+		double bool
+	}
+	keyOf := func(name string) actionKey {
+		keyName, double := strings.CutSuffix(name, doublePressSuffix)
+		return actionKey{keyCodeByName(keyName), double}
+	}
+	overridden := make(map[actionKey]bool, len(overrides))
+	for name := range overrides {
+		if key := keyOf(name); key.code != -1 {
+			overridden[key] = true
+		}
+	}
+	merged := make(map[string]string, len(base)+len(overrides))
+	for name, action := range base {
+		if !overridden[keyOf(name)] {
+			merged[name] = action
+		}
+	}
+	for name, action := range overrides {
+		merged[name] = action
+	}
+	return merged
+}
+
+func parseKeyMapFlags(keyMapArgs []string) map[string][]int {
+	m := make(map[string][]int)
+	for _, entry := range keyMapArgs {
+		// Split on the last colon: vendor key names contain colons too.
+		sep := strings.LastIndex(entry, ":")
+		if sep <= 0 {
+			slog.Warn("Invalid keymap entry", "entry", entry)
+			continue
+		}
+		cecKey := entry[:sep]
+		if strings.Contains(cecKey, ":") && !strings.HasPrefix(strings.ToLower(cecKey), vendorKeyPrefix) {
+			slog.Warn("Invalid keymap entry", "entry", entry)
+			continue
+		}
+
+		codes := strings.Split(entry[sep+1:], "+")
+		var linuxCodes []int
+		valid := true
+		for _, codeStr := range codes {
+			code, err := strconv.Atoi(codeStr)
+			if err != nil {
+				slog.Warn("Invalid keymap entry, skipping", "entry", entry, "badCode", codeStr)
+				valid = false
+				break
+			}
+			linuxCodes = append(linuxCodes, code)
+		}
+		if valid {
+			m[cecKey] = linuxCodes
+		}
+	}
+	return m
+}
+
+func parseKeyActionsFromMap(actionsConfig map[string]interface{}) map[string]string {
+	m := make(map[string]string, len(actionsConfig))
+	for cecKey, value := range actionsConfig {
+		action, ok := value.(string)
+		if !ok || action == "" {
+			slog.Warn("Invalid key action value", "key", cecKey, "value", value)
+			continue
+		}
+		m[cecKey] = action
+	}
+	return m
+}
+
+// parseKeyActionFlags parses --key-action entries of the form <cec>=<action>.
+func parseKeyActionFlags(actionArgs []string) map[string]string {
+	m := make(map[string]string)
+	for _, entry := range actionArgs {
+		cecKey, action, ok := strings.Cut(entry, "=")
+		if !ok || cecKey == "" || action == "" {
+			slog.Warn("Invalid key action entry", "entry", entry)
+			continue
+		}
+		m[cecKey] = action
+	}
+	return m
+}
+
+func parseDevices(devices []string) []int {
+	if len(devices) == 0 {
+		return []int{0}
+	}
+	var result []int
+	for _, devStr := range devices {
+		parts := strings.Split(devStr, ",")
+		for _, part := range parts {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			dev, err := strconv.Atoi(part)
+			if err != nil {
+				slog.Warn("Invalid device address", "device", part, "error", err)
+				continue
+			}
+			result = append(result, dev)
+		}
+	}
+	return result
+}
+
+// addressMapFromConfig reads a map of device address to string, given either
+// as a map in the config file or as <address>:<value> flag entries. what
+// names an entry in warnings.
+func addressMapFromConfig(key, what string) map[int]string {
+	switch v := viper.Get(key).(type) {
+	case map[string]interface{}:
+		return parseAddressMapFromMap(what, v)
+	case []interface{}:
+		var args []string
+		for _, item := range v {
+			if str, ok := item.(string); ok {
+				args = append(args, str)
+			}
+		}
+		return parseAddressMapFlags(what, args)
+	case []string:
+		return parseAddressMapFlags(what, v)
+	}
+	return nil
+}
+
+func parseAddressMapFromMap(what string, config map[string]interface{}) map[int]string {
+	m := make(map[int]string)
+	for addrStr, value := range config {
+		str, ok := value.(string)
+		if !ok || str == "" {
+			slog.Warn("Invalid "+what+" value", "device", addrStr, "value", value)
+			continue
+		}
+		addr, err := strconv.Atoi(strings.TrimSpace(addrStr))
+		if err != nil {
+			slog.Warn("Invalid "+what+" address", "device", addrStr, "error", err)
+			continue
+		}
+		m[addr] = str
+	}
+	return m
+}
+
+func parseAddressMapFlags(what string, args []string) map[int]string {
+	m := make(map[int]string)
+	for _, entry := range args {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			slog.Warn("Invalid "+what+" entry", "entry", entry)
+			continue
+		}
+		addr, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+		if err != nil {
+			slog.Warn("Invalid "+what+" address", "entry", entry, "error", err)
+			continue
+		}
+		m[addr] = parts[1]
+	}
+	return m
+}
+
+// deviceLabels returns a human readable label for each address, using the
+// configured alias when there is one and the bare address otherwise.
+func deviceLabels(devices []int, aliases map[int]string) []string {
+	labels := make([]string, 0, len(devices))
+	for _, dev := range devices {
+		if name, ok := aliases[dev]; ok {
+			labels = append(labels, name)
+		} else {
+			labels = append(labels, strconv.Itoa(dev))
+		}
+	}
+	return labels
+}
+
+// parseCECKeys resolves CEC keys given by name (e.g. "Select") or by code in
+// decimal or hex (e.g. "13", "0x0D"). Names win, so "1" is the digit key as in
+// the keymap. Invalid entries are skipped.
+func parseCECKeys(keys []string) []int {
+	var codes []int
+	for _, keyStr := range keys {
+		for _, part := range strings.Split(keyStr, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			if code := keyCodeByName(part); code != -1 {
+				codes = append(codes, code)
+				continue
+			}
+			code, err := strconv.ParseInt(part, 0, 0)
+			if err != nil {
+				slog.Warn("Invalid CEC key", "key", part)
+				continue
+			}
+			codes = append(codes, int(code))
+		}
+	}
+	return codes
+}
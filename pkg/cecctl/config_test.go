@@ -0,0 +1,862 @@
+package cecctl
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestConfigLoading(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "cec-controller.yaml")
+
+	configContent := `
+cec-adapter: "/dev/ttyACM0"
+device-name: "TestDevice"
+debug: true
+no-power-events: false
+retries: 10
+keymap:
+  "1": "105"
+  "2": "106"
+devices:
+  - "0"
+  - "1"
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("Failed to write test config file: %v", err)
+	}
+
+	viper.Reset()
+	viper.SetConfigFile(configPath)
+	viper.SetConfigType("yaml")
+
+	if err := viper.ReadInConfig(); err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+
+	if adapter := viper.GetString("cec-adapter"); adapter != "/dev/ttyACM0" {
+		t.Errorf("Expected cec-adapter to be '/dev/ttyACM0', got '%s'", adapter)
+	}
+	if deviceName := viper.GetString("device-name"); deviceName != "TestDevice" {
+		t.Errorf("Expected device-name to be 'TestDevice', got '%s'", deviceName)
+	}
+	if debug := viper.GetBool("debug"); !debug {
+		t.Error("Expected debug to be true")
+	}
+	if retries := viper.GetInt("retries"); retries != 10 {
+		t.Errorf("Expected retries to be 10, got %d", retries)
+	}
+	keymap := viper.GetStringMapString("keymap")
+	if len(keymap) != 2 {
+		t.Errorf("Expected 2 keymap entries, got %d", len(keymap))
+	}
+	if keymap["1"] != "105" {
+		t.Errorf("Expected keymap['1'] to be '105', got '%s'", keymap["1"])
+	}
+}
+
+func TestParseKeyMapFromMap(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    map[string]interface{}
+		expected map[string][]int
+	}{
+		{
+			name:     "Single key mapping",
+			input:    map[string]interface{}{"1": "105"},
+			expected: map[string][]int{"1": {105}},
+		},
+		{
+			name:     "Multiple key codes",
+			input:    map[string]interface{}{"1": "29+105"},
+			expected: map[string][]int{"1": {29, 105}},
+		},
+		{
+			name:     "Multiple mappings",
+			input:    map[string]interface{}{"1": "105", "2": "106"},
+			expected: map[string][]int{"1": {105}, "2": {106}},
+		},
+		{
+			name:     "Invalid value type",
+			input:    map[string]interface{}{"1": 105},
+			expected: map[string][]int{},
+		},
+		{
+			name:     "Partially invalid codes skips entire entry",
+			input:    map[string]interface{}{"1": "29+abc+105"},
+			expected: map[string][]int{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := parseKeyMapFromMap(tt.input)
+			if len(result) != len(tt.expected) {
+				t.Errorf("Expected %d mappings, got %d", len(tt.expected), len(result))
+			}
+			for key, expectedCodes := range tt.expected {
+				resultCodes, ok := result[key]
+				if !ok {
+					t.Errorf("Expected key '%s' not found in result", key)
+					continue
+				}
+				if len(resultCodes) != len(expectedCodes) {
+					t.Errorf("For key '%s', expected %d codes, got %d", key, len(expectedCodes), len(resultCodes))
+					continue
+				}
+				for i, code := range expectedCodes {
+					if resultCodes[i] != code {
+						t.Errorf("For key '%s' at index %d, expected code %d, got %d", key, i, code, resultCodes[i])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestParseKeyMapFlags(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []string
+		expected map[string][]int
+	}{
+		{
+			name:     "Single key mapping",
+			input:    []string{"1:105"},
+			expected: map[string][]int{"1": {105}},
+		},
+		{
+			name:     "Multiple key codes",
+			input:    []string{"1:29+105"},
+			expected: map[string][]int{"1": {29, 105}},
+		},
+		{
+			name:     "Multiple mappings",
+			input:    []string{"1:105", "2:106"},
+			expected: map[string][]int{"1": {105}, "2": {106}},
+		},
+		{
+			name:     "Invalid format",
+			input:    []string{"invalid"},
+			expected: map[string][]int{},
+		},
+		{
+			name:     "Partially invalid codes skips entire entry",
+			input:    []string{"1:29+abc+105"},
+			expected: map[string][]int{},
+		},
+		{
+			name:     "Vendor key with colons",
+			input:    []string{"vendor:00:91:28"},
+			expected: map[string][]int{"vendor:00:91": {28}},
+		},
+		{
+			name:     "Too many separators",
+			input:    []string{"1:2:105"},
+			expected: map[string][]int{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := parseKeyMapFlags(tt.input)
+			if len(result) != len(tt.expected) {
+				t.Errorf("Expected %d mappings, got %d", len(tt.expected), len(result))
+			}
+			for key, expectedCodes := range tt.expected {
+				resultCodes, ok := result[key]
+				if !ok {
+					t.Errorf("Expected key '%s' not found in result", key)
+					continue
+				}
+				if len(resultCodes) != len(expectedCodes) {
+					t.Errorf("For key '%s', expected %d codes, got %d", key, len(expectedCodes), len(resultCodes))
+					continue
+				}
+				for i, code := range expectedCodes {
+					if resultCodes[i] != code {
+						t.Errorf("For key '%s' at index %d, expected code %d, got %d", key, i, code, resultCodes[i])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestParseDevices(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []string
+		expected []int
+	}{
+		{"Empty input defaults to device 0", []string{}, []int{0}},
+		{"Single device", []string{"1"}, []int{1}},
+		{"Multiple devices in one string", []string{"0,1,2"}, []int{0, 1, 2}},
+		{"Multiple devices in separate strings", []string{"0", "1", "2"}, []int{0, 1, 2}},
+		{"Devices with spaces", []string{"0, 1, 2"}, []int{0, 1, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := parseDevices(tt.input)
+			if len(result) != len(tt.expected) {
+				t.Errorf("Expected %d devices, got %d", len(tt.expected), len(result))
+			}
+			for i, expected := range tt.expected {
+				if i >= len(result) {
+					t.Errorf("Missing device at index %d", i)
+					continue
+				}
+				if result[i] != expected {
+					t.Errorf("At index %d, expected device %d, got %d", i, expected, result[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseDeviceAliases(t *testing.T) {
+	fromMap := parseAddressMapFromMap("device alias", map[string]interface{}{"0": "TV", "5": "Soundbar", "x": "Bad", "1": 3})
+	if len(fromMap) != 2 || fromMap[0] != "TV" || fromMap[5] != "Soundbar" {
+		t.Errorf("Unexpected aliases from map: %v", fromMap)
+	}
+
+	fromFlags := parseAddressMapFlags("device alias", []string{"0:TV", "5:Sound:bar", "invalid", "x:Bad", "1:"})
+	if len(fromFlags) != 2 || fromFlags[0] != "TV" || fromFlags[5] != "Sound:bar" {
+		t.Errorf("Unexpected aliases from flags: %v", fromFlags)
+	}
+}
+
+func TestDeviceLabels(t *testing.T) {
+	labels := deviceLabels([]int{0, 5, 4}, map[int]string{0: "TV", 5: "Soundbar"})
+	expected := []string{"TV", "Soundbar", "4"}
+	if len(labels) != len(expected) {
+		t.Fatalf("Expected %d labels, got %v", len(expected), labels)
+	}
+	for i := range expected {
+		if labels[i] != expected[i] {
+			t.Errorf("At index %d, expected %q, got %q", i, expected[i], labels[i])
+		}
+	}
+}
+
+func TestKeyMapProfilesConfig(t *testing.T) {
+	viper.Reset()
+	configPath := filepath.Join(t.TempDir(), "cec-controller.yaml")
+	configContent := `
+keymap-profile: kodi
+keymap-profiles:
+  kodi:
+    Select: "28"
+    Exit: "14"
+  desktop:
+    Select: "29+28"
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("Failed to write test config file: %v", err)
+	}
+	viper.SetConfigFile(configPath)
+	if err := viper.ReadInConfig(); err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+	os.Setenv(queueDirEnvVar, t.TempDir())
+	defer os.Unsetenv(queueDirEnvVar)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.KeyMapProfile != "kodi" {
+		t.Errorf("Expected keymap profile 'kodi', got %q", cfg.KeyMapProfile)
+	}
+	if len(cfg.KeyMapProfiles) != 2 {
+		t.Fatalf("Expected 2 keymap profiles, got %v", cfg.KeyMapProfiles)
+	}
+	if codes := cfg.KeyMapProfiles["desktop"]["select"]; len(codes) != 2 || codes[0] != 29 || codes[1] != 28 {
+		t.Errorf("Expected desktop Select to map to [29 28], got %v", codes)
+	}
+}
+
+func TestLayerShiftConfig(t *testing.T) {
+	viper.Reset()
+	configPath := filepath.Join(t.TempDir(), "cec-controller.yaml")
+	configContent := `
+layer-shift:
+  key: Blue
+  keymap:
+    Up: "104"
+  actions:
+    Select: power:off-all
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("Failed to write test config file: %v", err)
+	}
+	viper.SetConfigFile(configPath)
+	if err := viper.ReadInConfig(); err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+	os.Setenv(queueDirEnvVar, t.TempDir())
+	defer os.Unsetenv(queueDirEnvVar)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	want := &ShiftLayer{
+		Key:     "Blue",
+		KeyMap:  map[string][]int{"up": {104}},
+		Actions: map[string]string{"select": "power:off-all"},
+	}
+	if !reflect.DeepEqual(cfg.LayerShift, want) {
+		t.Errorf("Expected %+v, got %+v", want, cfg.LayerShift)
+	}
+}
+
+func TestKeyMapFile(t *testing.T) {
+	dir := t.TempDir()
+	yamlPath := filepath.Join(dir, "keymap.yaml")
+	if err := os.WriteFile(yamlPath, []byte("Select: \"28\"\nExit: \"14\"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write keymap file: %v", err)
+	}
+	csvPath := filepath.Join(dir, "keymap.csv")
+	if err := os.WriteFile(csvPath, []byte("# remote buttons\nSelect,28\n\nExit, 29+14\nbogus\n"), 0644); err != nil {
+		t.Fatalf("Failed to write keymap file: %v", err)
+	}
+
+	load := func(t *testing.T, configContent string) *Config {
+		t.Helper()
+		viper.Reset()
+		configPath := filepath.Join(t.TempDir(), "cec-controller.yaml")
+		if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+			t.Fatalf("Failed to write test config file: %v", err)
+		}
+		viper.SetConfigFile(configPath)
+		if err := viper.ReadInConfig(); err != nil {
+			t.Fatalf("Failed to read config: %v", err)
+		}
+		os.Setenv(queueDirEnvVar, t.TempDir())
+		defer os.Unsetenv(queueDirEnvVar)
+		cfg, err := loadConfig()
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+		return cfg
+	}
+
+	t.Run("yaml merged under inline keymap", func(t *testing.T) {
+		cfg := load(t, "keymap-file: "+yamlPath+"\nkeymap:\n  exit: \"1\"\n")
+		if len(cfg.KeyMapOverrides) != 2 {
+			t.Fatalf("Expected 2 mappings, got %v", cfg.KeyMapOverrides)
+		}
+		if codes := cfg.KeyMapOverrides["select"]; len(codes) != 1 || codes[0] != 28 {
+			t.Errorf("Expected Select from the file to map to [28], got %v", codes)
+		}
+		if codes := cfg.KeyMapOverrides["exit"]; len(codes) != 1 || codes[0] != 1 {
+			t.Errorf("Expected inline Exit to win with [1], got %v", codes)
+		}
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		cfg := load(t, "keymap-file: "+csvPath+"\n")
+		if codes := cfg.KeyMapOverrides["Select"]; len(codes) != 1 || codes[0] != 28 {
+			t.Errorf("Expected Select to map to [28], got %v", codes)
+		}
+		if codes := cfg.KeyMapOverrides["Exit"]; len(codes) != 2 || codes[0] != 29 || codes[1] != 14 {
+			t.Errorf("Expected Exit to map to [29 14], got %v", codes)
+		}
+		if len(cfg.KeyMapOverrides) != 2 {
+			t.Errorf("Expected invalid lines to be skipped, got %v", cfg.KeyMapOverrides)
+		}
+	})
+
+	t.Run("grouped yaml", func(t *testing.T) {
+		groupedPath := filepath.Join(dir, "grouped.yaml")
+		content := `# Living room remote
+keymap:
+  Select: "28" # Enter
+  Exit: "14"
+profiles:
+  kodi:
+    Exit: "1" # Esc closes Kodi dialogs
+  browser:
+    Select: "57"
+actions:
+  Blue: profile:next
+  Red: power:off-all
+`
+		if err := os.WriteFile(groupedPath, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write keymap file: %v", err)
+		}
+		cfg := load(t, "keymap-file: "+groupedPath+"\nkeymap-profiles:\n  kodi:\n    select: \"96\"\nkey-actions:\n  red: profile:toggle\n")
+		wantKeyMap := map[string][]int{"select": {28}, "exit": {14}}
+		if !reflect.DeepEqual(cfg.KeyMapOverrides, wantKeyMap) {
+			t.Errorf("Expected keymap %v, got %v", wantKeyMap, cfg.KeyMapOverrides)
+		}
+		wantProfiles := map[string]map[string][]int{
+			"kodi":    {"exit": {1}, "select": {96}},
+			"browser": {"select": {57}},
+		}
+		if !reflect.DeepEqual(cfg.KeyMapProfiles, wantProfiles) {
+			t.Errorf("Expected profiles %v, got %v", wantProfiles, cfg.KeyMapProfiles)
+		}
+		wantActions := map[string]string{"blue": "profile:next", "red": "profile:toggle"}
+		if !reflect.DeepEqual(cfg.KeyActions, wantActions) {
+			t.Errorf("Expected configured actions to win, got %v", cfg.KeyActions)
+		}
+	})
+
+	t.Run("grouped yaml with an unknown section", func(t *testing.T) {
+		badPath := filepath.Join(dir, "bad.yaml")
+		if err := os.WriteFile(badPath, []byte("keymap:\n  Select: \"28\"\nprofile:\n  kodi:\n    Exit: \"1\"\n"), 0644); err != nil {
+			t.Fatalf("Failed to write keymap file: %v", err)
+		}
+		viper.Reset()
+		viper.Set("keymap-file", badPath)
+		if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), `unknown section "profile"`) {
+			t.Errorf("Expected an unknown section error, got %v", err)
+		}
+	})
+
+	t.Run("missing file is not fatal", func(t *testing.T) {
+		cfg := load(t, "keymap-file: "+filepath.Join(dir, "missing.yaml")+"\nkeymap:\n  select: \"28\"\n")
+		if len(cfg.KeyMapOverrides) != 1 {
+			t.Errorf("Expected only the inline mapping, got %v", cfg.KeyMapOverrides)
+		}
+	})
+}
+
+func TestParseCECKeys(t *testing.T) {
+	codes := parseCECKeys([]string{"Select", "0x91,13", "1", "NotAKey", ""})
+	expected := []int{0x00, 0x91, 13, 0x21}
+	if len(codes) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, codes)
+	}
+	for i := range expected {
+		if codes[i] != expected[i] {
+			t.Errorf("At index %d, expected %d, got %d", i, expected[i], codes[i])
+		}
+	}
+}
+
+func TestDefaultValues(t *testing.T) {
+	viper.Reset()
+
+	tempDir := t.TempDir()
+	os.Setenv(queueDirEnvVar, tempDir)
+	defer os.Unsetenv(queueDirEnvVar)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.ConnectionRetries != 5 {
+		t.Errorf("Expected default retries to be 5, got %d", cfg.ConnectionRetries)
+	}
+	if cfg.DeviceName == "" {
+		t.Error("Expected device name to be set to hostname")
+	}
+	if cfg.NoPowerEvents || len(cfg.PowerDevices) != 1 || cfg.PowerDevices[0] != 0 {
+		t.Errorf("Expected NoPowerEvents=false and PowerDevices=[0], got NoPowerEvents=%v, PowerDevices=%v", cfg.NoPowerEvents, cfg.PowerDevices)
+	}
+	if cfg.QueueDir != tempDir {
+		t.Errorf("Expected queue dir to be '%s', got '%s'", tempDir, cfg.QueueDir)
+	}
+	if cfg.PowerCommandRetries != 1 {
+		t.Errorf("Expected default power command retries to be 1, got %d", cfg.PowerCommandRetries)
+	}
+	if cfg.RestartRetries != 3 {
+		t.Errorf("Expected default restart retries to be 3, got %d", cfg.RestartRetries)
+	}
+	if cfg.ActiveSourceDeviceType != CECDeviceTypePlayback {
+		t.Errorf("Expected default active source device type to be %d (Playback), got %d", CECDeviceTypePlayback, cfg.ActiveSourceDeviceType)
+	}
+	if cfg.SetActiveSource {
+		t.Error("Expected set-active-source to be false by default")
+	}
+	if cfg.LogLevel != "info" {
+		t.Errorf("Expected default log level to be info, got %q", cfg.LogLevel)
+	}
+}
+
+func TestDefaultQueueDir(t *testing.T) {
+	runtimeDir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+	dir, err := defaultQueueDir()
+	if err != nil {
+		t.Fatalf("defaultQueueDir failed: %v", err)
+	}
+	if want := filepath.Join(runtimeDir, "cec-controller"); dir != want {
+		t.Errorf("Expected queue dir %s, got %s", want, dir)
+	}
+	if fi, err := os.Stat(dir); err != nil || fi.Mode().Perm() != 0o700 {
+		t.Errorf("Expected a 0700 directory, got %v, %v", fi, err)
+	}
+
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	dir, err = defaultQueueDir()
+	if err != nil {
+		t.Fatalf("defaultQueueDir failed: %v", err)
+	}
+	defer os.Remove(dir)
+	if filepath.Dir(dir) != filepath.Clean(os.TempDir()) {
+		t.Errorf("Expected a temporary directory without XDG_RUNTIME_DIR, got %s", dir)
+	}
+}
+
+func TestDebugIsLogLevelShortcut(t *testing.T) {
+	viper.Reset()
+	os.Setenv(queueDirEnvVar, t.TempDir())
+	defer os.Unsetenv(queueDirEnvVar)
+	viper.Set("debug", true)
+	viper.Set("log-level", "warn")
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.LogLevel != "debug" {
+		t.Errorf("Expected --debug to force the debug log level, got %q", cfg.LogLevel)
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	tests := map[string]slog.Level{
+		"error": slog.LevelError,
+		"warn":  slog.LevelWarn,
+		"info":  slog.LevelInfo,
+		"debug": slog.LevelDebug,
+	}
+	for name, expected := range tests {
+		lvl, err := parseLogLevel(name)
+		if err != nil || lvl != expected {
+			t.Errorf("parseLogLevel(%q) = %v, %v; expected %v", name, lvl, err, expected)
+		}
+	}
+	if _, err := parseLogLevel("trace"); err == nil {
+		t.Error("Expected error for unknown log level")
+	}
+}
+
+func TestRestartRetriesFromEnvVar(t *testing.T) {
+	viper.Reset()
+	tempDir := t.TempDir()
+	os.Setenv(queueDirEnvVar, tempDir)
+	defer os.Unsetenv(queueDirEnvVar)
+	os.Setenv(restartRetriesEnvVar, "7")
+	defer os.Unsetenv(restartRetriesEnvVar)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.RestartRetries != 7 {
+		t.Errorf("Expected RestartRetries to be 7 from env var, got %d", cfg.RestartRetries)
+	}
+}
+
+// TestExampleConfigFile verifies that the shipped example config file parses
+// cleanly and contains all known configuration keys, preventing silent drift.
+func TestExampleConfigFile(t *testing.T) {
+	viper.Reset()
+	viper.SetConfigFile("../../cec-controller.yaml.example")
+	viper.SetConfigType("yaml")
+
+	if err := viper.ReadInConfig(); err != nil {
+		t.Fatalf("Failed to read example config file: %v", err)
+	}
+
+	tempDir := t.TempDir()
+	os.Setenv(queueDirEnvVar, tempDir)
+	defer os.Unsetenv(queueDirEnvVar)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("loadConfig failed on example file: %v", err)
+	}
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("validateConfig failed on example file: %v", err)
+	}
+
+	// Verify all known keys are present in the example file so drift is caught.
+	knownKeys := []string{
+		"cec-adapter", "cec-fallback-adapter", "hdmi-port", "cec-backend", "device-name", "debug", "no-power-events", "no-sleep-events", "no-resume-events", "no-shutdown-events", "standby-all-on-shutdown", "standby-on-exit", "reconnect-osd", "confirm-power", "cec-command-gap-ms", "keep-queue", "queue-delete-on-restart", "number-mode", "power-on-method", "unknown-power-events",
+		"retries", "power-command-retries", "restart-retries", "set-active-source", "active-source-keepalive", "claim-active-source", "active-source-type",
+		"keymap", "keymap-profiles", "keymap-profile", "key-actions", "layer-shift", "ignore-keys", "allow-keys", "unmapped-warn-interval", "double-press-window", "scroll-amount", "log-keys", "devices", "quiet-hours", "resume-input", "resume-one-touch-play", "keymap-file", "remote-preset", "event-history-size", "startup-event", "startup-settle-ms", "max-idle-restart", "on-ready", "on-ready-timeout", "queue-dir", "recover-queue", "dbus-address", "control-socket", "device-aliases", "power-commands", "cec-initiator", "tv-speakers", "volume-accel", "log-level", "log-file", "log-syslog", "watch-config", "key-backend", "target-tty", "allow-no-keyboard",
+	}
+	for _, key := range knownKeys {
+		if !viper.IsSet(key) {
+			t.Errorf("Example config file is missing key %q — add it to cec-controller.yaml.example", key)
+		}
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{
+			name:    "valid defaults",
+			cfg:     Config{ConnectionRetries: 5, PowerCommandRetries: 1, RestartRetries: 3, ActiveSourceDeviceType: CECDeviceTypePlayback},
+			wantErr: false,
+		},
+		{
+			name:    "zero retries",
+			cfg:     Config{ConnectionRetries: 0, RestartRetries: 3, ActiveSourceDeviceType: CECDeviceTypePlayback},
+			wantErr: true,
+		},
+		{
+			name:    "zero power command retries",
+			cfg:     Config{ConnectionRetries: 5, PowerCommandRetries: 0, RestartRetries: 3, ActiveSourceDeviceType: CECDeviceTypePlayback},
+			wantErr: true,
+		},
+		{
+			name:    "negative restart retries",
+			cfg:     Config{ConnectionRetries: 5, RestartRetries: -1, ActiveSourceDeviceType: CECDeviceTypePlayback},
+			wantErr: true,
+		},
+		{
+			name:    "max idle restart in nanoseconds",
+			cfg:     Config{ConnectionRetries: 5, PowerCommandRetries: 1, RestartRetries: 3, ActiveSourceDeviceType: CECDeviceTypePlayback, MaxIdleRestart: 3},
+			wantErr: true,
+		},
+		{
+			name:    "max idle restart",
+			cfg:     Config{ConnectionRetries: 5, PowerCommandRetries: 1, RestartRetries: 3, ActiveSourceDeviceType: CECDeviceTypePlayback, MaxIdleRestart: 6 * time.Hour},
+			wantErr: false,
+		},
+		{
+			name:    "invalid device type",
+			cfg:     Config{ConnectionRetries: 5, RestartRetries: 3, ActiveSourceDeviceType: 9},
+			wantErr: true,
+		},
+		{
+			name:    "invalid resume input",
+			cfg:     Config{ConnectionRetries: 5, PowerCommandRetries: 1, RestartRetries: 3, ActiveSourceDeviceType: CECDeviceTypePlayback, ResumeInput: "2.0.0"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid cec initiator",
+			cfg:     Config{ConnectionRetries: 5, PowerCommandRetries: 1, RestartRetries: 3, ActiveSourceDeviceType: CECDeviceTypePlayback, CECInitiator: 16},
+			wantErr: true,
+		},
+		{
+			name:    "valid power commands",
+			cfg:     Config{ConnectionRetries: 5, PowerCommandRetries: 1, RestartRetries: 3, ActiveSourceDeviceType: CECDeviceTypePlayback, PowerCommands: map[int]string{0: "imageviewon", 5: "poweron"}},
+			wantErr: false,
+		},
+		{
+			name:    "unknown power command",
+			cfg:     Config{ConnectionRetries: 5, PowerCommandRetries: 1, RestartRetries: 3, ActiveSourceDeviceType: CECDeviceTypePlayback, PowerCommands: map[int]string{0: "wake"}},
+			wantErr: true,
+		},
+		{
+			name:    "power command for invalid address",
+			cfg:     Config{ConnectionRetries: 5, PowerCommandRetries: 1, RestartRetries: 3, ActiveSourceDeviceType: CECDeviceTypePlayback, PowerCommands: map[int]string{16: "poweron"}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown keymap profile",
+			cfg:     Config{ConnectionRetries: 5, PowerCommandRetries: 1, ActiveSourceDeviceType: CECDeviceTypePlayback, KeyMapProfile: "kodi"},
+			wantErr: true,
+		},
+		{
+			name: "known keymap profile",
+			cfg: Config{ConnectionRetries: 5, PowerCommandRetries: 1, ActiveSourceDeviceType: CECDeviceTypePlayback,
+				KeyMapProfile: "kodi", KeyMapProfiles: map[string]map[string][]int{"kodi": {"Select": {28}}}},
+			wantErr: false,
+		},
+		{
+			name:    "unknown log level",
+			cfg:     Config{ConnectionRetries: 5, PowerCommandRetries: 1, ActiveSourceDeviceType: CECDeviceTypePlayback, LogLevel: "verbose"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown key backend",
+			cfg:     Config{ConnectionRetries: 5, PowerCommandRetries: 1, ActiveSourceDeviceType: CECDeviceTypePlayback, KeyBackend: "xdotool"},
+			wantErr: true,
+		},
+		{
+			name:    "power on method cannot change standby",
+			cfg:     Config{ConnectionRetries: 5, PowerCommandRetries: 1, ActiveSourceDeviceType: CECDeviceTypePlayback, PowerOnMethod: powerCommandUserPower},
+			wantErr: true,
+		},
+		{
+			name:    "on-ready without timeout",
+			cfg:     Config{ConnectionRetries: 5, PowerCommandRetries: 1, ActiveSourceDeviceType: CECDeviceTypePlayback, OnReady: "true"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown cec backend",
+			cfg:     Config{ConnectionRetries: 5, PowerCommandRetries: 1, ActiveSourceDeviceType: CECDeviceTypePlayback, CECBackend: "cec-ctl"},
+			wantErr: true,
+		},
+		{
+			name:    "target tty with ydotool",
+			cfg:     Config{ConnectionRetries: 5, PowerCommandRetries: 1, ActiveSourceDeviceType: CECDeviceTypePlayback, KeyBackend: KeyBackendYdotool, TargetTTY: "/dev/tty3"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown startup event",
+			cfg:     Config{ConnectionRetries: 5, PowerCommandRetries: 1, ActiveSourceDeviceType: CECDeviceTypePlayback, StartupEvent: "off"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown unknown-power-events policy",
+			cfg:     Config{ConnectionRetries: 5, PowerCommandRetries: 1, ActiveSourceDeviceType: CECDeviceTypePlayback, UnknownPowerEvents: "poweroff"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown number mode",
+			cfg:     Config{ConnectionRetries: 5, PowerCommandRetries: 1, ActiveSourceDeviceType: CECDeviceTypePlayback, NumberMode: "digits"},
+			wantErr: true,
+		},
+		{
+			name:    "hdmi port with cec-client",
+			cfg:     Config{ConnectionRetries: 5, PowerCommandRetries: 1, ActiveSourceDeviceType: CECDeviceTypePlayback, CECBackend: CECBackendCECClient, HDMIPort: 2},
+			wantErr: false,
+		},
+		{
+			name:    "hdmi port out of range",
+			cfg:     Config{ConnectionRetries: 5, PowerCommandRetries: 1, ActiveSourceDeviceType: CECDeviceTypePlayback, CECBackend: CECBackendCECClient, HDMIPort: 16},
+			wantErr: true,
+		},
+		{
+			name:    "hdmi port with libcec",
+			cfg:     Config{ConnectionRetries: 5, PowerCommandRetries: 1, ActiveSourceDeviceType: CECDeviceTypePlayback, CECBackend: CECBackendLibcec, HDMIPort: 2},
+			wantErr: true,
+		},
+		{
+			name:    "shift layer",
+			cfg:     Config{ConnectionRetries: 5, PowerCommandRetries: 1, ActiveSourceDeviceType: CECDeviceTypePlayback, LayerShift: &ShiftLayer{Key: "Blue", Actions: map[string]string{"Up": "scroll:up"}}},
+			wantErr: false,
+		},
+		{
+			name:    "shift layer with an unknown modifier",
+			cfg:     Config{ConnectionRetries: 5, PowerCommandRetries: 1, ActiveSourceDeviceType: CECDeviceTypePlayback, LayerShift: &ShiftLayer{Key: "Shift"}},
+			wantErr: true,
+		},
+		{
+			name:    "shift layer with an unknown action",
+			cfg:     Config{ConnectionRetries: 5, PowerCommandRetries: 1, ActiveSourceDeviceType: CECDeviceTypePlayback, LayerShift: &ShiftLayer{Key: "Blue", Actions: map[string]string{"Up": "bogus"}}},
+			wantErr: true,
+		},
+		{
+			name:    "negative command gap",
+			cfg:     Config{ConnectionRetries: 5, PowerCommandRetries: 1, ActiveSourceDeviceType: CECDeviceTypePlayback, CommandGap: -time.Millisecond},
+			wantErr: true,
+		},
+		{
+			name:    "fallback adapter same as the adapter",
+			cfg:     Config{ConnectionRetries: 5, PowerCommandRetries: 1, ActiveSourceDeviceType: CECDeviceTypePlayback, CECAdapter: "/dev/ttyACM0", CECFallbackAdapter: "/dev/ttyACM0"},
+			wantErr: true,
+		},
+		{
+			name:    "negative active source keepalive",
+			cfg:     Config{ConnectionRetries: 5, PowerCommandRetries: 1, ActiveSourceDeviceType: CECDeviceTypePlayback, ActiveSourceKeepalive: -time.Minute},
+			wantErr: true,
+		},
+		{
+			name:    "negative scroll amount",
+			cfg:     Config{ConnectionRetries: 5, PowerCommandRetries: 1, ActiveSourceDeviceType: CECDeviceTypePlayback, ScrollAmount: -1},
+			wantErr: true,
+		},
+		{
+			name:    "unknown remote preset",
+			cfg:     Config{ConnectionRetries: 5, PowerCommandRetries: 1, ActiveSourceDeviceType: CECDeviceTypePlayback, RemotePreset: "roku"},
+			wantErr: true,
+		},
+		{
+			name:    "kodi remote preset",
+			cfg:     Config{ConnectionRetries: 5, PowerCommandRetries: 1, ActiveSourceDeviceType: CECDeviceTypePlayback, RemotePreset: RemotePresetKodi},
+			wantErr: false,
+		},
+		{
+			name:    "ydotool key backend",
+			cfg:     Config{ConnectionRetries: 5, PowerCommandRetries: 1, ActiveSourceDeviceType: CECDeviceTypePlayback, KeyBackend: KeyBackendYdotool},
+			wantErr: false,
+		},
+		{
+			name:    "valid TV device type",
+			cfg:     Config{ConnectionRetries: 5, PowerCommandRetries: 1, RestartRetries: 0, ActiveSourceDeviceType: CECDeviceTypeTV},
+			wantErr: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateConfig(&tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseQuietHours(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 1, 1, hour, minute, 0, 0, time.Local)
+	}
+
+	overnight, err := parseQuietHours("23:00-07:00")
+	if err != nil {
+		t.Fatalf("parseQuietHours failed: %v", err)
+	}
+	for _, tc := range []struct {
+		t        time.Time
+		expected bool
+	}{
+		{at(22, 59), false}, {at(23, 0), true}, {at(3, 0), true}, {at(6, 59), true}, {at(7, 0), false}, {at(12, 0), false},
+	} {
+		if got := overnight.Contains(tc.t); got != tc.expected {
+			t.Errorf("23:00-07:00 contains %s: expected %v, got %v", tc.t.Format("15:04"), tc.expected, got)
+		}
+	}
+
+	daytime, err := parseQuietHours("09:30-17:00")
+	if err != nil {
+		t.Fatalf("parseQuietHours failed: %v", err)
+	}
+	if !daytime.Contains(at(9, 30)) || daytime.Contains(at(17, 0)) || daytime.Contains(at(23, 0)) {
+		t.Error("Unexpected result for 09:30-17:00")
+	}
+
+	if q, err := parseQuietHours(""); q != nil || err != nil {
+		t.Errorf("Expected empty window to disable quiet hours, got %v, %v", q, err)
+	}
+	for _, bad := range []string{"23:00", "25:00-07:00", "07:00-07:00", "night"} {
+		if _, err := parseQuietHours(bad); err == nil {
+			t.Errorf("Expected error for quiet hours %q", bad)
+		}
+	}
+}
+
+func TestParseKeyActions(t *testing.T) {
+	fromFlags := parseKeyActionFlags([]string{"Blue=profile:next", "invalid", "Red="})
+	if len(fromFlags) != 1 || fromFlags["Blue"] != actionProfileNext {
+		t.Errorf("Unexpected actions from flags: %v", fromFlags)
+	}
+
+	fromMap := parseKeyActionsFromMap(map[string]interface{}{"Red": "profile:toggle", "Blue": 3})
+	if len(fromMap) != 1 || fromMap["Red"] != actionProfileToggle {
+		t.Errorf("Unexpected actions from map: %v", fromMap)
+	}
+
+	base := Config{ConnectionRetries: 5, PowerCommandRetries: 1, ActiveSourceDeviceType: CECDeviceTypePlayback}
+	for _, actions := range []map[string]string{{"NotAKey": actionProfileNext}, {"Blue": "profile:random"}} {
+		cfg := base
+		cfg.KeyActions = actions
+		if err := validateConfig(&cfg); err == nil {
+			t.Errorf("Expected validation error for key actions %v", actions)
+		}
+	}
+}
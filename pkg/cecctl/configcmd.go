@@ -0,0 +1,87 @@
+package cecctl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// newConfigCmd returns the config subcommand, which prints the effective
+// configuration for support requests.
+func newConfigCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "config",
+		Short: "Print the effective configuration as JSON",
+		Long: `Loads the configuration exactly as the daemon does (config file, environment
+and defaults) and prints the result as JSON, keyed like the config file. An
+empty queue-dir means the default one ($XDG_RUNTIME_DIR/cec-controller or a
+temporary directory) is created on startup. The on-ready command and the
+dbus-address are redacted since they may hold secrets.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			discardTempQueueDir(cfg)
+			if err := validateConfig(cfg); err != nil {
+				return err
+			}
+			return printConfig(cmd.OutOrStdout(), cfg)
+		},
+	}
+}
+
+// redactedConfigKeys are the keys configValues hides when set: the on-ready
+// command may embed tokens and the D-Bus address may carry a guid or
+// credentials, and the output is meant to be pasted in support requests.
+var redactedConfigKeys = map[string]bool{
+	"on-ready":     true,
+	"dbus-address": true,
+}
+
+// redactedValue replaces the value of a set key in redactedConfigKeys.
+const redactedValue = "<redacted>"
+
+// printConfig writes cfg as indented JSON, as returned by configValues.
+func printConfig(out io.Writer, cfg *Config) error {
+	data, err := json.MarshalIndent(configValues(cfg), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode configuration: %w", err)
+	}
+	_, err = fmt.Fprintln(out, string(data))
+	return err
+}
+
+// configValues returns cfg keyed like the config file, with the keys in
+// redactedConfigKeys hidden. Durations are written the way the config file
+// accepts them: "10s", or milliseconds for the *-ms keys.
+func configValues(cfg *Config) map[string]any {
+	v := reflect.ValueOf(*cfg)
+	t := v.Type()
+	values := make(map[string]any, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		key := t.Field(i).Tag.Get("json")
+		if key == "" {
+			key = t.Field(i).Name
+		}
+		value := v.Field(i).Interface()
+		if d, ok := value.(time.Duration); ok {
+			if strings.HasSuffix(key, "-ms") {
+				value = d.Milliseconds()
+			} else {
+				value = d.String()
+			}
+		}
+		if redactedConfigKeys[key] && !v.Field(i).IsZero() {
+			value = redactedValue
+		}
+		values[key] = value
+	}
+	return values
+}
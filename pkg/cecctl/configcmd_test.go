@@ -0,0 +1,139 @@
+package cecctl
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestPrintConfig(t *testing.T) {
+	cfg := &Config{
+		DeviceName:           "My PC",
+		PowerDevices:         []int{0, 5},
+		UnmappedWarnInterval: 10 * time.Second,
+		StartupSettle:        2 * time.Second,
+		DeviceAliases:        map[int]string{5: "Soundbar"},
+	}
+
+	var out bytes.Buffer
+	if err := printConfig(&out, cfg); err != nil {
+		t.Fatalf("printConfig failed: %v", err)
+	}
+
+	var values map[string]any
+	if err := json.Unmarshal(out.Bytes(), &values); err != nil {
+		t.Fatalf("Output is not valid JSON: %v\n%s", err, out.String())
+	}
+	if values["device-name"] != "My PC" {
+		t.Errorf("Expected device-name 'My PC', got %v", values["device-name"])
+	}
+	if values["unmapped-warn-interval"] != "10s" {
+		t.Errorf("Expected unmapped-warn-interval '10s', got %v", values["unmapped-warn-interval"])
+	}
+	if values["startup-settle-ms"] != float64(2000) {
+		t.Errorf("Expected startup-settle-ms 2000, got %v", values["startup-settle-ms"])
+	}
+	if aliases, ok := values["device-aliases"].(map[string]any); !ok || aliases["5"] != "Soundbar" {
+		t.Errorf("Expected device-aliases to contain 5:Soundbar, got %v", values["device-aliases"])
+	}
+}
+
+func TestPrintConfig_RedactsSecrets(t *testing.T) {
+	cfg := &Config{
+		OnReady:     "curl -H 'Authorization: Bearer s3cret' https://example.com/ready",
+		DBusAddress: "unix:path=/run/dbus/system_bus_socket,guid=0123456789abcdef",
+	}
+
+	var out bytes.Buffer
+	if err := printConfig(&out, cfg); err != nil {
+		t.Fatalf("printConfig failed: %v", err)
+	}
+	if strings.Contains(out.String(), "s3cret") || strings.Contains(out.String(), "guid=") {
+		t.Fatalf("Expected secrets left out of the output, got\n%s", out.String())
+	}
+	var values map[string]any
+	if err := json.Unmarshal(out.Bytes(), &values); err != nil {
+		t.Fatalf("Output is not valid JSON: %v", err)
+	}
+	for _, key := range []string{"on-ready", "dbus-address"} {
+		if values[key] != redactedValue {
+			t.Errorf("Expected %s redacted, got %v", key, values[key])
+		}
+	}
+
+	// Unset keys stay empty so the output still tells they are not used.
+	out.Reset()
+	if err := printConfig(&out, &Config{}); err != nil {
+		t.Fatalf("printConfig failed: %v", err)
+	}
+	values = nil
+	if err := json.Unmarshal(out.Bytes(), &values); err != nil {
+		t.Fatalf("Output is not valid JSON: %v", err)
+	}
+	if values["on-ready"] != "" || values["dbus-address"] != "" {
+		t.Errorf("Expected unset secrets printed empty, got %v and %v", values["on-ready"], values["dbus-address"])
+	}
+}
+
+func TestPrintConfig_CoversExampleKeys(t *testing.T) {
+	viper.Reset()
+	viper.SetConfigFile("../../cec-controller.yaml.example")
+	viper.SetConfigType("yaml")
+	if err := viper.ReadInConfig(); err != nil {
+		t.Fatalf("Failed to read example config: %v", err)
+	}
+	defer viper.Reset()
+
+	var out bytes.Buffer
+	if err := printConfig(&out, &Config{}); err != nil {
+		t.Fatalf("printConfig failed: %v", err)
+	}
+	var values map[string]any
+	if err := json.Unmarshal(out.Bytes(), &values); err != nil {
+		t.Fatalf("Output is not valid JSON: %v", err)
+	}
+
+	for _, key := range viper.AllKeys() {
+		key = strings.SplitN(key, ".", 2)[0]
+		if _, ok := values[key]; !ok {
+			t.Errorf("Config key %q is missing from the config subcommand output, add a json tag to Config", key)
+		}
+	}
+}
+
+func TestSubcommandFlagsOverrideConfigFile(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	root := NewRootCmd()
+	viper.SetConfigType("yaml")
+	if err := viper.ReadConfig(strings.NewReader("devices: [3]\ncontrol-socket: /nonexistent/file.sock\n")); err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+
+	var out bytes.Buffer
+	root.SetOut(&out)
+	root.SetArgs([]string{"config", "--devices", "0,5"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("config failed: %v", err)
+	}
+	var values map[string]any
+	if err := json.Unmarshal(out.Bytes(), &values); err != nil {
+		t.Fatalf("Output is not valid JSON: %v\n%s", err, out.String())
+	}
+	if devices, ok := values["devices"].([]any); !ok || len(devices) != 2 || devices[0] != float64(0) || devices[1] != float64(5) {
+		t.Errorf("Expected --devices to override the config file, got %v", values["devices"])
+	}
+
+	socket := filepath.Join(t.TempDir(), "control.sock")
+	root.SetArgs([]string{"snapshot", "--control-socket", socket})
+	root.SetErr(io.Discard)
+	if err := root.Execute(); err == nil || !strings.Contains(err.Error(), socket) {
+		t.Errorf("Expected snapshot to use --control-socket %s, got %v", socket, err)
+	}
+}
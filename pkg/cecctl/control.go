@@ -0,0 +1,240 @@
+package cecctl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// controlTimeout bounds a control socket exchange.
+const controlTimeout = 5 * time.Second
+
+// controlWaitTimeout bounds the wait for the main loop, which may be busy
+// sending power commands, leaving time within controlTimeout to tell the
+// client so. A variable for the tests.
+var controlWaitTimeout = controlTimeout - time.Second
+
+// Commands of the control socket.
+const (
+	// controlCommandSnapshot asks for a JSON snapshot of the daemon state.
+	controlCommandSnapshot = "snapshot"
+	// controlCommandProfile, followed by a profile name, activates that
+	// keymap profile.
+	controlCommandProfile = "profile"
+)
+
+// controlRequest asks the main loop for a state snapshot on behalf of a
+// control socket client. The main loop sends it on reply.
+type controlRequest struct {
+	reply chan stateSnapshot
+}
+
+// controlReply is written back to a client whose command failed.
+type controlReply struct {
+	Error string `json:"error"`
+}
+
+// profileReply is written back to a client whose profile command succeeded.
+type profileReply struct {
+	Profile string `json:"profile"`
+}
+
+// serveControl listens on the unix socket at path until ctx is done. Each
+// client sends one command line and receives one JSON document; snapshots
+// are gathered by the main loop, which receives them on requests, and
+// profiles are switched on keyMap.
+func serveControl(ctx context.Context, path string, requests chan<- controlRequest, keyMap *KeyMap) error {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("control socket %s is in use by another instance", path)
+	}
+	// A socket left by an instance that did not exit cleanly.
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove stale control socket: %w", err)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on control socket: %w", err)
+	}
+	// The snapshot includes the configuration: keep it to the service user.
+	if err := os.Chmod(path, 0o600); err != nil {
+		ln.Close()
+		return fmt.Errorf("failed to restrict control socket permissions: %w", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.Warn("Control socket stopped accepting connections", "error", err)
+				}
+				return
+			}
+			go handleControlConn(ctx, conn, requests, keyMap)
+		}
+	}()
+	return nil
+}
+
+// handleControlConn answers the command of one control socket client.
+func handleControlConn(ctx context.Context, conn net.Conn, requests chan<- controlRequest, keyMap *KeyMap) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && line == "" {
+		slog.Debug("Failed to read control command", "error", err)
+		return
+	}
+	fields := strings.Fields(line)
+	command := strings.Join(fields, " ")
+	var reply any
+	switch {
+	case command == controlCommandSnapshot:
+		// The deadline of conn does not bound the channel waits.
+		timeout := time.After(controlWaitTimeout)
+		req := controlRequest{reply: make(chan stateSnapshot, 1)}
+		select {
+		case requests <- req:
+			select {
+			case s := <-req.reply:
+				reply = s.document()
+			case <-timeout:
+				reply = controlReply{Error: "busy"}
+			case <-ctx.Done():
+				reply = controlReply{Error: "shutting down"}
+			}
+		case <-timeout:
+			reply = controlReply{Error: "busy"}
+		case <-ctx.Done():
+			reply = controlReply{Error: "shutting down"}
+		}
+	case len(fields) == 2 && fields[0] == controlCommandProfile:
+		if err := keyMap.SetProfile(fields[1]); err != nil {
+			reply = controlReply{Error: err.Error()}
+		} else {
+			slog.Info("Keymap profile switched from the control socket", "profile", fields[1])
+			reply = profileReply{Profile: fields[1]}
+		}
+	default:
+		reply = controlReply{Error: fmt.Sprintf("unknown command %q", command)}
+	}
+	if err := json.NewEncoder(conn).Encode(reply); err != nil {
+		slog.Debug("Failed to write control reply", "command", command, "error", err)
+	}
+}
+
+// requestControl sends command to the control socket at path and returns the
+// JSON document received back.
+func requestControl(path, command string) ([]byte, error) {
+	conn, err := net.DialTimeout("unix", path, controlTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach cec-controller (is it running with --control-socket %s?): %w", path, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	if _, err := io.WriteString(conn, command+"\n"); err != nil {
+		return nil, fmt.Errorf("failed to send control command: %w", err)
+	}
+	data, err := io.ReadAll(conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read control reply: %w", err)
+	}
+	var reply controlReply
+	if err := json.Unmarshal(data, &reply); err != nil {
+		return nil, fmt.Errorf("invalid control reply: %w", err)
+	}
+	if reply.Error != "" {
+		return nil, fmt.Errorf("cec-controller: %s", reply.Error)
+	}
+	return bytes.TrimSpace(data), nil
+}
+
+// newSnapshotCmd returns the snapshot subcommand, which prints the state of
+// the running daemon as JSON.
+func newSnapshotCmd() *cobra.Command {
+	var pretty bool
+	cmd := &cobra.Command{
+		Use:   "snapshot",
+		Short: "Print the state of the running cec-controller as JSON",
+		Long: `Asks the running cec-controller, over its --control-socket, for a snapshot of
+its state and prints it as a single JSON document: configuration, CEC
+connection status, queue depth, last power event, counters and the recent
+events. The same information SIGUSR1 logs, for periodic collection, e.g. from
+cron.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			discardTempQueueDir(cfg)
+			if cfg.ControlSocket == "" {
+				return errors.New("no control socket configured: set control-socket for both the service and this command")
+			}
+			data, err := requestControl(cfg.ControlSocket, controlCommandSnapshot)
+			if err != nil {
+				return err
+			}
+			if pretty {
+				var indented bytes.Buffer
+				if err := json.Indent(&indented, data, "", "  "); err != nil {
+					return err
+				}
+				data = indented.Bytes()
+			}
+			_, err = fmt.Fprintln(cmd.OutOrStdout(), string(data))
+			return err
+		},
+	}
+	cmd.Flags().BoolVar(&pretty, "pretty", false, "Indent the JSON output")
+	return cmd
+}
+
+// newProfileCmd returns the profile subcommand, which switches the keymap
+// profile of the running daemon.
+func newProfileCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "profile <name>",
+		Short: "Activate a keymap profile in the running cec-controller",
+		Long: `Asks the running cec-controller, over its --control-socket, to activate the
+named keymap profile, as a profile key action would. The profile stays active
+until it is switched again or the daemon restarts.`,
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			discardTempQueueDir(cfg)
+			if cfg.ControlSocket == "" {
+				return errors.New("no control socket configured: set control-socket for both the service and this command")
+			}
+			if _, err := requestControl(cfg.ControlSocket, controlCommandProfile+" "+args[0]); err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(cmd.OutOrStdout(), "Keymap profile %q active\n", args[0])
+			return err
+		},
+	}
+}
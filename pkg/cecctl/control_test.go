@@ -0,0 +1,157 @@
+package cecctl
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestControlSocket_Snapshot(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "control.sock")
+	requests := make(chan controlRequest)
+	if err := serveControl(ctx, path, requests, nil); err != nil {
+		t.Fatalf("serveControl failed: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected the socket restricted to its owner, got %v, %v", info, err)
+	}
+	go func() {
+		req := <-requests
+		ev := PowerEvent{Type: PowerSleep, Active: true}
+		req.reply <- stateSnapshot{
+			ConfigValues:     configValues(&Config{DeviceName: "HTPC"}),
+			CECConnected:     true,
+			QueueDepth:       3,
+			LastPowerEvent:   &ev,
+			KeyEventFailures: 2,
+			RecentEvents:     []eventRecord{{Kind: "key", KeyCode: 0}, {Kind: "power", Power: PowerResume}},
+		}
+	}()
+
+	data, err := requestControl(path, controlCommandSnapshot)
+	if err != nil {
+		t.Fatalf("requestControl failed: %v", err)
+	}
+	var doc snapshotDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("Expected a JSON snapshot, got %q: %v", data, err)
+	}
+	if !doc.CECConnected || doc.QueueDepth != 3 || doc.KeyEventFailures != 2 || doc.Config["device-name"] != "HTPC" {
+		t.Errorf("Unexpected snapshot %+v", doc)
+	}
+	if doc.LastPowerEvent != "sleep" {
+		t.Errorf("Expected the last power event labelled sleep, got %q", doc.LastPowerEvent)
+	}
+	if len(doc.RecentEvents) != 2 || doc.RecentEvents[0].KeyCode == nil || *doc.RecentEvents[0].KeyCode != 0 || doc.RecentEvents[1].PowerEvent != "resume" {
+		t.Errorf("Unexpected recent events %+v", doc.RecentEvents)
+	}
+}
+
+func TestControlSocket_UnknownCommand(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "control.sock")
+	if err := serveControl(ctx, path, make(chan controlRequest), nil); err != nil {
+		t.Fatalf("serveControl failed: %v", err)
+	}
+	if _, err := requestControl(path, "reboot"); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Errorf("Expected an unknown command error, got %v", err)
+	}
+}
+
+func TestControlSocket_Profile(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "control.sock")
+	km, err := newKeyMapWithEmitter(nil, &MockKeyboardEmitter{}, nil)
+	if err != nil {
+		t.Fatalf("newKeyMapWithEmitter failed: %v", err)
+	}
+	defer km.Close()
+	km.AddProfile("kodi", map[string][]int{"Select": {28}})
+	if err := serveControl(ctx, path, make(chan controlRequest), km); err != nil {
+		t.Fatalf("serveControl failed: %v", err)
+	}
+
+	data, err := requestControl(path, controlCommandProfile+" kodi")
+	if err != nil {
+		t.Fatalf("requestControl failed: %v", err)
+	}
+	var reply profileReply
+	if err := json.Unmarshal(data, &reply); err != nil || reply.Profile != "kodi" {
+		t.Errorf("Expected the kodi profile in the reply, got %q: %v", data, err)
+	}
+	if km.Profile() != "kodi" {
+		t.Errorf("Expected the kodi profile active, got %q", km.Profile())
+	}
+
+	if _, err := requestControl(path, controlCommandProfile+" plex"); err == nil || !strings.Contains(err.Error(), "unknown keymap profile") {
+		t.Errorf("Expected an unknown profile error, got %v", err)
+	}
+	if km.Profile() != "kodi" {
+		t.Errorf("Expected the kodi profile still active, got %q", km.Profile())
+	}
+	if _, err := requestControl(path, controlCommandProfile); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Errorf("Expected an unknown command error without a profile name, got %v", err)
+	}
+}
+
+func TestControlSocket_Busy(t *testing.T) {
+	defer func(timeout time.Duration) { controlWaitTimeout = timeout }(controlWaitTimeout)
+	controlWaitTimeout = 50 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "control.sock")
+	requests := make(chan controlRequest)
+	if err := serveControl(ctx, path, requests, nil); err != nil {
+		t.Fatalf("serveControl failed: %v", err)
+	}
+
+	// The main loop never takes the request.
+	if _, err := requestControl(path, controlCommandSnapshot); err == nil || !strings.Contains(err.Error(), "busy") {
+		t.Errorf("Expected a busy error, got %v", err)
+	}
+
+	// The main loop takes the request but does not answer in time.
+	go func() { <-requests }()
+	if _, err := requestControl(path, controlCommandSnapshot); err == nil || !strings.Contains(err.Error(), "busy") {
+		t.Errorf("Expected a busy error, got %v", err)
+	}
+}
+
+func TestControlSocket_InUse(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "control.sock")
+	if err := serveControl(ctx, path, make(chan controlRequest), nil); err != nil {
+		t.Fatalf("serveControl failed: %v", err)
+	}
+	if err := serveControl(ctx, path, make(chan controlRequest), nil); err == nil {
+		t.Error("Expected an error for a socket another instance listens on")
+	}
+}
+
+func TestControlSocket_StaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control.sock")
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := serveControl(ctx, path, make(chan controlRequest), nil); err != nil {
+		t.Errorf("Expected a stale socket file replaced, got %v", err)
+	}
+}
+
+func TestRequestControl_NotRunning(t *testing.T) {
+	if _, err := requestControl(filepath.Join(t.TempDir(), "control.sock"), controlCommandSnapshot); err == nil {
+		t.Error("Expected an error without a running instance")
+	}
+}
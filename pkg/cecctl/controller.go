@@ -0,0 +1,694 @@
+package cecctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/claes/cec"
+	"github.com/spf13/cobra"
+	"github.com/spf13/cobra/doc"
+	"github.com/spf13/viper"
+)
+
+// Build metadata shown by --version and in the logs. The cec-controller
+// binary sets them from its own, injected at build time with
+// -ldflags "-X main.Version=... -X main.Commit=... -X main.BuildDate=...".
+var (
+	Version   = "dev"
+	Commit    = "dev"
+	BuildDate = "dev"
+)
+
+// versionString formats the build metadata for --version and the logs.
+func versionString() string {
+	return fmt.Sprintf("%s (commit %s, built %s)", Version, Commit, BuildDate)
+}
+
+// Config is the resolved configuration. The json tags are the config file
+// keys, used by the config subcommand.
+type Config struct {
+	DeviceName             string                      `json:"device-name"`
+	CECAdapter             string                      `json:"cec-adapter"`
+	CECBackend             string                      `json:"cec-backend"`
+	CECFallbackAdapter     string                      `json:"cec-fallback-adapter"`
+	HDMIPort               int                         `json:"hdmi-port"`
+	Debug                  bool                        `json:"debug"`
+	LogLevel               string                      `json:"log-level"`
+	LogFile                string                      `json:"log-file"`
+	LogSyslog              bool                        `json:"log-syslog"`
+	WatchConfig            bool                        `json:"watch-config"`
+	KeyMapOverrides        map[string][]int            `json:"keymap"`
+	KeyMapFile             string                      `json:"keymap-file"`
+	RemotePreset           string                      `json:"remote-preset"`
+	NumberMode             string                      `json:"number-mode"`
+	KeyMapProfiles         map[string]map[string][]int `json:"keymap-profiles"`
+	KeyMapProfile          string                      `json:"keymap-profile"`
+	KeyActions             map[string]string           `json:"key-actions"`
+	LayerShift             *ShiftLayer                 `json:"layer-shift"`
+	IgnoreKeys             []int                       `json:"ignore-keys"`
+	AllowKeys              []int                       `json:"allow-keys"`
+	UnmappedWarnInterval   time.Duration               `json:"unmapped-warn-interval"`
+	DoublePressWindow      time.Duration               `json:"double-press-window"`
+	ScrollAmount           int                         `json:"scroll-amount"`
+	LogKeys                bool                        `json:"log-keys"`
+	EventHistorySize       int                         `json:"event-history-size"`
+	StartupSettle          time.Duration               `json:"startup-settle-ms"`
+	MaxIdleRestart         time.Duration               `json:"max-idle-restart"`
+	OnReady                string                      `json:"on-ready"`
+	OnReadyTimeout         time.Duration               `json:"on-ready-timeout"`
+	NoPowerEvents          bool                        `json:"no-power-events"`
+	StartupEvent           string                      `json:"startup-event"`
+	NoSleepEvents          bool                        `json:"no-sleep-events"`
+	NoResumeEvents         bool                        `json:"no-resume-events"`
+	NoShutdownEvents       bool                        `json:"no-shutdown-events"`
+	UnknownPowerEvents     string                      `json:"unknown-power-events"`
+	QuietHours             string                      `json:"quiet-hours"`
+	ResumeInput            string                      `json:"resume-input"`
+	ResumeOneTouchPlay     bool                        `json:"resume-one-touch-play"`
+	PowerDevices           []int                       `json:"devices"`
+	ConnectionRetries      int                         `json:"retries"`
+	PowerCommandRetries    int                         `json:"power-command-retries"`
+	ReconnectOSD           bool                        `json:"reconnect-osd"`
+	ConfirmPower           bool                        `json:"confirm-power"`
+	CommandGap             time.Duration               `json:"cec-command-gap-ms"`
+	StandbyAllOnShutdown   bool                        `json:"standby-all-on-shutdown"`
+	StandbyOnExit          bool                        `json:"standby-on-exit"`
+	QueueDir               string                      `json:"queue-dir"`
+	RestartRetries         int                         `json:"restart-retries"`
+	RecoverQueue           bool                        `json:"recover-queue"`
+	KeepQueue              bool                        `json:"keep-queue"`
+	QueueDeleteOnRestart   bool                        `json:"queue-delete-on-restart"`
+	SetActiveSource        bool                        `json:"set-active-source"`
+	ActiveSourceKeepalive  time.Duration               `json:"active-source-keepalive"`
+	ActiveSourceDeviceType int                         `json:"active-source-type"`
+	ClaimActiveSource      bool                        `json:"claim-active-source"`
+	DBusAddress            string                      `json:"dbus-address"`
+	ControlSocket          string                      `json:"control-socket"`
+	DeviceAliases          map[int]string              `json:"device-aliases"`
+	PowerCommands          map[int]string              `json:"power-commands"`
+	PowerOnMethod          string                      `json:"power-on-method"`
+	CECInitiator           int                         `json:"cec-initiator"`
+	TVSpeakers             bool                        `json:"tv-speakers"`
+	VolumeAccel            bool                        `json:"volume-accel"`
+	KeyBackend             string                      `json:"key-backend"`
+	TargetTTY              string                      `json:"target-tty"`
+	AllowNoKeyboard        bool                        `json:"allow-no-keyboard"`
+}
+
+// setupLogger logs to stderr, without timestamps since systemd already adds
+// them, and additionally to logFile and syslog when requested. The returned
+// function closes the extra outputs.
+func setupLogger(lvl slog.Leveler, logFile string, useSyslog bool) (func(), error) {
+	handler, closers, err := newLogHandler(lvl, logFile, useSyslog)
+	if err != nil {
+		return nil, err
+	}
+	slog.SetDefault(slog.New(handler))
+	return func() {
+		for _, c := range closers {
+			c.Close()
+		}
+	}, nil
+}
+
+// standbyOnExitTimeout bounds the --standby-on-exit standby, retries
+// included, so stopping the service does not hang on an unresponsive bus.
+const standbyOnExitTimeout = 10 * time.Second
+
+// configureKeyMap applies the key handling settings of cfg to keyMapObj and
+// activates the configured profile. The daemon, replay and test-key all go
+// through it, so a key is resolved the same way by each of them.
+func configureKeyMap(keyMapObj *KeyMap, cfg *Config) error {
+	keyMapObj.SetIgnoredKeys(cfg.IgnoreKeys)
+	keyMapObj.SetAllowedKeys(cfg.AllowKeys)
+	keyMapObj.SetActions(cfg.KeyActions)
+	keyMapObj.SetShiftLayer(cfg.LayerShift)
+	keyMapObj.SetScrollAmount(cfg.ScrollAmount)
+	keyMapObj.SetUnmappedWarnInterval(cfg.UnmappedWarnInterval)
+	keyMapObj.SetDoublePressWindow(cfg.DoublePressWindow)
+	keyMapObj.SetNumberMode(cfg.NumberMode)
+	keyMapObj.SetVolumeAccel(cfg.VolumeAccel)
+	for name, overrides := range cfg.KeyMapProfiles {
+		keyMapObj.AddProfile(name, overrides)
+	}
+	if cfg.KeyMapProfile != "" {
+		return keyMapObj.SetProfile(cfg.KeyMapProfile)
+	}
+	return nil
+}
+
+func runController(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		slog.Error("Failed to load configuration", "error", err)
+		return err
+	}
+
+	if err := validateConfig(cfg); err != nil {
+		slog.Error("Invalid configuration", "error", err)
+		return err
+	}
+
+	lvl, _ := parseLogLevel(cfg.LogLevel) // already checked by validateConfig
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(lvl)
+	closeLogs, err := setupLogger(logLevel, cfg.LogFile, cfg.LogSyslog)
+	if err != nil {
+		slog.Error("Failed to set up logging", "error", err)
+		return err
+	}
+	defer closeLogs()
+
+	slog.Info("Starting cec-controller", "version", Version, "commit", Commit, "buildDate", BuildDate, "config", configValues(cfg))
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	queue, err := NewQueue(ctx, cfg.QueueDir, cfg.RecoverQueue)
+	if err != nil {
+		slog.Error("Failed to initialize event queue", "dir", cfg.QueueDir, "error", err)
+		return err
+	}
+	defer queue.Close()
+	queue.SetKeepOnClose(cfg.KeepQueue)
+	queue.SetDiscardOnRestart(cfg.QueueDeleteOnRestart)
+	// A process started by RestartProcess reuses the queue directory.
+	var recentRestarts int
+	if os.Getenv(queueDirEnvVar) != "" {
+		recentRestarts = logRestarts(cfg.QueueDir, time.Now())
+	}
+
+	c, err := NewCEC(cfg.CECBackend, cfg.CECAdapter, cfg.HDMIPort, cfg.DeviceName, cfg.ConnectionRetries, cfg.PowerCommandRetries, queue.InKeyEvents)
+	if err != nil {
+		slog.Error("Failed to open CEC, you can specify a cec-adapter since auto-detect does not work", "cec-adapter", cfg.CECAdapter, "error", err)
+		return err
+	}
+	defer c.Close()
+
+	// Some TVs send remote buttons as vendor commands instead of user control
+	// codes; turn them into key presses so the keymap can handle them.
+	commands := make(chan *cec.Command, 32)
+	c.SetCommandsChan(commands)
+	c.SetPowerCommands(cfg.PowerCommands)
+	c.SetPowerOnMethod(cfg.PowerOnMethod)
+	c.SetActiveSourceType(cfg.ActiveSourceDeviceType)
+	c.SetContext(ctx)
+	c.SetConfirmPower(cfg.ConfirmPower)
+	c.SetCommandGap(cfg.CommandGap)
+	c.SetReconnectOSD(cfg.ReconnectOSD)
+	c.SetFallbackAdapter(cfg.CECFallbackAdapter)
+	if cfg.CECInitiator >= 0 {
+		c.SetInitiator(cfg.CECInitiator)
+	}
+	vendorCommands := (<-chan *cec.Command)(commands)
+	if cfg.ClaimActiveSource {
+		answered := make(chan *cec.Command, 32)
+		go answerActiveSourceRequests(commands, answered, c, cfg.ActiveSourceDeviceType)
+		vendorCommands = answered
+	}
+	go forwardVendorKeys(ctx, vendorCommands, queue.InKeyEvents)
+
+	// The on-ready command runs in every process, so a restarted one applies
+	// the setup again. Key presses received meanwhile wait in the queue.
+	if cfg.OnReady != "" {
+		if err := runOnReady(ctx, cfg.OnReady, cfg.OnReadyTimeout); err != nil {
+			slog.Warn("On-ready command failed, continuing", "error", err)
+		}
+	}
+
+	// With --tv-speakers, volume keys go over CEC to the TV while every other
+	// key still goes to the virtual keyboard.
+	var volume VolumeController
+	if cfg.TVSpeakers {
+		volume = c
+	}
+	keyMapObj, err := NewKeyMap(cfg.KeyMapOverrides, cfg.RemotePreset, cfg.KeyBackend, cfg.TargetTTY, volume)
+	if err != nil {
+		logArgs := []any{"error", err}
+		var uerr *uinputError
+		if errors.As(err, &uerr) && uerr.Hint != "" {
+			logArgs = append(logArgs, "fix", uerr.Hint)
+		}
+		if !cfg.AllowNoKeyboard {
+			slog.Error("Failed to initialize virtual keyboard", logArgs...)
+			return err
+		}
+		// Keep handling power events and CEC volume keys; every other key
+		// press is dropped.
+		slog.Error("Failed to initialize virtual keyboard, continuing without key events", logArgs...)
+		keyMapObj, _ = newKeyMapWithPreset(cfg.KeyMapOverrides, cfg.RemotePreset, noopEmitter{}, volume)
+	}
+	defer keyMapObj.Close()
+	if err := configureKeyMap(keyMapObj, cfg); err != nil {
+		slog.Error("Failed to activate keymap profile", "error", err)
+		return err
+	}
+	keyMapObj.SetPowerController(c)
+	// The virtual wheel is a separate uinput device, only created when a key
+	// scrolls.
+	if usesScrollActions(cfg.KeyActions) || (cfg.LayerShift != nil && usesScrollActions(cfg.LayerShift.Actions)) {
+		wheel, err := newUinputWheel(uinputPath)
+		if err != nil {
+			slog.Error("Failed to create the virtual wheel, scroll actions are disabled", "error", err)
+		} else {
+			defer wheel.Close()
+			keyMapObj.SetWheelEmitter(wheel)
+		}
+	}
+
+	// Claim active source on startup so the TV switches input to this device.
+	if cfg.SetActiveSource {
+		if !c.SetActiveSource(cfg.ActiveSourceDeviceType) {
+			slog.Warn("Failed to set active source on startup")
+		} else {
+			slog.Info("Active source set", "deviceType", cfg.ActiveSourceDeviceType)
+		}
+	}
+	if cfg.ActiveSourceKeepalive > 0 {
+		go c.KeepActiveSource(ctx, cfg.ActiveSourceKeepalive, cfg.ActiveSourceDeviceType)
+	}
+
+	// Open a D-Bus connection for logind inhibitor locks (sleep/shutdown protection).
+	// Non-fatal: if unavailable, CEC commands run without holding a delay lock.
+	var dbusConn, dbusErr = openSystemBus(cfg.DBusAddress)
+	if dbusErr != nil {
+		slog.Warn("Failed to connect to D-Bus, inhibitor locks will be skipped until it is reachable", "error", dbusErr)
+		dbusConn = nil
+	}
+
+	if !cfg.NoPowerEvents {
+		// Send an initial PowerOn (or PowerResume, see --startup-event) so
+		// devices wake up when this service starts.
+		if startup, ok := startupPowerEvent(cfg.StartupEvent); ok {
+			// Freshly opened adapters can drop the first command while the bus is
+			// still negotiating, so wait for it to answer before waking devices.
+			if cfg.StartupSettle > 0 && !c.WaitReady(ctx, cfg.StartupSettle) {
+				slog.Warn("CEC bus not ready after startup settle time, sending initial power on anyway", "settle", cfg.StartupSettle)
+			}
+			queue.InPowerEvents <- startup
+		}
+		// Non-fatal: on systems without a reachable logind we keep handling keys.
+		if err := PowerEventListener(ctx, cfg.DBusAddress, cfg.powerEventSources(), queue.InPowerEvents); err != nil {
+			slog.Warn("Failed to start power event listener, retrying until logind is reachable", "error", err)
+			go retryPowerEventListener(ctx, cfg.DBusAddress, cfg.powerEventSources(), queue.InPowerEvents, realClock{})
+		}
+	}
+
+	// SIGUSR1 dumps a state snapshot to the log for field debugging; the
+	// snapshot subcommand asks for it over the control socket.
+	dumpSignals := make(chan os.Signal, 1)
+	signal.Notify(dumpSignals, syscall.SIGUSR1)
+	defer signal.Stop(dumpSignals)
+	controlRequests := make(chan controlRequest)
+	if cfg.ControlSocket != "" {
+		if err := serveControl(ctx, cfg.ControlSocket, controlRequests, keyMapObj); err != nil {
+			slog.Warn("Failed to open the control socket, continuing without it", "path", cfg.ControlSocket, "error", err)
+		}
+	}
+
+	// SIGHUP re-reads the configuration and applies the settings listed in
+	// reloadableKeys; --watch-config does the same when the file changes.
+	reloadSignals := make(chan os.Signal, 1)
+	signal.Notify(reloadSignals, syscall.SIGHUP)
+	defer signal.Stop(reloadSignals)
+	configChanged := make(chan struct{}, 1)
+	if cfg.WatchConfig {
+		if err := watchConfigFile(ctx, configFilePath, configWatchDebounce, realClock{}, configChanged); err != nil {
+			slog.Warn("Failed to watch the config file, continuing without automatic reloads", "path", configFilePath, "error", err)
+		}
+	}
+
+	history := NewEventHistory(cfg.EventHistorySize)
+	var lastPowerEvent *PowerEvent
+	var lastPowerEventAt time.Time
+	var powerEvents powerSequence
+	var keyEventFailures uint64
+
+	// With --max-idle-restart, restart when nothing happened for too long and
+	// the adapter does not answer anymore. A nil channel never fires.
+	var watchdog *idleWatchdog
+	var watchdogFired <-chan struct{}
+	if cfg.MaxIdleRestart > 0 {
+		watchdog = newIdleWatchdog(cfg.MaxIdleRestart, c.connectionAlive)
+		watchdogFired = watchdog.Run(ctx)
+	}
+
+	restartProcess := func(reason string) error {
+		cancel()
+		if !queue.RestartProcess(cfg.RestartRetries, reason) {
+			slog.Error("Process restart failed or no retries left, exiting")
+			return fmt.Errorf("too many restarts")
+		}
+		return nil
+	}
+
+	slog.Info("Listening for CEC key and power events... (Ctrl+C to exit)")
+	for {
+		select {
+		case kp := <-queue.OutKeyEvents:
+			if watchdog != nil {
+				watchdog.Touch()
+			}
+			if kp == nil {
+				continue
+			}
+			if kp.Duration != 0 {
+				// libcec reports the release with the hold duration in ms.
+				keyMapObj.OnKeyEvent(kp.KeyCode, time.Duration(kp.Duration)*time.Millisecond)
+				continue
+			}
+			history.RecordKey(kp.KeyCode)
+			if cfg.LogKeys {
+				name, ok := keyName(kp.KeyCode)
+				if !ok {
+					name = "unknown"
+				}
+				slog.Info("CEC key pressed", "cec-key-code", fmt.Sprintf("0x%02X", kp.KeyCode), "name", name)
+			}
+			keyMapObj.OnKeyEvent(kp.KeyCode, 0)
+		case ev := <-queue.OutPowerEvents:
+			lastPowerEvent, lastPowerEventAt = &ev, time.Now()
+			history.RecordPower(ev)
+			if watchdog != nil {
+				watchdog.Touch()
+			}
+			if !powerEvents.accept(ev) {
+				slog.Warn("Shutdown in progress, ignoring power event", "event", ev.Type)
+				continue
+			}
+			action := planPowerAction(cfg, ev, time.Now())
+			if action.Skip != "" {
+				switch {
+				case action.Unknown:
+					slog.Warn("Unhandled power event, ignoring it", "event", ev.Type, "active", ev.Active, "unknown-power-events", cfg.UnknownPowerEvents)
+				case ev.Type == PowerShutdown:
+					slog.Info("Shutdown cancelled, leaving devices as they are")
+				default:
+					slog.Info("Quiet hours, not powering on devices", "quiet-hours", cfg.QuietHours, "event", ev.Type)
+				}
+				continue
+			}
+			var err error
+			switch {
+			case action.OneTouchPlay:
+				slog.Info("Sending One Touch Play", "devices", action.Devices, "names", deviceLabels(action.Devices, cfg.DeviceAliases))
+				err = c.OneTouchPlay()
+				if len(action.Devices) > 0 {
+					err = errors.Join(err, c.PowerOn(action.Devices...))
+				}
+			case action.PowerOn:
+				slog.Info("Powering on devices", "devices", action.Devices, "names", deviceLabels(action.Devices, cfg.DeviceAliases))
+				err = c.PowerOn(action.Devices...)
+			case action.Unknown:
+				slog.Warn("Unhandled power event, putting devices to standby", "event", ev.Type, "devices", action.Devices, "names", deviceLabels(action.Devices, cfg.DeviceAliases))
+				err = c.Standby(action.Devices...)
+			default:
+				if action.StandbyAll {
+					slog.Info("Putting every device to standby")
+				} else {
+					slog.Info("Putting devices to standby", "devices", action.Devices, "names", deviceLabels(action.Devices, cfg.DeviceAliases))
+				}
+				// Hold a logind delay inhibitor so the system waits for CEC
+				// standby to complete before proceeding with sleep/shutdown.
+				// The bus may have come up, or restarted, since startup.
+				if dbusConn == nil || !dbusConn.Connected() {
+					if dbusConn != nil {
+						dbusConn.Close()
+					}
+					dbusConn, _ = openSystemBus(cfg.DBusAddress)
+				}
+				lock, lockErr := acquireInhibitor(dbusConn, "sleep:shutdown", "Sending CEC standby command")
+				if lockErr != nil {
+					slog.Warn("Failed to acquire inhibitor lock", "error", lockErr)
+				}
+				if action.StandbyAll {
+					err = c.StandbyAll()
+				} else {
+					err = c.Standby(action.Devices...)
+				}
+				lock.Release()
+			}
+			if action.StreamPath != "" {
+				slog.Info("Switching TV input", "resume-input", action.StreamPath)
+				streamPath, _ := parsePhysicalAddress(action.StreamPath) // already checked by validateConfig
+				if err := c.SetStreamPath(streamPath); err != nil {
+					slog.Warn("Failed to switch TV input", "resume-input", action.StreamPath, "error", err)
+				}
+			}
+			targets := action.targets()
+			failed := failedAddresses(err)
+			switch {
+			case err == nil:
+			case len(failed) < len(targets):
+				// Some devices answered so the connection works, no need to restart.
+				slog.Warn("Power command failed for some devices", "failed", failed, "names", deviceLabels(failed, cfg.DeviceAliases), "error", err)
+			case ctx.Err() != nil:
+				// Retries were cut short by the shutdown, restarting would undo it.
+				slog.Warn("Power command interrupted by shutdown", "error", err)
+			case !powerFailureNeedsRestart(err):
+				// Every device rejected the command over a working connection.
+				slog.Warn("Power command rejected by every device", "error", err)
+			default:
+				slog.Warn("Failed to send power command after connection reopen, libcec is weird so we need to restart the current process...", "error", err)
+				reason := "power command failed for every device after reopening the connection"
+				if errors.Is(err, errAdapterGone) {
+					reason = "CEC adapter could not be reopened for a power command"
+				}
+				if err := restartProcess(reason); err != nil {
+					return err
+				}
+			}
+		case <-watchdogFired:
+			slog.Warn("No events processed and CEC adapter not answering, restarting the current process...", "max-idle", cfg.MaxIdleRestart)
+			if err := restartProcess("no events processed and CEC adapter not answering"); err != nil {
+				return err
+			}
+		case <-keyMapObj.KeyEventErrors:
+			keyEventFailures++
+		case <-dumpSignals:
+			s := snapshotState(cfg, c, queue, history, lastPowerEvent, lastPowerEventAt)
+			s.KeyEventFailures = keyEventFailures
+			s.RecentRestarts = recentRestarts
+			s.log()
+		case req := <-controlRequests:
+			s := snapshotState(cfg, c, queue, history, lastPowerEvent, lastPowerEventAt)
+			s.KeyEventFailures = keyEventFailures
+			s.RecentRestarts = recentRestarts
+			req.reply <- s
+		case <-reloadSignals:
+			reloadConfig(cfg, c, keyMapObj, logLevel)
+		case <-configChanged:
+			slog.Info("Config file changed, reloading", "path", configFilePath)
+			reloadConfig(cfg, c, keyMapObj, logLevel)
+		case <-ctx.Done():
+			slog.Info("Shutting down...")
+			if cfg.StandbyOnExit {
+				// Sent here rather than through the queue, which closes
+				// right after.
+				slog.Info("Putting devices to standby before exiting", "devices", cfg.PowerDevices, "names", deviceLabels(cfg.PowerDevices, cfg.DeviceAliases))
+				if err := c.StandbyOnExit(standbyOnExitTimeout, cfg.PowerDevices...); err != nil {
+					slog.Warn("Failed to put devices to standby before exiting", "error", err)
+				}
+			}
+			return nil
+		}
+	}
+}
+
+// NewRootCmd returns the cec-controller command with its subcommands. Its
+// flags are persistent, so they override the config file for the
+// subcommands loading the configuration too.
+func NewRootCmd() *cobra.Command {
+	var rootCmd = &cobra.Command{
+		Use:   "cec-controller",
+		Short: "HDMI-CEC controller for Linux",
+		Long: `CEC Controller is a Linux CLI application that listens for HDMI-CEC key events
+and translates them to Linux virtual keyboard actions. It also reacts to system
+power events (startup, shutdown, sleep, resume).`,
+		Version: versionString(),
+		RunE:    runController,
+	}
+	rootCmd.SetVersionTemplate("{{.Name}} {{.Version}}\n")
+
+	rootCmd.PersistentFlags().String("cec-adapter", "", "CEC adapter path (leave empty for auto-detect)")
+	rootCmd.PersistentFlags().Int("hdmi-port", 0, "TV HDMI port the adapter is plugged into, to derive the physical address instead of detecting it (0 to detect; cec-client backend only)")
+	rootCmd.PersistentFlags().String("cec-fallback-adapter", "", "Second CEC adapter used when cec-adapter cannot be reopened, until it can")
+	rootCmd.PersistentFlags().String("cec-backend", CECBackendLibcec, "How the adapter is driven: libcec (built-in bindings) or cec-client (runs the cec-client binary)")
+	rootCmd.PersistentFlags().String("device-name", "", "Device name shown on your TV (leave empty for hostname)")
+	rootCmd.PersistentFlags().Bool("debug", false, "Enable debug output (shortcut for --log-level debug)")
+	rootCmd.PersistentFlags().String("log-level", "info", "Log level: error, warn, info or debug")
+	rootCmd.PersistentFlags().String("log-file", "", "Also append logs to this file")
+	rootCmd.PersistentFlags().Bool("log-syslog", false, "Also send logs to syslog")
+	rootCmd.PersistentFlags().Bool("watch-config", false, "Reload the config file when it changes, like SIGHUP")
+	rootCmd.PersistentFlags().Bool("no-power-events", false, "Disable power event handling")
+	rootCmd.PersistentFlags().Bool("no-sleep-events", false, "Do not put devices to standby when the system goes to sleep")
+	rootCmd.PersistentFlags().Bool("no-resume-events", false, "Do not power on devices when the system resumes from sleep")
+	rootCmd.PersistentFlags().Bool("standby-all-on-shutdown", false, "On shutdown, broadcast standby to every device on the bus instead of only --devices (sleep still targets --devices)")
+	rootCmd.PersistentFlags().Bool("standby-on-exit", false, "Put --devices to standby when the service stops (SIGTERM or SIGINT), before closing the CEC connection")
+	rootCmd.PersistentFlags().Bool("no-shutdown-events", false, "Do not put devices to standby when the system shuts down")
+	rootCmd.PersistentFlags().String("unknown-power-events", UnknownPowerEventsIgnore, "What to do with power events of an unknown type: ignore (log them) or standby (put devices to standby, fail-safe)")
+	rootCmd.PersistentFlags().Int("retries", 5, "Number of times to retry opening the CEC adapter on failure (each attempt may take up to 10s)")
+	rootCmd.PersistentFlags().Int("power-command-retries", 1, "Number of attempts for a power command before reopening the CEC connection")
+	rootCmd.PersistentFlags().Bool("reconnect-osd", false, "Show a short message on the TV after the CEC connection was reopened")
+	rootCmd.PersistentFlags().Bool("confirm-power", false, "Check the device power status after a power command and retry when it did not change")
+	rootCmd.PersistentFlags().Int("cec-command-gap-ms", 0, "Pause in milliseconds between the devices of a power command, for slow devices that drop commands (0 disables)")
+	rootCmd.PersistentFlags().StringSlice("keymap", []string{}, "Custom CEC-to-Linux key mapping (format <cec>:<linux>, e.g. --keymap 1:105)")
+	rootCmd.PersistentFlags().String("remote-preset", RemotePresetDesktop, "Base key mapping the keymap applies on top of: desktop, kodi or androidtv")
+	rootCmd.PersistentFlags().String("number-mode", NumberModeDirect, "How number keys are sent: direct (each digit at once) or channel (digits buffered and sent with Enter after a short pause)")
+	rootCmd.PersistentFlags().String("keymap-file", "", "YAML or CSV file of key mappings, applied under --keymap (a missing file is ignored)")
+	rootCmd.PersistentFlags().String("keymap-profile", "", "Keymap profile to activate on startup (profiles are defined in the config file under keymap-profiles)")
+	rootCmd.PersistentFlags().StringSlice("key-action", []string{}, "Bind a CEC key to an action instead of a keystroke (format <cec>=<action>, e.g. --key-action Blue=profile:next)")
+	rootCmd.PersistentFlags().StringSlice("ignore-keys", []string{}, "CEC keys to drop silently, by name or code (e.g. --ignore-keys Select,0x91)")
+	rootCmd.PersistentFlags().StringSlice("allow-keys", []string{}, "Only handle these CEC keys, by name or code, and drop every other one silently (e.g. --allow-keys Up,Down,Select)")
+	rootCmd.PersistentFlags().Duration("double-press-window", defaultDoublePressWindow, "Maximum time between the two presses of a double press, for key-actions bound to <cec>:double (0 disables double presses)")
+	rootCmd.PersistentFlags().Int("scroll-amount", defaultScrollAmount, "Wheel clicks sent per press of a key bound to a scroll:up/down/left/right action (0 uses the default)")
+	rootCmd.PersistentFlags().Bool("log-keys", false, "Log every CEC key press with its code and name, to help writing a keymap")
+	rootCmd.PersistentFlags().Duration("unmapped-warn-interval", 10*time.Second, "Minimum time between two \"Unmapped CEC key code\" warnings for the same key (0 warns on every press)")
+	rootCmd.PersistentFlags().String("quiet-hours", "", "Local time window during which devices are not powered on, e.g. 23:00-07:00 (standby still works)")
+	rootCmd.PersistentFlags().String("resume-input", "", "Physical address of the HDMI input the TV is switched to on resume, e.g. 2.0.0.0")
+	rootCmd.PersistentFlags().Bool("resume-one-touch-play", false, "On resume, wake the TV with CEC One Touch Play (Image View On and Active Source) instead of a plain power on")
+	rootCmd.PersistentFlags().StringSlice("devices", []string{}, "Power event device addresses (e.g. --devices 0,1). Defaults to 0.")
+	rootCmd.PersistentFlags().Int("event-history-size", 50, "Number of recent events kept in memory and included in the SIGUSR1 state dump (0 disables)")
+	rootCmd.PersistentFlags().String("startup-event", StartupEventOn, "Power event sent when the service starts: on, resume (also switches to --resume-input) or none")
+	rootCmd.PersistentFlags().Int("startup-settle-ms", 2000, "Maximum time in milliseconds to wait for the CEC bus to answer before sending the startup power on (0 disables)")
+	rootCmd.PersistentFlags().String("queue-dir", "", "Directory for event queue (defaults to $XDG_RUNTIME_DIR/cec-controller, or a temporary directory)")
+	rootCmd.PersistentFlags().String("on-ready", "", "Shell command run once the CEC connection is established, on every start and restart")
+	rootCmd.PersistentFlags().Duration("on-ready-timeout", 30*time.Second, "Time after which the --on-ready command is killed")
+	rootCmd.PersistentFlags().Duration("max-idle-restart", 0, "Restart the process when no event was processed for this long and the CEC adapter does not answer (0 disables)")
+	rootCmd.PersistentFlags().Int("restart-retries", 3, "Maximum number of process restarts when the CEC library gets stuck (0 disables restart)")
+	rootCmd.PersistentFlags().Bool("keep-queue", false, "Keep the event queue directory on shutdown so pending events survive a clean restart (set queue-dir to a stable path)")
+	rootCmd.PersistentFlags().Bool("queue-delete-on-restart", false, "Discard the pending events on an automatic restart instead of handing them to the restarted process")
+	rootCmd.PersistentFlags().Bool("recover-queue", false, "Move an event queue store that cannot be opened aside and start with an empty one (always done after an automatic restart)")
+	rootCmd.PersistentFlags().Bool("set-active-source", false, "Claim active source on startup so the TV switches input to this device")
+	rootCmd.PersistentFlags().Duration("active-source-keepalive", 0, "Claim active source again at this interval, for TVs that switch back to another input (0 disables it; paused while devices are in standby)")
+	rootCmd.PersistentFlags().Bool("claim-active-source", false, "Answer the TV's \"Request Active Source\" by claiming active source, so it switches to this device when it powers on")
+	rootCmd.PersistentFlags().Int("active-source-type", CECDeviceTypePlayback, "CEC device type for active source claim (0=TV 1=Recording 3=Tuner 4=Playback 5=AudioSystem)")
+	rootCmd.PersistentFlags().StringSlice("device-aliases", []string{}, "Friendly names for device addresses used in logs (format <address>:<name>, e.g. --device-aliases 0:TV,5:Soundbar)")
+	rootCmd.PersistentFlags().StringSlice("power-commands", []string{}, "CEC command used to power a device on and off, per address (format <address>:<command>, e.g. --power-commands 0:imageviewon); commands: poweron, imageviewon, textviewon, poweron+imageviewon, userpower")
+	rootCmd.PersistentFlags().String("power-on-method", powerCommandDefault, "How devices without power-commands are powered on: poweron, imageviewon, textviewon or poweron+imageviewon")
+	rootCmd.PersistentFlags().Int("cec-initiator", -1, "Logical address the raw CEC commands (power-commands, mute) are sent from, e.g. 0 to pretend to be the TV (-1 for this adapter's address)")
+	rootCmd.PersistentFlags().Bool("tv-speakers", false, "Send volume and mute keys over CEC to the TV/audio system instead of the virtual keyboard")
+	rootCmd.PersistentFlags().Bool("volume-accel", false, "With --tv-speakers, send more volume steps per press while a volume key is held")
+	rootCmd.PersistentFlags().String("target-tty", "", "Type keys into this virtual terminal (e.g. /dev/tty3) instead of the uinput virtual keyboard, for console setups")
+	rootCmd.PersistentFlags().String("key-backend", KeyBackendUinput, "Key emission backend: uinput (built-in virtual keyboard) or ydotool (shells out to ydotool, can work better on Wayland)")
+	rootCmd.PersistentFlags().Bool("allow-no-keyboard", false, "Keep running power and volume handling when the virtual keyboard cannot be created (e.g. no uinput access)")
+	rootCmd.PersistentFlags().String("control-socket", "", "Unix socket the snapshot subcommand reads the daemon state from (disabled when empty)")
+	rootCmd.PersistentFlags().String("dbus-address", "", "D-Bus address used to reach logind (defaults to the system bus, honours DBUS_SYSTEM_BUS_ADDRESS)")
+
+	mustBind := func(key, flag string) {
+		if err := viper.BindPFlag(key, rootCmd.PersistentFlags().Lookup(flag)); err != nil {
+			slog.Warn("Failed to bind flag", "key", key, "flag", flag, "error", err)
+		}
+	}
+	mustBind("cec-adapter", "cec-adapter")
+	mustBind("cec-fallback-adapter", "cec-fallback-adapter")
+	mustBind("hdmi-port", "hdmi-port")
+	mustBind("cec-backend", "cec-backend")
+	mustBind("device-name", "device-name")
+	mustBind("debug", "debug")
+	mustBind("log-level", "log-level")
+	mustBind("log-file", "log-file")
+	mustBind("log-syslog", "log-syslog")
+	mustBind("watch-config", "watch-config")
+	mustBind("no-power-events", "no-power-events")
+	mustBind("no-sleep-events", "no-sleep-events")
+	mustBind("no-resume-events", "no-resume-events")
+	mustBind("no-shutdown-events", "no-shutdown-events")
+	mustBind("standby-all-on-shutdown", "standby-all-on-shutdown")
+	mustBind("standby-on-exit", "standby-on-exit")
+	mustBind("unknown-power-events", "unknown-power-events")
+	mustBind("retries", "retries")
+	mustBind("power-command-retries", "power-command-retries")
+	mustBind("reconnect-osd", "reconnect-osd")
+	mustBind("confirm-power", "confirm-power")
+	mustBind("cec-command-gap-ms", "cec-command-gap-ms")
+	mustBind("keymap", "keymap")
+	mustBind("keymap-file", "keymap-file")
+	mustBind("remote-preset", "remote-preset")
+	mustBind("number-mode", "number-mode")
+	mustBind("keymap-profile", "keymap-profile")
+	mustBind("key-actions", "key-action")
+	mustBind("ignore-keys", "ignore-keys")
+	mustBind("allow-keys", "allow-keys")
+	mustBind("unmapped-warn-interval", "unmapped-warn-interval")
+	mustBind("double-press-window", "double-press-window")
+	mustBind("scroll-amount", "scroll-amount")
+	mustBind("log-keys", "log-keys")
+	mustBind("quiet-hours", "quiet-hours")
+	mustBind("resume-input", "resume-input")
+	mustBind("resume-one-touch-play", "resume-one-touch-play")
+	mustBind("devices", "devices")
+	mustBind("event-history-size", "event-history-size")
+	mustBind("startup-event", "startup-event")
+	mustBind("startup-settle-ms", "startup-settle-ms")
+	mustBind("queue-dir", "queue-dir")
+	mustBind("max-idle-restart", "max-idle-restart")
+	mustBind("on-ready", "on-ready")
+	mustBind("on-ready-timeout", "on-ready-timeout")
+	mustBind("restart-retries", "restart-retries")
+	mustBind("recover-queue", "recover-queue")
+	mustBind("keep-queue", "keep-queue")
+	mustBind("queue-delete-on-restart", "queue-delete-on-restart")
+	mustBind("set-active-source", "set-active-source")
+	mustBind("active-source-keepalive", "active-source-keepalive")
+	mustBind("claim-active-source", "claim-active-source")
+	mustBind("active-source-type", "active-source-type")
+	mustBind("dbus-address", "dbus-address")
+	mustBind("control-socket", "control-socket")
+	mustBind("device-aliases", "device-aliases")
+	mustBind("power-commands", "power-commands")
+	mustBind("power-on-method", "power-on-method")
+	mustBind("cec-initiator", "cec-initiator")
+	mustBind("tv-speakers", "tv-speakers")
+	mustBind("volume-accel", "volume-accel")
+	mustBind("key-backend", "key-backend")
+	mustBind("target-tty", "target-tty")
+	mustBind("allow-no-keyboard", "allow-no-keyboard")
+
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "version",
+		Short: "Print the version, git commit and build date",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Fprintf(cmd.OutOrStdout(), "cec-controller %s\n", versionString())
+		},
+	})
+
+	rootCmd.AddCommand(newTestKeyCmd())
+	rootCmd.AddCommand(newConfigCmd())
+	rootCmd.AddCommand(newDoctorCmd())
+	rootCmd.AddCommand(newReplayCmd())
+	rootCmd.AddCommand(newLearnCmd())
+	rootCmd.AddCommand(newSnapshotCmd())
+	rootCmd.AddCommand(newProfileCmd())
+
+	// Hidden subcommand to generate man pages into a target directory.
+	// Usage: cec-controller generate-docs --output-dir /usr/share/man/man1
+	var outputDir string
+	generateDocsCmd := &cobra.Command{
+		Use:    "generate-docs",
+		Short:  "Generate man pages for cec-controller",
+		Hidden: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := os.MkdirAll(outputDir, 0755); err != nil {
+				return fmt.Errorf("failed to create output directory: %w", err)
+			}
+			header := &doc.GenManHeader{
+				Title:   "CEC-CONTROLLER",
+				Section: "1",
+				Source:  "cec-controller",
+				Manual:  "General Commands Manual",
+			}
+			return doc.GenManTree(rootCmd, header, outputDir)
+		},
+	}
+	generateDocsCmd.Flags().StringVar(&outputDir, "output-dir", ".", "Directory to write man pages into")
+	rootCmd.AddCommand(generateDocsCmd)
+	return rootCmd
+}
@@ -0,0 +1,14 @@
+// Package cecctl is the HDMI-CEC controller behind the cec-controller
+// command, for Go programs that want to drive a TV without running the
+// binary.
+//
+// CEC opens the adapter, sends power and volume commands and reopens the
+// adapter when it stops answering. KeyMap turns the CEC key presses it
+// receives into Linux key events, through a KeyboardEmitter. Queue buffers
+// power and key events on disk, and PowerEventListener reports the system
+// power changes from logind. VolumeController is what the volume keys are
+// sent to instead of the keyboard, e.g. a CEC.
+//
+// NewRootCmd returns the whole cec-controller command line, for programs
+// embedding it.
+package cecctl
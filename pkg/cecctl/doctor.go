@@ -0,0 +1,176 @@
+package cecctl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// doctorCheck is one environment check run by the doctor subcommand. run
+// returns a short detail on success, or an error and a remediation hint.
+type doctorCheck struct {
+	name     string
+	critical bool
+	run      func() (detail string, hint string, err error)
+}
+
+// newDoctorCmd returns the doctor subcommand, which checks everything the
+// daemon needs and explains how to fix what is missing.
+func newDoctorCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "doctor",
+		Short: "Check the CEC adapter, virtual keyboard, logind and queue directory",
+		Long: `Loads the configuration and checks that the CEC adapter can be opened, the
+virtual keyboard backend is usable, logind is reachable on the system bus and
+the queue directory is writable. Prints a pass/fail table with hints and exits
+non-zero if a critical check fails.
+
+Stop a running cec-controller first: the adapter can only be opened once.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			discardTempQueueDir(cfg)
+			if err := validateConfig(cfg); err != nil {
+				return err
+			}
+			return runDoctor(cmd.OutOrStdout(), doctorChecks(cfg))
+		},
+	}
+}
+
+// doctorChecks returns the checks for cfg. A check is critical when the
+// daemon cannot do its job without it under this configuration.
+func doctorChecks(cfg *Config) []doctorCheck {
+	return []doctorCheck{
+		{name: "CEC adapter", critical: true, run: func() (string, string, error) {
+			return checkCECAdapter(cfg.CECBackend, cfg.CECAdapter, cfg.HDMIPort, cfg.DeviceName)
+		}},
+		{name: "Virtual keyboard", critical: !cfg.AllowNoKeyboard, run: func() (string, string, error) {
+			return checkKeyBackend(cfg.KeyBackend)
+		}},
+		{name: "logind", critical: !cfg.NoPowerEvents, run: func() (string, string, error) {
+			return checkLogind(cfg.DBusAddress)
+		}},
+		{name: "Queue directory", critical: true, run: func() (string, string, error) {
+			return checkQueueDir(cfg.QueueDir)
+		}},
+	}
+}
+
+// runDoctor runs every check, prints the results and returns an error if a
+// critical check failed.
+func runDoctor(out io.Writer, checks []doctorCheck) error {
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tSTATUS\tDETAILS")
+	var hints []string
+	failed := 0
+	for _, check := range checks {
+		detail, hint, err := check.run()
+		status := "PASS"
+		if err != nil {
+			status = "WARN"
+			if check.critical {
+				status = "FAIL"
+				failed++
+			}
+			detail = err.Error()
+			if hint != "" {
+				hints = append(hints, fmt.Sprintf("%s: %s", check.name, hint))
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", check.name, status, detail)
+	}
+	tw.Flush()
+
+	if len(hints) > 0 {
+		fmt.Fprintln(out, "\nHow to fix:")
+		for _, hint := range hints {
+			fmt.Fprintf(out, "  - %s\n", hint)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d critical check(s) failed", failed)
+	}
+	return nil
+}
+
+func checkCECAdapter(backend, adapter string, hdmiPort int, deviceName string) (string, string, error) {
+	opener, err := cecOpenerFor(backend, hdmiPort)
+	if err != nil {
+		return "", "", err
+	}
+	conn, err := opener(adapter, deviceName)
+	if err != nil {
+		if backend == CECBackendCECClient {
+			return "", "install cec-client (libcec's utilities, e.g. the cec-utils package) and check that it can open " +
+				"the adapter on its own", err
+		}
+		return "", "check that the adapter is plugged in, that the user can access it (e.g. the dialout group for " +
+			"/dev/ttyACM0) and that nothing else (cec-client, a running cec-controller) holds it", err
+	}
+	conn.Close()
+	if adapter == "" {
+		return "opened (auto-detected)", "", nil
+	}
+	return "opened " + adapter, "", nil
+}
+
+func checkKeyBackend(backend string) (string, string, error) {
+	if backend == KeyBackendYdotool {
+		path, err := exec.LookPath("ydotool")
+		if err != nil {
+			return "", "install ydotool and start ydotoold", err
+		}
+		return "ydotool at " + path, "", nil
+	}
+	if err := checkUinput(uinputPath); err != nil {
+		hint := ""
+		var uerr *uinputError
+		if errors.As(err, &uerr) {
+			hint = uerr.Hint
+		}
+		return "", hint, err
+	}
+	return uinputPath + " is writable", "", nil
+}
+
+func checkLogind(address string) (string, string, error) {
+	conn, err := connectSystemBus(address)
+	if err != nil {
+		return "", "power events need the D-Bus system bus; check that dbus is running or set dbus-address", err
+	}
+	defer conn.Close()
+	obj := conn.Object("org.freedesktop.login1", "/org/freedesktop/login1")
+	if err := obj.Call("org.freedesktop.DBus.Peer.Ping", 0).Err; err != nil {
+		return "", "power events need systemd-logind; disable them with --no-power-events on systems without it", err
+	}
+	return "reachable", "", nil
+}
+
+// checkQueueDir verifies that a file can be created in the queue directory.
+// An empty dir means the default directory is used, which always works.
+func checkQueueDir(dir string) (string, string, error) {
+	if dir == "" {
+		return "default directory (queue not persisted across restarts)", "", nil
+	}
+	hint := fmt.Sprintf("make %s writable by the user running cec-controller, or choose another queue-dir", dir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", hint, err
+	}
+	f, err := os.CreateTemp(dir, ".doctor-*")
+	if err != nil {
+		return "", hint, err
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return dir + " is writable", "", nil
+}
@@ -0,0 +1,72 @@
+package cecctl
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunDoctor(t *testing.T) {
+	pass := func() (string, string, error) { return "ok", "", nil }
+	fail := func() (string, string, error) { return "", "do the thing", errors.New("broken") }
+
+	tests := []struct {
+		name      string
+		checks    []doctorCheck
+		expectErr bool
+		contains  []string
+	}{
+		{
+			name:     "All pass",
+			checks:   []doctorCheck{{name: "a", critical: true, run: pass}},
+			contains: []string{"a      PASS    ok"},
+		},
+		{
+			name:     "Non-critical failure",
+			checks:   []doctorCheck{{name: "a", critical: true, run: pass}, {name: "b", run: fail}},
+			contains: []string{"b      WARN    broken", "b: do the thing"},
+		},
+		{
+			name:      "Critical failure",
+			checks:    []doctorCheck{{name: "a", critical: true, run: fail}, {name: "b", run: pass}},
+			expectErr: true,
+			contains:  []string{"a      FAIL    broken", "b      PASS    ok", "a: do the thing"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := runDoctor(&out, tt.checks)
+			if (err != nil) != tt.expectErr {
+				t.Errorf("Expected error=%v, got %v", tt.expectErr, err)
+			}
+			for _, s := range tt.contains {
+				if !strings.Contains(out.String(), s) {
+					t.Errorf("Expected output to contain %q, got:\n%s", s, out.String())
+				}
+			}
+		})
+	}
+}
+
+func TestCheckQueueDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "queue")
+	if _, _, err := checkQueueDir(dir); err != nil {
+		t.Fatalf("Expected a new queue dir to be writable, got %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 0 {
+		t.Errorf("Expected the probe file to be removed, got %v (%v)", entries, err)
+	}
+
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, hint, err := checkQueueDir(file); err == nil || hint == "" {
+		t.Errorf("Expected an error and a hint for a queue dir that is a file, got %v %q", err, hint)
+	}
+}
@@ -0,0 +1,70 @@
+package cecctl
+
+import (
+	"sync"
+	"time"
+)
+
+// eventRecord is a processed event kept for diagnostics.
+type eventRecord struct {
+	Time    time.Time
+	Kind    string // "key" or "power"
+	KeyCode int
+	Power   PowerEventType
+	Active  bool
+}
+
+// EventHistory is a bounded, in-memory ring buffer of the last processed
+// events. Unlike the persistent queue it is only a debugging window: it is
+// never written to disk and old entries are overwritten.
+type EventHistory struct {
+	mu   sync.Mutex
+	buf  []eventRecord
+	next int
+	full bool
+}
+
+// NewEventHistory creates a history keeping the last size events. A size of
+// zero or less disables recording.
+func NewEventHistory(size int) *EventHistory {
+	if size < 0 {
+		size = 0
+	}
+	return &EventHistory{buf: make([]eventRecord, size)}
+}
+
+// Record appends an event, overwriting the oldest one when full.
+func (h *EventHistory) Record(r eventRecord) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.buf) == 0 {
+		return
+	}
+	h.buf[h.next] = r
+	h.next = (h.next + 1) % len(h.buf)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// RecordKey records a dispatched CEC key press.
+func (h *EventHistory) RecordKey(keyCode int) {
+	h.Record(eventRecord{Time: time.Now(), Kind: "key", KeyCode: keyCode})
+}
+
+// RecordPower records a processed power event.
+func (h *EventHistory) RecordPower(ev PowerEvent) {
+	h.Record(eventRecord{Time: time.Now(), Kind: "power", Power: ev.Type, Active: ev.Active})
+}
+
+// Recent returns a copy of the recorded events, oldest first.
+func (h *EventHistory) Recent() []eventRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.full {
+		return append([]eventRecord(nil), h.buf[:h.next]...)
+	}
+	recent := make([]eventRecord, 0, len(h.buf))
+	recent = append(recent, h.buf[h.next:]...)
+	return append(recent, h.buf[:h.next]...)
+}
@@ -0,0 +1,45 @@
+package cecctl
+
+import "testing"
+
+func TestEventHistory_KeepsLastN(t *testing.T) {
+	h := NewEventHistory(3)
+	for code := 1; code <= 5; code++ {
+		h.RecordKey(code)
+	}
+
+	recent := h.Recent()
+	if len(recent) != 3 {
+		t.Fatalf("Expected 3 events, got %d", len(recent))
+	}
+	for i, expected := range []int{3, 4, 5} {
+		if recent[i].KeyCode != expected || recent[i].Kind != "key" {
+			t.Errorf("At index %d, expected key %d, got %+v", i, expected, recent[i])
+		}
+	}
+}
+
+func TestEventHistory_PartiallyFilled(t *testing.T) {
+	h := NewEventHistory(5)
+	h.RecordPower(PowerEvent{Type: PowerSleep, Active: true})
+	h.RecordKey(7)
+
+	recent := h.Recent()
+	if len(recent) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(recent))
+	}
+	if recent[0].Kind != "power" || recent[0].Power != PowerSleep || !recent[0].Active {
+		t.Errorf("Unexpected first event: %+v", recent[0])
+	}
+	if recent[1].Kind != "key" || recent[1].KeyCode != 7 {
+		t.Errorf("Unexpected second event: %+v", recent[1])
+	}
+}
+
+func TestEventHistory_Disabled(t *testing.T) {
+	h := NewEventHistory(0)
+	h.RecordKey(1)
+	if recent := h.Recent(); len(recent) != 0 {
+		t.Errorf("Expected no events when disabled, got %v", recent)
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package cecctl
 
 import (
 	"fmt"
@@ -14,8 +14,9 @@ type inhibitorLock struct {
 }
 
 // openSystemBus opens a connection to the D-Bus system bus for inhibitor use.
-func openSystemBus() (*dbus.Conn, error) {
-	return dbus.SystemBus()
+// address overrides the bus address, see connectSystemBus.
+func openSystemBus(address string) (*dbus.Conn, error) {
+	return connectSystemBus(address)
 }
 
 // acquireInhibitor acquires a systemd-logind delay inhibitor lock via D-Bus.
@@ -0,0 +1,210 @@
+//go:build integration
+
+package cecctl
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/claes/cec"
+)
+
+// fakeAdapter is a scriptable stand-in for a physical CEC adapter. Every
+// connection opened through it shares its state, so the test can unplug and
+// replug the adapter underneath an open CEC and watch the reopen flow.
+type fakeAdapter struct {
+	mu sync.Mutex
+
+	plugged     bool
+	replugAfter int // failed opens before the adapter comes back, -1 for never
+	opens       int
+	current     *fakeConn
+	powered     map[int]bool
+}
+
+var errAdapterUnplugged = errors.New("adapter unplugged")
+
+func newFakeAdapter() *fakeAdapter {
+	return &fakeAdapter{plugged: true, replugAfter: -1, powered: make(map[int]bool)}
+}
+
+func (a *fakeAdapter) open(string, string) (CECConnection, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.opens++
+	if !a.plugged {
+		if a.replugAfter != 0 {
+			if a.replugAfter > 0 {
+				a.replugAfter--
+			}
+			return nil, errAdapterUnplugged
+		}
+		a.plugged = true
+	}
+	a.current = &fakeConn{adapter: a}
+	return a.current, nil
+}
+
+// unplug makes the adapter stop answering; it comes back after replugAfter
+// more failed opens.
+func (a *fakeAdapter) unplug(replugAfter int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.plugged = false
+	a.replugAfter = replugAfter
+}
+
+// press simulates the TV forwarding a remote key press to the current connection.
+func (a *fakeAdapter) press(t *testing.T, keyCode int) {
+	t.Helper()
+	a.mu.Lock()
+	conn := a.current
+	plugged := a.plugged
+	a.mu.Unlock()
+	if !plugged || conn == nil || conn.keyPresses == nil {
+		t.Fatal("Cannot press a key while the adapter is unplugged")
+	}
+	conn.keyPresses <- &cec.KeyPress{KeyCode: keyCode}
+}
+
+func (a *fakeAdapter) isPowered(address int) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.powered[address]
+}
+
+func (a *fakeAdapter) openCount() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.opens
+}
+
+// fakeConn is a connection opened on a fakeAdapter. It only works while the
+// adapter is plugged and it is the adapter's current connection.
+type fakeConn struct {
+	adapter    *fakeAdapter
+	keyPresses chan *cec.KeyPress
+	closed     bool
+}
+
+func (f *fakeConn) usable() bool {
+	return f.adapter.plugged && f.adapter.current == f && !f.closed
+}
+
+func (f *fakeConn) setPower(address int, on bool) error {
+	f.adapter.mu.Lock()
+	defer f.adapter.mu.Unlock()
+	if !f.usable() {
+		return errAdapterUnplugged
+	}
+	f.adapter.powered[address] = on
+	return nil
+}
+
+func (f *fakeConn) PowerOn(address int) error { return f.setPower(address, true) }
+func (f *fakeConn) Standby(address int) error { return f.setPower(address, false) }
+func (f *fakeConn) SetActiveSource(int) bool  { return f.ConnectionAlive() }
+func (f *fakeConn) VolumeUp() error           { return nil }
+func (f *fakeConn) VolumeDown() error         { return nil }
+func (f *fakeConn) Mute() error               { return nil }
+
+func (f *fakeConn) Transmit(string)                   {}
+func (f *fakeConn) SetCommandsChan(chan *cec.Command) {}
+
+func (f *fakeConn) SetKeyPressesChan(ch chan *cec.KeyPress) {
+	f.keyPresses = ch
+}
+
+func (f *fakeConn) ConnectionAlive() bool {
+	f.adapter.mu.Lock()
+	defer f.adapter.mu.Unlock()
+	return f.usable()
+}
+
+func (f *fakeConn) PowerStatus(address int) string {
+	f.adapter.mu.Lock()
+	defer f.adapter.mu.Unlock()
+	if !f.usable() {
+		return ""
+	}
+	if f.adapter.powered[address] {
+		return "on"
+	}
+	return "standby"
+}
+
+func (f *fakeConn) Close() {
+	f.adapter.mu.Lock()
+	defer f.adapter.mu.Unlock()
+	f.closed = true
+}
+
+func expectKeyPress(t *testing.T, keyPresses chan *cec.KeyPress, keyCode int) {
+	t.Helper()
+	select {
+	case kp := <-keyPresses:
+		if kp.KeyCode != keyCode {
+			t.Errorf("Expected key code %d, got %d", keyCode, kp.KeyCode)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting for key code %d", keyCode)
+	}
+}
+
+func TestIntegration_ConnectionLossAndRecovery(t *testing.T) {
+	adapter := newFakeAdapter()
+	keyPresses := make(chan *cec.KeyPress, 10)
+
+	c, err := newCECWithOpener("fake", "test", 3, 1, keyPresses, adapter.open)
+	if err != nil {
+		t.Fatalf("newCECWithOpener failed: %v", err)
+	}
+	defer c.Close()
+
+	adapter.press(t, 0x00)
+	expectKeyPress(t, keyPresses, 0x00)
+
+	// The adapter drops off the bus and only comes back on the second reopen
+	// attempt: the power command must still go through.
+	adapter.unplug(1)
+	if err := c.PowerOn(0); err != nil {
+		t.Fatalf("Expected PowerOn to recover through reopen, got %v", err)
+	}
+	if opens := adapter.openCount(); opens != 3 {
+		t.Errorf("Expected 3 opens (initial, failed reopen, successful reopen), got %d", opens)
+	}
+	if !adapter.isPowered(0) {
+		t.Error("Expected device 0 to be powered on after recovery")
+	}
+
+	// Key presses resume on the same channel after the reopen.
+	adapter.press(t, 0x01)
+	expectKeyPress(t, keyPresses, 0x01)
+}
+
+func TestIntegration_ConnectionLostForGood(t *testing.T) {
+	adapter := newFakeAdapter()
+	keyPresses := make(chan *cec.KeyPress, 10)
+
+	c, err := newCECWithOpener("fake", "test", 2, 1, keyPresses, adapter.open)
+	if err != nil {
+		t.Fatalf("newCECWithOpener failed: %v", err)
+	}
+	defer c.Close()
+
+	// With the adapter gone every reopen fails; the caller gets every
+	// address back as failed, which is what triggers RestartProcess.
+	adapter.unplug(-1)
+	err = c.Standby(0, 5)
+	if err == nil {
+		t.Fatal("Expected Standby to fail while the adapter is unplugged")
+	}
+	if failed := failedAddresses(err); len(failed) != 2 {
+		t.Errorf("Expected both addresses to fail, got %v", failed)
+	}
+	if c.Connected() {
+		t.Error("Expected no connection to be held after the reopen failed")
+	}
+}
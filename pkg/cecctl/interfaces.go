@@ -0,0 +1,243 @@
+package cecctl
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strconv"
+	"sync"
+
+	"github.com/claes/cec"
+	keybd "github.com/micmonay/keybd_event"
+)
+
+// CECConnection interface abstracts the CEC library connection for testing.
+// Semantics: nil = success, non-nil = failure (standard Go).
+type CECConnection interface {
+	PowerOn(address int) error
+	Standby(address int) error
+	SetActiveSource(deviceType int) bool
+	VolumeUp() error
+	VolumeDown() error
+	Mute() error
+	SetKeyPressesChan(ch chan *cec.KeyPress)
+	// SetCommandsChan enables delivery of every raw frame received. libcec
+	// blocks while the channel is full, so it must be drained continuously.
+	SetCommandsChan(ch chan *cec.Command)
+	// Transmit sends a raw CEC frame encoded as colon separated hex bytes,
+	// e.g. "10:47:41". libcec reports no result for raw frames.
+	Transmit(command string)
+	// ConnectionAlive reports whether the adapter still answers, letting
+	// callers tell a rejected command apart from a lost connection.
+	ConnectionAlive() bool
+	// PowerStatus asks a device for its power status: "on", "standby",
+	// "starting", "shutting down", or "" when it does not answer.
+	PowerStatus(address int) string
+	Close()
+}
+
+// CECConnectionWrapper wraps the real CEC connection and normalises its error
+// semantics: libcec returns non-nil on success and nil on failure; this wrapper
+// inverts that so callers see standard Go conventions (nil = success).
+type CECConnectionWrapper struct {
+	*cec.Connection
+}
+
+func (w *CECConnectionWrapper) PowerOn(address int) error {
+	if w.Connection.PowerOn(address) == nil {
+		return fmt.Errorf("libcec PowerOn failed for address %d", address)
+	}
+	return nil
+}
+
+func (w *CECConnectionWrapper) Standby(address int) error {
+	if w.Connection.Standby(address) == nil {
+		return fmt.Errorf("libcec Standby failed for address %d", address)
+	}
+	return nil
+}
+
+func (w *CECConnectionWrapper) VolumeUp() error {
+	if w.Connection.VolumeUp() == nil {
+		return fmt.Errorf("libcec VolumeUp failed")
+	}
+	return nil
+}
+
+func (w *CECConnectionWrapper) VolumeDown() error {
+	if w.Connection.VolumeDown() == nil {
+		return fmt.Errorf("libcec VolumeDown failed")
+	}
+	return nil
+}
+
+func (w *CECConnectionWrapper) Mute() error {
+	if w.Connection.Mute() == nil {
+		return fmt.Errorf("libcec Mute failed")
+	}
+	return nil
+}
+
+func (w *CECConnectionWrapper) SetActiveSource(deviceType int) bool {
+	return w.Connection.SetActiveSource(deviceType)
+}
+
+// ConnectionAlive pings the adapter; libcec returns 1 when it responds.
+func (w *CECConnectionWrapper) ConnectionAlive() bool {
+	return w.Connection.Ping() == 1
+}
+
+func (w *CECConnectionWrapper) PowerStatus(address int) string {
+	return w.Connection.GetDevicePowerStatus(address)
+}
+
+func (w *CECConnectionWrapper) SetKeyPressesChan(ch chan *cec.KeyPress) {
+	w.Connection.KeyPresses = ch
+}
+
+func (w *CECConnectionWrapper) SetCommandsChan(ch chan *cec.Command) {
+	w.Connection.Commands = ch
+}
+
+// VolumeController handles the volume keys of the remote.
+type VolumeController interface {
+	VolumeUp() error
+	VolumeDown() error
+	// Mute toggles mute; SetMute sets it to the given state.
+	Mute() error
+	SetMute(muted bool) error
+}
+
+// PowerController handles key actions that power devices.
+type PowerController interface {
+	StandbyAll() error
+	OneTouchPlay() error
+}
+
+// WheelEmitter sends scroll wheel events, for scroll: key actions.
+type WheelEmitter interface {
+	// Scroll turns the horizontal wheel by horizontal clicks, positive to
+	// the right, and the vertical one by vertical clicks, positive upwards.
+	Scroll(horizontal, vertical int) error
+}
+
+// KeyboardEmitter abstracts virtual key event emission for testing.
+type KeyboardEmitter interface {
+	Emit(keyCodes []int) error
+}
+
+// keybdEmitter is the real KeyboardEmitter using keybd_event. The KeyBonding
+// is reused for every key event: keybd_event opens uinput once anyway.
+type keybdEmitter struct {
+	mu sync.Mutex
+	kb keybd.KeyBonding
+}
+
+// newKeybdEmitter checks that the uinput device can be opened, so a missing
+// module or permission problem is reported at startup rather than on the
+// first key press.
+func newKeybdEmitter() (*keybdEmitter, error) {
+	if err := checkUinput(uinputPath); err != nil {
+		return nil, err
+	}
+	kb, err := keybd.NewKeyBonding()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open uinput: %w", err)
+	}
+	return &keybdEmitter{kb: kb}, nil
+}
+
+func (k *keybdEmitter) Emit(keyCodes []int) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.kb.SetKeys(keyCodes...)
+	return k.kb.Launching()
+}
+
+// noopEmitter drops every key event. It stands in for the virtual keyboard
+// when none is available and --allow-no-keyboard is set.
+type noopEmitter struct{}
+
+func (noopEmitter) Emit(keyCodes []int) error {
+	slog.Debug("No virtual keyboard, dropping key event", "linux-key-code", keyCodes)
+	return nil
+}
+
+// ydotoolEmitter is a KeyboardEmitter that shells out to ydotool, whose daemon
+// injects events in a way Wayland compositors pick up more reliably than a
+// plain uinput device.
+type ydotoolEmitter struct {
+	path string
+	run  func(path string, args ...string) error
+}
+
+func newYdotoolEmitter() (*ydotoolEmitter, error) {
+	path, err := exec.LookPath("ydotool")
+	if err != nil {
+		return nil, fmt.Errorf("ydotool not found in PATH: %w", err)
+	}
+	return &ydotoolEmitter{path: path, run: runCommand}, nil
+}
+
+func (y *ydotoolEmitter) Emit(keyCodes []int) error {
+	if err := y.run(y.path, ydotoolKeyArgs(keyCodes)...); err != nil {
+		return fmt.Errorf("ydotool key failed: %w", err)
+	}
+	return nil
+}
+
+// ydotoolKeyArgs builds the `ydotool key` arguments for a key combination:
+// every key is pressed in order, then released in reverse order.
+func ydotoolKeyArgs(keyCodes []int) []string {
+	args := make([]string, 0, 1+2*len(keyCodes))
+	args = append(args, "key")
+	for _, code := range keyCodes {
+		args = append(args, strconv.Itoa(code)+":1")
+	}
+	for i := len(keyCodes) - 1; i >= 0; i-- {
+		args = append(args, strconv.Itoa(keyCodes[i])+":0")
+	}
+	return args
+}
+
+func runCommand(path string, args ...string) error {
+	out, err := exec.Command(path, args...).CombinedOutput()
+	if err != nil && len(out) > 0 {
+		return fmt.Errorf("%w: %s", err, out)
+	}
+	return err
+}
+
+// WindowActivator raises and focuses application windows, for key actions
+// that target a specific application.
+type WindowActivator interface {
+	// Activate focuses a window whose WM_CLASS matches class. It returns
+	// errWindowNotFound when there is none.
+	Activate(class string) error
+}
+
+var errWindowNotFound = errors.New("no matching window")
+
+// wmctrlActivator activates windows with wmctrl, which works for X11 and
+// XWayland windows. wmctrl is looked up on use, so it is only needed when a
+// window action is configured.
+type wmctrlActivator struct {
+	run func(path string, args ...string) error
+}
+
+func (w wmctrlActivator) Activate(class string) error {
+	path, err := exec.LookPath("wmctrl")
+	if err != nil {
+		return fmt.Errorf("wmctrl not found in PATH: %w", err)
+	}
+	if err := w.run(path, "-x", "-a", class); err != nil {
+		// wmctrl exits with status 1 when no window matches.
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			return errWindowNotFound
+		}
+		return fmt.Errorf("wmctrl failed: %w", err)
+	}
+	return nil
+}
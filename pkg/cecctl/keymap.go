@@ -0,0 +1,1148 @@
+package cecctl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/claes/cec"
+	keybd "github.com/micmonay/keybd_event"
+)
+
+// keyQueueSize bounds the number of key presses waiting for the delivery worker.
+const keyQueueSize = 100
+
+// keyErrorQueueSize bounds the number of unread key event failures; more
+// failures are dropped rather than blocking delivery.
+const keyErrorQueueSize = 16
+
+// KeyMap provides mapping from CEC key codes to Linux key codes and handles virtual key events.
+// Key presses are delivered in order by a dedicated worker goroutine so that a
+// slow uinput write never stalls the caller.
+//
+// A KeyMap can hold several named profiles (e.g. one for Kodi and one for the
+// desktop); the mapping of the active one is used to resolve key presses.
+type KeyMap struct {
+	mu         sync.RWMutex
+	cecToLinux map[int][]int            // mapping of the active profile
+	profiles   map[string]map[int][]int // every known profile, by name
+	profile    string                   // name of the active profile
+	overrides  map[string][]int         // global overrides, shared by every profile
+	ignored    map[int]bool             // CEC codes dropped silently, before any lookup
+	allowed    map[int]bool             // when not empty, the only CEC codes handled, see SetAllowedKeys
+	actions    map[int]string           // CEC codes bound to an action, shared by every profile
+	doubles    map[int]string           // CEC codes bound to a double press action, see SetActions
+	previous   string                   // profile active before the current one, for profile:toggle
+	preset     map[int]int              // base map of the remote preset, under every profile
+
+	clock Clock
+
+	warnMu       sync.Mutex
+	warnInterval time.Duration     // minimum time between two unmapped warnings for a code
+	lastWarned   map[int]time.Time // last unmapped warning per CEC code
+
+	emitter KeyboardEmitter
+	volume  VolumeController // optional, routes volume keys away from the keyboard
+	windows WindowActivator  // focuses the target of win: actions
+	power   PowerController  // optional, for power: actions
+	wheel   WheelEmitter     // optional, for scroll: actions
+
+	scrollAmount int // wheel clicks per scroll: action, see SetScrollAmount
+
+	// KeyEventErrors receives failures to emit a key event, e.g. after losing
+	// uinput permissions. Sends never block: unread failures are dropped.
+	KeyEventErrors chan error
+
+	numberMode  string        // NumberModeDirect or NumberModeChannel, see SetNumberMode
+	doubleDelay time.Duration // window for a second press, see SetDoublePressWindow
+	digits      []int         // CEC codes of the channel number being entered, only used by run
+
+	volumeAccel   bool      // see SetVolumeAccel
+	volumeKey     string    // last volume key sent, only used by run
+	volumeAt      time.Time // when volumeKey was sent
+	volumeRepeats int       // consecutive presses of volumeKey, 1 for the first
+
+	shiftKey     int            // CEC code of the layer-shift modifier, -1 without one, see SetShiftLayer
+	shiftKeyMap  map[int][]int  // mappings of the shift layer
+	shiftActions map[int]string // actions of the shift layer
+	shiftArmed   bool           // the modifier was pressed, for the next key, only used by run
+
+	pending   chan keyEvent
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+var base = map[int]int{
+	// Navigation
+	cec.GetKeyCodeByName("Select"): keybd.VK_ENTER,
+	cec.GetKeyCodeByName("Enter"):  keybd.VK_ENTER,
+	cec.GetKeyCodeByName("Up"):     keybd.VK_UP,
+	cec.GetKeyCodeByName("Down"):   keybd.VK_DOWN,
+	cec.GetKeyCodeByName("Left"):   keybd.VK_LEFT,
+	cec.GetKeyCodeByName("Right"):  keybd.VK_RIGHT,
+	cec.GetKeyCodeByName("Exit"):   keybd.VK_ESC,
+	cec.GetKeyCodeByName("Play"):   keybd.VK_PLAY,
+	cec.GetKeyCodeByName("Pause"):  keybd.VK_PAUSE,
+	cec.GetKeyCodeByName("Stop"):   keybd.VK_STOP,
+	cec.GetKeyCodeByName("Home"):   keybd.VK_HOME,
+
+	// Numbers
+	cec.GetKeyCodeByName("0"): keybd.VK_0,
+	cec.GetKeyCodeByName("1"): keybd.VK_1,
+	cec.GetKeyCodeByName("2"): keybd.VK_2,
+	cec.GetKeyCodeByName("3"): keybd.VK_3,
+	cec.GetKeyCodeByName("4"): keybd.VK_4,
+	cec.GetKeyCodeByName("5"): keybd.VK_5,
+	cec.GetKeyCodeByName("6"): keybd.VK_6,
+	cec.GetKeyCodeByName("7"): keybd.VK_7,
+	cec.GetKeyCodeByName("8"): keybd.VK_8,
+	cec.GetKeyCodeByName("9"): keybd.VK_9,
+
+	// TODO: send MPRIS messages
+	//cec.GetKeyCodeByName("Volume Up"): keybd.VK_VOLUMEUP,
+	//cec.GetKeyCodeByName("Volume Down"): keybd.VK_VOLUMEDOWN,
+	//cec.GetKeyCodeByName("Mute"): keybd.VK_MUTE,
+}
+
+// Remote presets, selected with --remote-preset. Each one is a base map
+// suited to an application stack, with the keymap applied on top.
+const (
+	RemotePresetDesktop   = "desktop"
+	RemotePresetKodi      = "kodi"
+	RemotePresetAndroidTV = "androidtv"
+)
+
+var remotePresets = map[string]map[int]int{
+	RemotePresetDesktop: base,
+	RemotePresetKodi: derivePreset(map[int]int{
+		cec.GetKeyCodeByName("Exit"):               keybd.VK_BACKSPACE, // Back
+		cec.GetKeyCodeByName("ContentsMenu"):       keybd.VK_C,         // Context menu
+		cec.GetKeyCodeByName("DisplayInformation"): keybd.VK_I,         // Info
+	}),
+	RemotePresetAndroidTV: derivePreset(map[int]int{
+		cec.GetKeyCodeByName("Exit"):         keybd.VK_BACK,
+		cec.GetKeyCodeByName("RootMenu"):     keybd.VK_HOMEPAGE, // The remote's home button
+		cec.GetKeyCodeByName("ContentsMenu"): keybd.VK_MENU,
+	}),
+}
+
+// derivePreset returns a copy of the base map with changes applied.
+func derivePreset(changes map[int]int) map[int]int {
+	preset := make(map[int]int, len(base)+len(changes))
+	for k, v := range base {
+		preset[k] = v
+	}
+	for k, v := range changes {
+		preset[k] = v
+	}
+	return preset
+}
+
+// validRemotePreset reports whether name is a known remote preset.
+func validRemotePreset(name string) bool {
+	_, ok := remotePresets[name]
+	return ok
+}
+
+// defaultProfile is the name of the profile built from the preset map and the
+// global overrides only.
+const defaultProfile = "default"
+
+// volumeKeys are the CEC user control codes handled by the VolumeController
+// when one is set. Raw codes are used because "Mute" names both 0x43 and the
+// 0x65 mute function, which GetKeyCodeByName cannot tell apart. Mute toggles,
+// while the mute function and the restore volume function (0x66) set the
+// state.
+var volumeKeys = map[int]string{
+	0x41: "VolumeUp",
+	0x42: "VolumeDown",
+	0x43: "Mute",
+	0x65: "MuteFunction",
+	0x66: "RestoreVolumeFunction",
+}
+
+// Number key behaviours, selected with --number-mode.
+const (
+	// NumberModeDirect sends each number key as soon as it is pressed.
+	NumberModeDirect = "direct"
+	// NumberModeChannel buffers number keys and sends them as one channel
+	// number followed by Enter, see channelEntryTimeout.
+	NumberModeChannel = "channel"
+)
+
+// channelEntryTimeout is how long channel number entry waits for the next
+// digit before submitting the number.
+const channelEntryTimeout = 1500 * time.Millisecond
+
+// Key emission backends, selected with --key-backend.
+const (
+	KeyBackendUinput  = "uinput"
+	KeyBackendYdotool = "ydotool"
+)
+
+// NewKeyMap creates a KeyMap from a remote preset, optionally overriding its
+// keys, that emits keys through the given backend, or types them into
+// targetTTY when set. When volume is non-nil, the volume keys are sent to it
+// instead of the virtual keyboard.
+func NewKeyMap(overrides map[string][]int, preset string, backend string, targetTTY string, volume VolumeController) (*KeyMap, error) {
+	emitter, err := newKeyboardEmitter(backend, targetTTY)
+	if err != nil {
+		return nil, err
+	}
+	return newKeyMapWithPreset(overrides, preset, emitter, volume)
+}
+
+func newKeyboardEmitter(backend string, targetTTY string) (KeyboardEmitter, error) {
+	if targetTTY != "" {
+		return newTTYEmitter(targetTTY)
+	}
+	switch backend {
+	case KeyBackendUinput, "":
+		return newKeybdEmitter()
+	case KeyBackendYdotool:
+		return newYdotoolEmitter()
+	default:
+		return nil, fmt.Errorf("unknown key backend %q", backend)
+	}
+}
+
+func newKeyMapWithEmitter(overrides map[string][]int, emitter KeyboardEmitter, volume VolumeController) (*KeyMap, error) {
+	return newKeyMapWithPreset(overrides, RemotePresetDesktop, emitter, volume)
+}
+
+func newKeyMapWithPreset(overrides map[string][]int, preset string, emitter KeyboardEmitter, volume VolumeController) (*KeyMap, error) {
+	if preset == "" {
+		preset = RemotePresetDesktop
+	}
+	presetMap, ok := remotePresets[preset]
+	if !ok {
+		return nil, fmt.Errorf("unknown remote preset %q", preset)
+	}
+	keyMap := buildKeyMap(presetMap, overrides)
+
+	slog.Debug("Key map initialized", "preset", preset, "mapping", presetMap)
+
+	km := &KeyMap{
+		cecToLinux: keyMap,
+		profiles:   map[string]map[int][]int{defaultProfile: keyMap},
+		profile:    defaultProfile,
+		overrides:  overrides,
+		preset:     presetMap,
+		emitter:    emitter,
+		volume:     volume,
+		windows:    wmctrlActivator{run: runCommand},
+		clock:      realClock{},
+		pending:    make(chan keyEvent, keyQueueSize),
+
+		doubleDelay:  defaultDoublePressWindow,
+		scrollAmount: defaultScrollAmount,
+		shiftKey:     -1,
+
+		KeyEventErrors: make(chan error, keyErrorQueueSize),
+	}
+	km.wg.Add(1)
+	go km.run()
+	return km, nil
+}
+
+// buildKeyMap resolves the preset map with each layer of overrides applied
+// in order, later layers winning.
+func buildKeyMap(preset map[int]int, layers ...map[string][]int) map[int][]int {
+	keyMap := make(map[int][]int, len(preset))
+
+	for k, v := range preset {
+		keyMap[k] = []int{v}
+	}
+
+	for _, overrides := range layers {
+		for k, v := range overrides {
+			cecCode := keyCodeByName(k)
+			if cecCode == -1 {
+				slog.Warn("Invalid CEC key name in overrides", "key", k)
+				continue
+			}
+			keyMap[cecCode] = v
+		}
+	}
+	return keyMap
+}
+
+// AddProfile registers a named profile whose overrides apply on top of the
+// global ones. Adding an existing profile replaces it.
+func (km *KeyMap) AddProfile(name string, overrides map[string][]int) {
+	keyMap := buildKeyMap(km.preset, km.overrides, overrides)
+
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	km.profiles[name] = keyMap
+	if km.profile == name {
+		km.cecToLinux = keyMap
+	}
+}
+
+// ReplaceKeyMap replaces the global overrides and every profile, e.g. after a
+// configuration reload. The active profile is kept when it still exists,
+// otherwise the default one is activated.
+func (km *KeyMap) ReplaceKeyMap(overrides map[string][]int, profiles map[string]map[string][]int) {
+	built := map[string]map[int][]int{defaultProfile: buildKeyMap(km.preset, overrides)}
+	for name, profileOverrides := range profiles {
+		built[name] = buildKeyMap(km.preset, overrides, profileOverrides)
+	}
+
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	km.overrides = overrides
+	km.profiles = built
+	if _, ok := built[km.previous]; !ok {
+		km.previous = ""
+	}
+	if keyMap, ok := built[km.profile]; ok {
+		km.cecToLinux = keyMap
+	} else {
+		km.activateProfile(defaultProfile, built[defaultProfile])
+	}
+}
+
+// SetVolumeController sets where the volume keys are sent, or sends them to
+// the virtual keyboard again when volume is nil.
+func (km *KeyMap) SetVolumeController(volume VolumeController) {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	km.volume = volume
+}
+
+// SetProfile makes the named profile the active one.
+func (km *KeyMap) SetProfile(name string) error {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	keyMap, ok := km.profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown keymap profile %q", name)
+	}
+	km.activateProfile(name, keyMap)
+	return nil
+}
+
+// activateProfile switches to a profile; km.mu must be held for writing.
+func (km *KeyMap) activateProfile(name string, keyMap map[int][]int) {
+	if name != km.profile {
+		km.previous = km.profile
+	}
+	km.cecToLinux = keyMap
+	km.profile = name
+	slog.Info("Keymap profile activated", "profile", name)
+}
+
+// Key actions, bound to CEC keys with key-actions. Several actions can be
+// bound to one key, separated by actionSeparator, and run in order.
+const (
+	actionProfileNext   = "profile:next"     // cycle through the profiles in name order
+	actionProfileToggle = "profile:toggle"   // go back to the previously active profile
+	actionWindowPrefix  = "win:"             // win:<class>:<codes>, focus a window then send the keys
+	actionKeyPrefix     = "key:"             // key:<codes>, send the keys
+	actionPowerOffAll   = "power:off-all"    // put every device on the bus to standby
+	actionOneTouchPlay  = "cec:onetouchplay" // wake the TV and switch it to this device
+	actionScrollPrefix  = "scroll:"          // scroll:<up|down|left|right>, turn the virtual wheel
+
+	actionSeparator = ";"
+)
+
+// validKeyAction reports whether action is a known key action, or a list of
+// them.
+func validKeyAction(action string) bool {
+	for _, single := range splitActions(action) {
+		switch single {
+		case actionProfileNext, actionProfileToggle, actionPowerOffAll, actionOneTouchPlay:
+			continue
+		}
+		if _, ok := parseKeyAction(single); ok {
+			continue
+		}
+		if _, _, ok := parseScrollAction(single); ok {
+			continue
+		}
+		if _, _, ok := parseWindowAction(single); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// splitActions returns the actions of an action list, e.g.
+// "key:28;profile:next".
+func splitActions(action string) []string {
+	actions := strings.Split(action, actionSeparator)
+	for i := range actions {
+		actions[i] = strings.TrimSpace(actions[i])
+	}
+	return actions
+}
+
+// parseKeyAction parses a "key:<codes>" action, e.g. "key:29+20" for Ctrl+T.
+func parseKeyAction(action string) ([]int, bool) {
+	rest, ok := strings.CutPrefix(action, actionKeyPrefix)
+	if !ok {
+		return nil, false
+	}
+	return parseActionKeyCodes(rest)
+}
+
+// scrollDirections are the wheel clicks, horizontal then vertical, of one
+// step of each scroll: action. Positive values scroll right and up.
+var scrollDirections = map[string][2]int{
+	"up":    {0, 1},
+	"down":  {0, -1},
+	"left":  {-1, 0},
+	"right": {1, 0},
+}
+
+// defaultScrollAmount is the default number of wheel clicks per scroll:
+// action.
+const defaultScrollAmount = 1
+
+// parseScrollAction parses a "scroll:<direction>" action, e.g. "scroll:down",
+// into the wheel clicks of one step.
+func parseScrollAction(action string) (horizontal, vertical int, ok bool) {
+	rest, ok := strings.CutPrefix(action, actionScrollPrefix)
+	if !ok {
+		return 0, 0, false
+	}
+	direction, ok := scrollDirections[rest]
+	return direction[0], direction[1], ok
+}
+
+// usesScrollActions reports whether any of the key actions scrolls, i.e.
+// whether the virtual wheel is needed.
+func usesScrollActions(actions map[string]string) bool {
+	for _, action := range actions {
+		for _, single := range splitActions(action) {
+			if _, _, ok := parseScrollAction(single); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseActionKeyCodes parses the "+" separated Linux key codes of an action.
+func parseActionKeyCodes(codes string) ([]int, bool) {
+	var keyCodes []int
+	for _, codeStr := range strings.Split(codes, "+") {
+		code, err := strconv.Atoi(codeStr)
+		if err != nil || code <= 0 {
+			return nil, false
+		}
+		keyCodes = append(keyCodes, code)
+	}
+	return keyCodes, true
+}
+
+// parseWindowAction parses a "win:<class>:<codes>" action, e.g. "win:kodi:28"
+// or "win:firefox:29+20" for Ctrl+T, where class matches the WM_CLASS of the
+// target window.
+func parseWindowAction(action string) (string, []int, bool) {
+	rest, ok := strings.CutPrefix(action, actionWindowPrefix)
+	if !ok {
+		return "", nil, false
+	}
+	sep := strings.LastIndex(rest, ":")
+	if sep <= 0 {
+		return "", nil, false
+	}
+	keyCodes, ok := parseActionKeyCodes(rest[sep+1:])
+	if !ok {
+		return "", nil, false
+	}
+	return rest[:sep], keyCodes, true
+}
+
+// doublePressSuffix marks a key-actions entry run on a double press of the
+// key, e.g. "Exit:double".
+const doublePressSuffix = ":double"
+
+// defaultDoublePressWindow is the default maximum time between the two
+// presses of a double press.
+const defaultDoublePressWindow = 400 * time.Millisecond
+
+// SetActions binds CEC keys, by name, to actions. Actions apply whatever the
+// active profile is. Names ending with doublePressSuffix bind the action to a
+// double press of the key instead.
+func (km *KeyMap) SetActions(actions map[string]string) {
+	byCode := make(map[int]string, len(actions))
+	doubles := make(map[int]string)
+	for name, action := range actions {
+		keyName, double := strings.CutSuffix(name, doublePressSuffix)
+		cecCode := keyCodeByName(keyName)
+		if cecCode == -1 {
+			slog.Warn("Invalid CEC key name in key actions", "key", name)
+			continue
+		}
+		if double {
+			doubles[cecCode] = action
+		} else {
+			byCode[cecCode] = action
+		}
+	}
+
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	km.actions = byCode
+	km.doubles = doubles
+}
+
+// SetDoublePressWindow sets the maximum time between the two presses of a
+// double press. A key with a double press action is only handled once this
+// window has passed without a second press. Zero disables double presses.
+func (km *KeyMap) SetDoublePressWindow(window time.Duration) {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	km.doubleDelay = window
+}
+
+// DoubleAction returns the action bound to a double press of a CEC key, if
+// any. Ignored keys have none.
+func (km *KeyMap) DoubleAction(cecKeyCode int) (string, bool) {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+	action, ok := km.doubles[cecKeyCode]
+	return action, ok && !km.dropped(cecKeyCode)
+}
+
+// Action returns the action bound to a CEC key, if any.
+func (km *KeyMap) Action(cecKeyCode int) (string, bool) {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+	action, ok := km.actions[cecKeyCode]
+	return action, ok
+}
+
+// runAction performs a key action.
+func (km *KeyMap) runAction(action string) {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	switch action {
+	case actionProfileNext:
+		names := make([]string, 0, len(km.profiles))
+		for name := range km.profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		next := names[(sort.SearchStrings(names, km.profile)+1)%len(names)]
+		km.activateProfile(next, km.profiles[next])
+	case actionProfileToggle:
+		if keyMap, ok := km.profiles[km.previous]; ok && km.previous != "" {
+			km.activateProfile(km.previous, keyMap)
+		}
+	default:
+		slog.Warn("Unknown key action", "action", action)
+	}
+}
+
+// SetIgnoredKeys replaces the set of CEC key codes that are dropped without
+// any action or warning, e.g. pseudo-keys some TVs send on every press.
+func (km *KeyMap) SetIgnoredKeys(codes []int) {
+	ignored := make(map[int]bool, len(codes))
+	for _, code := range codes {
+		ignored[code] = true
+	}
+
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	km.ignored = ignored
+}
+
+// SetAllowedKeys restricts the handled CEC keys to codes, e.g. for a kiosk:
+// other keys are dropped without any action or warning, whatever the keymap
+// and its overrides map them to. A key also given to SetIgnoredKeys is
+// dropped. An empty list handles every key.
+func (km *KeyMap) SetAllowedKeys(codes []int) {
+	allowed := make(map[int]bool, len(codes))
+	for _, code := range codes {
+		allowed[code] = true
+	}
+
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	km.allowed = allowed
+}
+
+// SetUnmappedWarnInterval limits the "Unmapped CEC key code" warning to once
+// per interval for each code, so a held button does not flood the logs. Zero
+// warns on every press.
+func (km *KeyMap) SetUnmappedWarnInterval(interval time.Duration) {
+	km.warnMu.Lock()
+	defer km.warnMu.Unlock()
+	km.warnInterval = interval
+}
+
+// shouldWarnUnmapped reports whether an unmapped warning for the code is due.
+func (km *KeyMap) shouldWarnUnmapped(cecKeyCode int) bool {
+	km.warnMu.Lock()
+	defer km.warnMu.Unlock()
+	if km.warnInterval <= 0 {
+		return true
+	}
+	now := km.clock.Now()
+	if last, ok := km.lastWarned[cecKeyCode]; ok && now.Sub(last) < km.warnInterval {
+		return false
+	}
+	if km.lastWarned == nil {
+		km.lastWarned = make(map[int]time.Time)
+	}
+	km.lastWarned[cecKeyCode] = now
+	return true
+}
+
+// Lookup returns the Linux key codes the active profile maps a CEC key to.
+func (km *KeyMap) Lookup(cecKeyCode int) ([]int, bool) {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+	linuxKeyCodes, ok := km.cecToLinux[cecKeyCode]
+	return linuxKeyCodes, ok
+}
+
+// Ignored reports whether a CEC key is dropped by SetIgnoredKeys or, when
+// set, SetAllowedKeys.
+func (km *KeyMap) Ignored(cecKeyCode int) bool {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+	return km.dropped(cecKeyCode)
+}
+
+// dropped implements Ignored; km.mu must be held.
+func (km *KeyMap) dropped(cecKeyCode int) bool {
+	return km.ignored[cecKeyCode] || (len(km.allowed) > 0 && !km.allowed[cecKeyCode])
+}
+
+// Profile returns the name of the active profile.
+func (km *KeyMap) Profile() string {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+	return km.profile
+}
+
+// keyEvent is a CEC key event waiting for the delivery worker: a press when
+// duration is 0, otherwise the release of a key held for duration. An event
+// with done set is not a key event but a flush, see flush.
+type keyEvent struct {
+	code     int
+	duration time.Duration
+	done     chan struct{}
+}
+
+// run delivers queued key events one at a time, preserving their order.
+func (km *KeyMap) run() {
+	defer km.wg.Done()
+	var submit <-chan time.Time
+	// A key with a double press action waits in held until a second press
+	// or the end of the double press window.
+	held := -1
+	var single <-chan time.Time
+	// down is the key pressed last, until its release: remotes repeat the
+	// press of a held key, which is not a double press.
+	down := -1
+	submitted := func() {
+		submit = nil
+		km.submitDigits()
+	}
+	singlePressed := func() {
+		km.handleKey(held)
+		held, single = -1, nil
+	}
+	for {
+		select {
+		case ev, ok := <-km.pending:
+			if !ok {
+				if held >= 0 {
+					km.handleKey(held)
+				}
+				km.submitDigits()
+				return
+			}
+			if ev.done != nil {
+				// Timers that fired before the flush was queued count as
+				// handled before it.
+				select {
+				case <-submit:
+					submitted()
+				default:
+				}
+				select {
+				case <-single:
+					singlePressed()
+				default:
+				}
+				close(ev.done)
+				continue
+			}
+			if ev.duration > 0 {
+				// A release neither ends a double press window nor a
+				// channel number.
+				if ev.code == down {
+					down = -1
+				}
+				km.handleRelease(ev.code, ev.duration)
+				continue
+			}
+			cecKeyCode := ev.code
+			repeat := cecKeyCode == down
+			down = cecKeyCode
+			if held >= 0 {
+				first := held
+				held, single = -1, nil
+				if cecKeyCode == first && !repeat {
+					km.handleDoublePress(cecKeyCode)
+					continue
+				}
+				km.handleKey(first)
+			}
+			if km.isShiftKey(cecKeyCode) {
+				// CEC releases carry no key code and libcec releases the
+				// modifier as soon as another key is pressed, so a press
+				// arms the layer for the next key rather than while held.
+				// Repeats of a held modifier keep it armed.
+				km.shiftArmed = true
+				continue
+			}
+			if km.shifted(cecKeyCode) {
+				// The layer takes the key over, double presses and
+				// channel numbers included.
+				km.handleKey(cecKeyCode)
+				km.shiftArmed = false
+				continue
+			}
+			km.shiftArmed = false
+			if km.bufferDigit(cecKeyCode) {
+				submit = km.clock.After(channelEntryTimeout)
+				continue
+			}
+			if len(km.digits) > 0 {
+				submit = nil
+				km.submitDigits()
+				if cecKeyCode == cecSelect || cecKeyCode == cecEnter {
+					// The number was submitted with the Enter it called for.
+					continue
+				}
+			}
+			if _, ok := km.DoubleAction(cecKeyCode); ok && !repeat {
+				km.mu.RLock()
+				delay := km.doubleDelay
+				km.mu.RUnlock()
+				if delay > 0 {
+					single = km.clock.After(delay)
+					held = cecKeyCode
+					continue
+				}
+			}
+			km.handleKey(cecKeyCode)
+		case <-submit:
+			submitted()
+		case <-single:
+			singlePressed()
+		}
+	}
+}
+
+// handleDoublePress runs the action bound to a double press of a CEC key.
+func (km *KeyMap) handleDoublePress(cecKeyCode int) {
+	action, ok := km.DoubleAction(cecKeyCode)
+	if !ok {
+		return
+	}
+	slog.Debug("Double press", "cec-key-code", cecKeyCode)
+	for _, single := range splitActions(action) {
+		km.runKeyAction(cecKeyCode, single)
+	}
+}
+
+// CEC codes of the keys that submit a channel number.
+var (
+	cecSelect = cec.GetKeyCodeByName("Select")
+	cecEnter  = cec.GetKeyCodeByName("Enter")
+)
+
+// SetNumberMode selects how number keys are sent: NumberModeDirect (the
+// default) or NumberModeChannel.
+func (km *KeyMap) SetNumberMode(mode string) {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	km.numberMode = mode
+}
+
+// bufferDigit adds a number key to the channel number being entered, in
+// channel mode. It returns false for other keys, and for number keys that are
+// ignored or bound to an action.
+func (km *KeyMap) bufferDigit(cecKeyCode int) bool {
+	km.mu.RLock()
+	channel := km.numberMode == NumberModeChannel
+	km.mu.RUnlock()
+	if !channel || cecKeyCode < 0x20 || cecKeyCode > 0x29 {
+		return false
+	}
+	if _, ok := km.Action(cecKeyCode); ok || km.Ignored(cecKeyCode) {
+		return false
+	}
+	if _, ok := km.DoubleAction(cecKeyCode); ok {
+		return false
+	}
+	km.digits = append(km.digits, cecKeyCode)
+	return true
+}
+
+// submitDigits sends the buffered channel number followed by Enter.
+func (km *KeyMap) submitDigits() {
+	if len(km.digits) == 0 {
+		return
+	}
+	slog.Debug("Submitting channel number", "cec-key-codes", km.digits)
+	for _, cecKeyCode := range km.digits {
+		km.handleKey(cecKeyCode)
+	}
+	km.emit(cecEnter, []int{keybd.VK_ENTER})
+	km.digits = km.digits[:0]
+}
+
+// Close stops the delivery worker after the already queued key events have
+// been handled, then closes the emitter if it holds a device, e.g. the
+// --target-tty terminal. OnKeyEvent must not be called after Close.
+func (km *KeyMap) Close() {
+	km.closeOnce.Do(func() {
+		close(km.pending)
+		km.wg.Wait()
+		if closer, ok := km.emitter.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				slog.Warn("Failed to close the key emitter", "error", err)
+			}
+		}
+	})
+}
+
+// flush returns once the key events queued before it have been handled. A
+// replay uses it to print what each event did before reading the next one.
+func (km *KeyMap) flush() {
+	done := make(chan struct{})
+	km.pending <- keyEvent{done: done}
+	<-done
+}
+
+// OnKeyEvent queues a CEC key event for delivery without blocking: a press
+// when duration is 0, as libcec reports it, or the release of a key held for
+// duration. If the worker has fallen too far behind, the event is dropped.
+func (km *KeyMap) OnKeyEvent(cecKeyCode int, duration time.Duration) {
+	select {
+	case km.pending <- keyEvent{code: cecKeyCode, duration: duration}:
+	default:
+		slog.Warn("Key delivery queue full, dropping key event", "cec-key-code", cecKeyCode, "duration", duration)
+	}
+}
+
+// OnKeyPress queues a CEC key press for delivery without blocking.
+//
+// Deprecated: use OnKeyEvent, which also delivers key releases.
+func (km *KeyMap) OnKeyPress(cecKeyCode int) {
+	km.OnKeyEvent(cecKeyCode, 0)
+}
+
+// Volume acceleration, see SetVolumeAccel.
+const (
+	// volumeAccelWindow is the maximum time between two presses of a volume
+	// key for the second one to count as consecutive; remotes repeat a held
+	// key faster than this.
+	volumeAccelWindow = 600 * time.Millisecond
+	// volumeAccelMaxSteps caps the number of volume commands sent per press.
+	volumeAccelMaxSteps = 4
+)
+
+// SetVolumeAccel makes consecutive presses of the same volume key, e.g. while
+// it is held, send a growing number of volume commands: 1, 2, 3, then
+// volumeAccelMaxSteps per press. A pause longer than volumeAccelWindow, or
+// another key, goes back to one. Only volume keys sent over CEC are
+// accelerated.
+func (km *KeyMap) SetVolumeAccel(enabled bool) {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	km.volumeAccel = enabled
+}
+
+// ShiftLayer is the alternate layer of layer-shift: the key pressed right
+// after Key does what the layer maps or binds it to, if anything, instead of
+// its usual meaning.
+// Keys and actions are named like in keymap and key-actions.
+type ShiftLayer struct {
+	Key     string            `json:"key"`
+	KeyMap  map[string][]int  `json:"keymap"`
+	Actions map[string]string `json:"actions"`
+}
+
+// SetShiftLayer replaces the shift layer, nil removes it. The modifier key is
+// then only used to switch layers: its own presses are not sent.
+func (km *KeyMap) SetShiftLayer(layer *ShiftLayer) {
+	shiftKey := -1
+	keyMap := make(map[int][]int)
+	actions := make(map[int]string)
+	if layer != nil {
+		if shiftKey = keyCodeByName(layer.Key); shiftKey == -1 {
+			slog.Warn("Invalid CEC key name for the shift layer", "key", layer.Key)
+		}
+		for name, keyCodes := range layer.KeyMap {
+			if code := keyCodeByName(name); code != -1 {
+				keyMap[code] = keyCodes
+			} else {
+				slog.Warn("Invalid CEC key name in the shift layer", "key", name)
+			}
+		}
+		for name, action := range layer.Actions {
+			if code := keyCodeByName(name); code != -1 {
+				actions[code] = action
+			} else {
+				slog.Warn("Invalid CEC key name in the shift layer actions", "key", name)
+			}
+		}
+	}
+
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	km.shiftKey = shiftKey
+	km.shiftKeyMap = keyMap
+	km.shiftActions = actions
+}
+
+// isShiftKey reports whether a CEC key is the shift layer modifier.
+func (km *KeyMap) isShiftKey(cecKeyCode int) bool {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+	return km.shiftKey != -1 && cecKeyCode == km.shiftKey
+}
+
+// shifted reports whether a press of a CEC key is handled by the shift layer,
+// i.e. the modifier was pressed just before and the layer maps the key or
+// binds an action to it. Only used by run.
+func (km *KeyMap) shifted(cecKeyCode int) bool {
+	if !km.shiftArmed {
+		return false
+	}
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+	_, isAction := km.shiftActions[cecKeyCode]
+	_, isMapped := km.shiftKeyMap[cecKeyCode]
+	return isAction || isMapped
+}
+
+// volumeSteps returns the number of volume commands to send for a press of
+// the named volume key, and records the press.
+func (km *KeyMap) volumeSteps(name string, accel bool) int {
+	now := km.clock.Now()
+	if name == km.volumeKey && now.Sub(km.volumeAt) <= volumeAccelWindow {
+		km.volumeRepeats++
+	} else {
+		km.volumeRepeats = 1
+	}
+	km.volumeKey, km.volumeAt = name, now
+	if !accel || (name != "VolumeUp" && name != "VolumeDown") {
+		return 1
+	}
+	return min(km.volumeRepeats, volumeAccelMaxSteps)
+}
+
+// handleRelease is called with the hold duration of every released key, once
+// the events queued before it are handled. Keys are sent on press, so a
+// release only matters to features telling a tap from a hold.
+func (km *KeyMap) handleRelease(cecKeyCode int, duration time.Duration) {
+	slog.Debug("CEC key released", "cec-key-code", cecKeyCode, "held", duration)
+}
+
+// handleKey maps a CEC key code to Linux and sends the virtual key event.
+func (km *KeyMap) handleKey(cecKeyCode int) {
+	if km.Ignored(cecKeyCode) {
+		return
+	}
+
+	if km.shiftArmed {
+		km.mu.RLock()
+		action, isAction := km.shiftActions[cecKeyCode]
+		keyCodes, isMapped := km.shiftKeyMap[cecKeyCode]
+		km.mu.RUnlock()
+		if isAction {
+			for _, single := range splitActions(action) {
+				km.runKeyAction(cecKeyCode, single)
+			}
+			return
+		}
+		if isMapped {
+			km.emit(cecKeyCode, keyCodes)
+			return
+		}
+	}
+
+	if action, ok := km.Action(cecKeyCode); ok {
+		for _, single := range splitActions(action) {
+			km.runKeyAction(cecKeyCode, single)
+		}
+		return
+	}
+
+	km.mu.RLock()
+	volume, accel := km.volume, km.volumeAccel
+	km.mu.RUnlock()
+	if volume != nil {
+		if name, ok := volumeKeys[cecKeyCode]; ok {
+			for range km.volumeSteps(name, accel) {
+				handleVolumeKey(volume, name)
+			}
+			return
+		}
+	}
+
+	linuxKeyCode, ok := km.Lookup(cecKeyCode)
+	if !ok {
+		if km.shouldWarnUnmapped(cecKeyCode) {
+			if name, ok := vendorKeyName(cecKeyCode); ok {
+				slog.Warn("Unmapped vendor key, map it in the keymap", "key", name)
+			} else {
+				slog.Warn("Unmapped CEC key code", "cec-key-code", cecKeyCode)
+			}
+		}
+		return
+	}
+
+	km.emit(cecKeyCode, linuxKeyCode)
+}
+
+// runKeyAction performs one action bound to a CEC key.
+func (km *KeyMap) runKeyAction(cecKeyCode int, action string) {
+	slog.Debug("Running key action", "cec-key-code", cecKeyCode, "action", action)
+	if class, keyCodes, ok := parseWindowAction(action); ok {
+		km.sendToWindow(cecKeyCode, class, keyCodes)
+	} else if keyCodes, ok := parseKeyAction(action); ok {
+		km.emit(cecKeyCode, keyCodes)
+	} else if horizontal, vertical, ok := parseScrollAction(action); ok {
+		km.scroll(horizontal, vertical)
+	} else if action == actionPowerOffAll {
+		km.standbyAll()
+	} else if action == actionOneTouchPlay {
+		km.oneTouchPlay()
+	} else {
+		km.runAction(action)
+	}
+}
+
+// SetPowerController sets where power: and cec: actions are sent.
+func (km *KeyMap) SetPowerController(power PowerController) {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	km.power = power
+}
+
+// SetWheelEmitter sets where scroll: actions are sent.
+func (km *KeyMap) SetWheelEmitter(wheel WheelEmitter) {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	km.wheel = wheel
+}
+
+// SetScrollAmount sets the number of wheel clicks sent per scroll: action. A
+// held key repeats the action, so it keeps scrolling.
+func (km *KeyMap) SetScrollAmount(clicks int) {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	km.scrollAmount = clicks
+}
+
+// scroll runs a scroll: action.
+func (km *KeyMap) scroll(horizontal, vertical int) {
+	km.mu.RLock()
+	wheel, amount := km.wheel, km.scrollAmount
+	km.mu.RUnlock()
+	if wheel == nil {
+		slog.Warn("No virtual wheel for scroll actions, ignoring key press (restart after adding the first scroll action)")
+		return
+	}
+	slog.Debug("Scrolling", "horizontal", horizontal*amount, "vertical", vertical*amount)
+	if err := wheel.Scroll(horizontal*amount, vertical*amount); err != nil {
+		slog.Error("Failed to scroll", "error", err)
+	}
+}
+
+// standbyAll runs the power:off-all action.
+func (km *KeyMap) standbyAll() {
+	km.mu.RLock()
+	power := km.power
+	km.mu.RUnlock()
+	if power == nil {
+		slog.Warn("No CEC connection for power:off-all, ignoring key press")
+		return
+	}
+	slog.Info("Putting every device to standby")
+	if err := power.StandbyAll(); err != nil {
+		slog.Error("Failed to put every device to standby", "error", err)
+	}
+}
+
+// oneTouchPlay runs the cec:onetouchplay action.
+func (km *KeyMap) oneTouchPlay() {
+	km.mu.RLock()
+	power := km.power
+	km.mu.RUnlock()
+	if power == nil {
+		slog.Warn("No CEC connection for cec:onetouchplay, ignoring key press")
+		return
+	}
+	slog.Info("Sending One Touch Play")
+	if err := power.OneTouchPlay(); err != nil {
+		slog.Error("Failed to send One Touch Play", "error", err)
+	}
+}
+
+// sendToWindow focuses the window of the given class and sends keyCodes to
+// it. Nothing is sent when there is no such window, so the keys never land in
+// another application.
+func (km *KeyMap) sendToWindow(cecKeyCode int, class string, keyCodes []int) {
+	if err := km.windows.Activate(class); err != nil {
+		if errors.Is(err, errWindowNotFound) {
+			slog.Debug("No window for key action, ignoring key press", "class", class)
+		} else {
+			slog.Warn("Failed to activate window for key action", "class", class, "error", err)
+		}
+		return
+	}
+	km.emit(cecKeyCode, keyCodes)
+}
+
+// emit sends a virtual key event and reports failures on KeyEventErrors.
+func (km *KeyMap) emit(cecKeyCode int, linuxKeyCode []int) {
+	slog.Debug("Sending virtual key event", "cec-key-code", cecKeyCode, "linux-key-code", linuxKeyCode)
+	if err := km.emitter.Emit(linuxKeyCode); err != nil {
+		slog.Error("Failed to send key event", "error", err)
+		select {
+		case km.KeyEventErrors <- fmt.Errorf("CEC key code %d: %w", cecKeyCode, err):
+		default:
+		}
+	}
+}
+
+// handleVolumeKey forwards a volume key to the VolumeController.
+func handleVolumeKey(volume VolumeController, name string) {
+	var err error
+	switch name {
+	case "VolumeUp":
+		err = volume.VolumeUp()
+	case "VolumeDown":
+		err = volume.VolumeDown()
+	case "Mute":
+		err = volume.Mute()
+	case "MuteFunction":
+		err = volume.SetMute(true)
+	case "RestoreVolumeFunction":
+		err = volume.SetMute(false)
+	}
+	slog.Debug("Sending volume command", "key", name)
+	if err != nil {
+		slog.Error("Failed to send volume command", "key", name, "error", err)
+	}
+}
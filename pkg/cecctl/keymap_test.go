@@ -0,0 +1,1082 @@
+package cecctl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/claes/cec"
+	keybd "github.com/micmonay/keybd_event"
+)
+
+// MockKeyboardEmitter records Emit calls for testing.
+type MockKeyboardEmitter struct {
+	EmitFunc  func(keyCodes []int) error
+	EmitCalls [][]int
+}
+
+func (m *MockKeyboardEmitter) Emit(keyCodes []int) error {
+	m.EmitCalls = append(m.EmitCalls, keyCodes)
+	if m.EmitFunc != nil {
+		return m.EmitFunc(keyCodes)
+	}
+	return nil
+}
+
+// MockVolumeController records volume commands for testing.
+type MockVolumeController struct {
+	Calls []string
+}
+
+func (m *MockVolumeController) VolumeUp() error {
+	m.Calls = append(m.Calls, "VolumeUp")
+	return nil
+}
+
+func (m *MockVolumeController) VolumeDown() error {
+	m.Calls = append(m.Calls, "VolumeDown")
+	return nil
+}
+
+func (m *MockVolumeController) Mute() error {
+	m.Calls = append(m.Calls, "Mute")
+	return nil
+}
+
+func (m *MockVolumeController) SetMute(muted bool) error {
+	m.Calls = append(m.Calls, fmt.Sprintf("SetMute(%v)", muted))
+	return nil
+}
+
+func TestKeyMapStructure(t *testing.T) {
+	km := &KeyMap{
+		cecToLinux: make(map[int][]int),
+	}
+	if km.cecToLinux == nil {
+		t.Fatal("Expected cecToLinux map to be initialized")
+	}
+	km.cecToLinux[1] = []int{105}
+	if mapping, ok := km.cecToLinux[1]; !ok || len(mapping) != 1 || mapping[0] != 105 {
+		t.Error("Failed to add mapping to KeyMap")
+	}
+}
+
+func TestKeyMapMapping(t *testing.T) {
+	km := &KeyMap{
+		cecToLinux: make(map[int][]int),
+	}
+	km.cecToLinux[1] = []int{105}
+	if mapping, ok := km.cecToLinux[1]; !ok || len(mapping) != 1 || mapping[0] != 105 {
+		t.Error("Failed to map single key")
+	}
+
+	km.cecToLinux[2] = []int{29, 3}
+	if mapping, ok := km.cecToLinux[2]; !ok || len(mapping) != 2 {
+		t.Error("Failed to map key combination")
+	}
+}
+
+func TestKeyMapLookup(t *testing.T) {
+	km := &KeyMap{
+		cecToLinux: map[int][]int{
+			1: {105},
+			2: {29, 3},
+			3: {56, 29, 4},
+		},
+	}
+
+	if _, ok := km.cecToLinux[1]; !ok {
+		t.Error("Expected key 1 to be mapped")
+	}
+	if _, ok := km.cecToLinux[2]; !ok {
+		t.Error("Expected key 2 to be mapped")
+	}
+	if _, ok := km.cecToLinux[999]; ok {
+		t.Error("Did not expect key 999 to be mapped")
+	}
+}
+
+func TestKeyMapConcurrentRead(t *testing.T) {
+	km := &KeyMap{
+		cecToLinux: map[int][]int{
+			1: {105},
+			2: {29, 3},
+		},
+	}
+
+	done := make(chan bool, 10)
+	for i := 0; i < 10; i++ {
+		go func() {
+			defer func() { done <- true }()
+			for j := 0; j < 100; j++ {
+				_ = km.cecToLinux[1]
+				_ = km.cecToLinux[2]
+			}
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		<-done
+	}
+}
+
+func TestOnKeyPress_MappedKey(t *testing.T) {
+	mock := &MockKeyboardEmitter{}
+	km, err := newKeyMapWithEmitter(nil, mock, nil)
+	if err != nil {
+		t.Fatalf("newKeyMapWithEmitter failed: %v", err)
+	}
+
+	cecCode := cec.GetKeyCodeByName("Select")
+	if cecCode == -1 {
+		t.Fatal("CEC key 'Select' not found")
+	}
+	km.OnKeyPress(cecCode)
+	km.Close()
+
+	if len(mock.EmitCalls) != 1 {
+		t.Errorf("Expected 1 Emit call, got %d", len(mock.EmitCalls))
+	}
+}
+
+func TestOnKeyPress_UnmappedKey(t *testing.T) {
+	mock := &MockKeyboardEmitter{}
+	km, err := newKeyMapWithEmitter(nil, mock, nil)
+	if err != nil {
+		t.Fatalf("newKeyMapWithEmitter failed: %v", err)
+	}
+
+	km.OnKeyPress(99999) // definitely unmapped
+	km.Close()
+
+	if len(mock.EmitCalls) != 0 {
+		t.Errorf("Expected no Emit calls for unmapped key, got %d", len(mock.EmitCalls))
+	}
+}
+
+func TestOnKeyPress_EmitterError(t *testing.T) {
+	mock := &MockKeyboardEmitter{
+		EmitFunc: func(keyCodes []int) error {
+			return errors.New("emit failed")
+		},
+	}
+	km, err := newKeyMapWithEmitter(nil, mock, nil)
+	if err != nil {
+		t.Fatalf("newKeyMapWithEmitter failed: %v", err)
+	}
+
+	cecCode := cec.GetKeyCodeByName("Select")
+	// Should not panic; error is logged internally
+	km.OnKeyPress(cecCode)
+	km.Close()
+
+	if len(mock.EmitCalls) != 1 {
+		t.Errorf("Expected Emit to be called once, got %d", len(mock.EmitCalls))
+	}
+	select {
+	case err := <-km.KeyEventErrors:
+		if err == nil || !strings.Contains(err.Error(), "emit failed") {
+			t.Errorf("Expected the emit failure to be reported, got %v", err)
+		}
+	default:
+		t.Error("Expected the emit failure on KeyEventErrors")
+	}
+}
+
+func TestOnKeyPress_EmitterErrorsDoNotBlock(t *testing.T) {
+	mock := &MockKeyboardEmitter{
+		EmitFunc: func(keyCodes []int) error {
+			return errors.New("emit failed")
+		},
+	}
+	km, err := newKeyMapWithEmitter(nil, mock, nil)
+	if err != nil {
+		t.Fatalf("newKeyMapWithEmitter failed: %v", err)
+	}
+
+	// Nobody reads KeyEventErrors: delivery must go on past its capacity.
+	for i := 0; i < keyErrorQueueSize+5; i++ {
+		km.OnKeyPress(cec.GetKeyCodeByName("Select"))
+	}
+	km.Close()
+
+	if len(mock.EmitCalls) != keyErrorQueueSize+5 {
+		t.Errorf("Expected every key press to be delivered, got %d", len(mock.EmitCalls))
+	}
+	if len(km.KeyEventErrors) != keyErrorQueueSize {
+		t.Errorf("Expected the error channel to hold %d failures, got %d", keyErrorQueueSize, len(km.KeyEventErrors))
+	}
+}
+
+func TestOnKeyPress_Override(t *testing.T) {
+	mock := &MockKeyboardEmitter{}
+	overrides := map[string][]int{
+		"Select": {29, 105}, // override Select to Ctrl+KP1
+	}
+	km, err := newKeyMapWithEmitter(overrides, mock, nil)
+	if err != nil {
+		t.Fatalf("newKeyMapWithEmitter failed: %v", err)
+	}
+
+	cecCode := cec.GetKeyCodeByName("Select")
+	km.OnKeyPress(cecCode)
+	km.Close()
+
+	if len(mock.EmitCalls) != 1 {
+		t.Fatalf("Expected 1 Emit call, got %d", len(mock.EmitCalls))
+	}
+	if len(mock.EmitCalls[0]) != 2 || mock.EmitCalls[0][0] != 29 || mock.EmitCalls[0][1] != 105 {
+		t.Errorf("Expected override codes [29, 105], got %v", mock.EmitCalls[0])
+	}
+}
+
+func TestOnKeyPress_VolumeKeysRoutedToController(t *testing.T) {
+	mock := &MockKeyboardEmitter{}
+	volume := &MockVolumeController{}
+	km, err := newKeyMapWithEmitter(nil, mock, volume)
+	if err != nil {
+		t.Fatalf("newKeyMapWithEmitter failed: %v", err)
+	}
+
+	km.OnKeyPress(cec.GetKeyCodeByName("VolumeUp"))
+	km.OnKeyPress(cec.GetKeyCodeByName("VolumeDown"))
+	km.OnKeyPress(0x43) // Mute
+	km.OnKeyPress(0x65) // Mute Function
+	km.OnKeyPress(0x66) // Restore Volume Function
+	km.OnKeyPress(cec.GetKeyCodeByName("Select"))
+	km.Close()
+
+	expected := []string{"VolumeUp", "VolumeDown", "Mute", "SetMute(true)", "SetMute(false)"}
+	if len(volume.Calls) != len(expected) {
+		t.Fatalf("Expected volume calls %v, got %v", expected, volume.Calls)
+	}
+	for i := range expected {
+		if volume.Calls[i] != expected[i] {
+			t.Errorf("At index %d, expected %s, got %s", i, expected[i], volume.Calls[i])
+		}
+	}
+	if len(mock.EmitCalls) != 1 {
+		t.Errorf("Expected only the navigation key to reach the keyboard, got %d Emit calls", len(mock.EmitCalls))
+	}
+}
+
+func TestHandleKey_VolumeAccel(t *testing.T) {
+	volume := &MockVolumeController{}
+	km, err := newKeyMapWithEmitter(nil, &MockKeyboardEmitter{}, volume)
+	if err != nil {
+		t.Fatalf("newKeyMapWithEmitter failed: %v", err)
+	}
+	defer km.Close()
+	clock := newFakeClock()
+	km.clock = clock
+	km.SetVolumeAccel(true)
+
+	press := func(code int) int {
+		before := len(volume.Calls)
+		km.handleKey(code)
+		clock.Advance(200 * time.Millisecond)
+		return len(volume.Calls) - before
+	}
+	// Holding the key: the steps grow, up to the cap.
+	for i, want := range []int{1, 2, 3, 4, 4} {
+		if got := press(0x41); got != want {
+			t.Errorf("Press %d: expected %d volume steps, got %d", i+1, want, got)
+		}
+	}
+	// Another volume key starts over.
+	if got := press(0x42); got != 1 {
+		t.Errorf("Expected 1 step after changing direction, got %d", got)
+	}
+	if got := press(0x42); got != 2 {
+		t.Errorf("Expected 2 steps for a consecutive press, got %d", got)
+	}
+	// So does a pause.
+	clock.Advance(volumeAccelWindow)
+	if got := press(0x42); got != 1 {
+		t.Errorf("Expected 1 step after a pause, got %d", got)
+	}
+	// Mute is never repeated.
+	press(0x43)
+	if got := press(0x43); got != 1 {
+		t.Errorf("Expected a single mute, got %d", got)
+	}
+
+	km.SetVolumeAccel(false)
+	press(0x41)
+	if got := press(0x41); got != 1 {
+		t.Errorf("Expected 1 step without acceleration, got %d", got)
+	}
+}
+
+func TestOnKeyPress_VolumeKeysWithoutController(t *testing.T) {
+	mock := &MockKeyboardEmitter{}
+	km, err := newKeyMapWithEmitter(nil, mock, nil)
+	if err != nil {
+		t.Fatalf("newKeyMapWithEmitter failed: %v", err)
+	}
+
+	km.OnKeyPress(cec.GetKeyCodeByName("VolumeUp"))
+	km.Close()
+	if len(mock.EmitCalls) != 0 {
+		t.Errorf("Expected unmapped volume key to be ignored, got %d Emit calls", len(mock.EmitCalls))
+	}
+}
+
+func TestOnKeyPress_PreservesOrder(t *testing.T) {
+	mock := &MockKeyboardEmitter{}
+	km, err := newKeyMapWithEmitter(nil, mock, nil)
+	if err != nil {
+		t.Fatalf("newKeyMapWithEmitter failed: %v", err)
+	}
+
+	keys := []string{"1", "2", "3", "4", "5"}
+	for _, k := range keys {
+		km.OnKeyPress(cec.GetKeyCodeByName(k))
+	}
+	km.Close()
+
+	if len(mock.EmitCalls) != len(keys) {
+		t.Fatalf("Expected %d Emit calls, got %d", len(keys), len(mock.EmitCalls))
+	}
+	for i, k := range keys {
+		expected := base[cec.GetKeyCodeByName(k)]
+		if mock.EmitCalls[i][0] != expected {
+			t.Errorf("At index %d, expected code %d, got %d", i, expected, mock.EmitCalls[i][0])
+		}
+	}
+}
+
+func TestOnKeyEvent_ReleasesAreNotSent(t *testing.T) {
+	mock := &MockKeyboardEmitter{}
+	km, err := newKeyMapWithEmitter(nil, mock, nil)
+	if err != nil {
+		t.Fatalf("newKeyMapWithEmitter failed: %v", err)
+	}
+
+	selectKey := cec.GetKeyCodeByName("Select")
+	km.OnKeyEvent(selectKey, 0)
+	km.OnKeyEvent(selectKey, 800*time.Millisecond)
+	km.OnKeyEvent(selectKey, 0)
+	km.Close()
+
+	if len(mock.EmitCalls) != 2 {
+		t.Errorf("Expected one key event per press, got %v", mock.EmitCalls)
+	}
+}
+
+func TestOnKeyPress_DoesNotBlockOnSlowEmitter(t *testing.T) {
+	release := make(chan struct{})
+	mock := &MockKeyboardEmitter{
+		EmitFunc: func([]int) error {
+			<-release
+			return nil
+		},
+	}
+	km, err := newKeyMapWithEmitter(nil, mock, nil)
+	if err != nil {
+		t.Fatalf("newKeyMapWithEmitter failed: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < keyQueueSize+10; i++ {
+			km.OnKeyPress(cec.GetKeyCodeByName("Select"))
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("OnKeyPress blocked on a slow emitter")
+	}
+	close(release)
+	km.Close()
+}
+
+func TestKeyMapProfiles(t *testing.T) {
+	mock := &MockKeyboardEmitter{}
+	km, err := newKeyMapWithEmitter(map[string][]int{"Exit": {1}}, mock, nil)
+	if err != nil {
+		t.Fatalf("newKeyMapWithEmitter failed: %v", err)
+	}
+	km.AddProfile("kodi", map[string][]int{"Select": {28}})
+
+	if km.Profile() != defaultProfile {
+		t.Errorf("Expected %q profile to be active, got %q", defaultProfile, km.Profile())
+	}
+	if err := km.SetProfile("unknown"); err == nil {
+		t.Error("Expected error when activating an unknown profile")
+	}
+	if err := km.SetProfile("kodi"); err != nil {
+		t.Fatalf("SetProfile failed: %v", err)
+	}
+
+	km.OnKeyPress(cec.GetKeyCodeByName("Select"))
+	km.OnKeyPress(cec.GetKeyCodeByName("Exit"))
+	km.Close()
+
+	if len(mock.EmitCalls) != 2 {
+		t.Fatalf("Expected 2 Emit calls, got %d", len(mock.EmitCalls))
+	}
+	if mock.EmitCalls[0][0] != 28 {
+		t.Errorf("Expected profile mapping 28 for Select, got %v", mock.EmitCalls[0])
+	}
+	if mock.EmitCalls[1][0] != 1 {
+		t.Errorf("Expected global override 1 for Exit in profile, got %v", mock.EmitCalls[1])
+	}
+}
+
+func TestRemotePresets(t *testing.T) {
+	if _, err := newKeyMapWithPreset(nil, "roku", &MockKeyboardEmitter{}, nil); err == nil {
+		t.Error("Expected error for an unknown remote preset")
+	}
+
+	mock := &MockKeyboardEmitter{}
+	km, err := newKeyMapWithPreset(map[string][]int{"RootMenu": {102}}, RemotePresetAndroidTV, mock, nil)
+	if err != nil {
+		t.Fatalf("newKeyMapWithPreset failed: %v", err)
+	}
+	km.AddProfile("kodi", map[string][]int{"Select": {28}})
+	if err := km.SetProfile("kodi"); err != nil {
+		t.Fatalf("SetProfile failed: %v", err)
+	}
+
+	km.OnKeyPress(cec.GetKeyCodeByName("Exit"))
+	km.OnKeyPress(cec.GetKeyCodeByName("RootMenu"))
+	km.OnKeyPress(cec.GetKeyCodeByName("Up"))
+	km.Close()
+
+	if len(mock.EmitCalls) != 3 {
+		t.Fatalf("Expected 3 Emit calls, got %d", len(mock.EmitCalls))
+	}
+	if mock.EmitCalls[0][0] != keybd.VK_BACK {
+		t.Errorf("Expected preset mapping %d for Exit in profile, got %v", keybd.VK_BACK, mock.EmitCalls[0])
+	}
+	if mock.EmitCalls[1][0] != 102 {
+		t.Errorf("Expected override 102 to win over the preset for RootMenu, got %v", mock.EmitCalls[1])
+	}
+	if mock.EmitCalls[2][0] != keybd.VK_UP {
+		t.Errorf("Expected base mapping %d for Up, got %v", keybd.VK_UP, mock.EmitCalls[2])
+	}
+}
+
+func TestOnKeyPress_IgnoredKey(t *testing.T) {
+	var logs bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	defer slog.SetDefault(prev)
+
+	mock := &MockKeyboardEmitter{}
+	km, err := newKeyMapWithEmitter(nil, mock, nil)
+	if err != nil {
+		t.Fatalf("newKeyMapWithEmitter failed: %v", err)
+	}
+	km.SetIgnoredKeys([]int{0x91, cec.GetKeyCodeByName("Select")})
+	logs.Reset()
+
+	km.OnKeyPress(0x91) // unmapped, would normally warn
+	km.OnKeyPress(cec.GetKeyCodeByName("Select"))
+	km.Close()
+
+	if len(mock.EmitCalls) != 0 {
+		t.Errorf("Expected no Emit calls for ignored keys, got %d", len(mock.EmitCalls))
+	}
+	if logs.Len() != 0 {
+		t.Errorf("Expected no log output for ignored keys, got %q", logs.String())
+	}
+}
+
+func TestOnKeyPress_AllowedKeys(t *testing.T) {
+	var logs bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelWarn})))
+	defer slog.SetDefault(prev)
+
+	mock := &MockKeyboardEmitter{}
+	km, err := newKeyMapWithEmitter(map[string][]int{"Exit": {14}}, mock, nil)
+	if err != nil {
+		t.Fatalf("newKeyMapWithEmitter failed: %v", err)
+	}
+	power := &MockPowerController{}
+	km.SetPowerController(power)
+	km.SetActions(map[string]string{"Red": actionPowerOffAll})
+	up, selectKey := cec.GetKeyCodeByName("Up"), cec.GetKeyCodeByName("Select")
+	km.SetAllowedKeys([]int{up, selectKey, 0x91})
+	// Ignoring a key wins over allowing it.
+	km.SetIgnoredKeys([]int{selectKey})
+
+	km.OnKeyPress(up)
+	km.OnKeyPress(selectKey)
+	km.OnKeyPress(cec.GetKeyCodeByName("Exit")) // mapped by an override, not allowed
+	km.OnKeyPress(cec.GetKeyCodeByName("Red"))  // bound to an action, not allowed
+	km.OnKeyPress(0x90)                         // unmapped, not allowed
+	km.Close()
+
+	if want := [][]int{{keybd.VK_UP}}; !reflect.DeepEqual(mock.EmitCalls, want) {
+		t.Errorf("Expected only Up sent, got %v", mock.EmitCalls)
+	}
+	if power.StandbyAllCalls != 0 {
+		t.Errorf("Expected no action for a key left out, got %d StandbyAll calls", power.StandbyAllCalls)
+	}
+	if logs.Len() != 0 {
+		t.Errorf("Expected no warnings for keys left out, got %q", logs.String())
+	}
+
+	// An empty list handles every key again.
+	km.SetAllowedKeys(nil)
+	if km.Ignored(cec.GetKeyCodeByName("Exit")) || !km.Ignored(selectKey) {
+		t.Error("Expected only the ignored keys dropped without an allow list")
+	}
+}
+
+func TestOnKeyPress_UnmappedWarningSampled(t *testing.T) {
+	var logs bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	defer slog.SetDefault(prev)
+
+	mock := &MockKeyboardEmitter{}
+	km, err := newKeyMapWithEmitter(nil, mock, nil)
+	if err != nil {
+		t.Fatalf("newKeyMapWithEmitter failed: %v", err)
+	}
+	km.SetUnmappedWarnInterval(time.Hour)
+
+	for i := 0; i < 5; i++ {
+		km.OnKeyPress(0x91)
+	}
+	km.OnKeyPress(0x92)
+	km.Close()
+
+	if n := bytes.Count(logs.Bytes(), []byte("Unmapped CEC key code")); n != 2 {
+		t.Errorf("Expected one warning per unmapped code (2), got %d:\n%s", n, logs.String())
+	}
+}
+
+func TestUnmappedWarning_IntervalFakeClock(t *testing.T) {
+	km, err := newKeyMapWithEmitter(nil, &MockKeyboardEmitter{}, nil)
+	if err != nil {
+		t.Fatalf("newKeyMapWithEmitter failed: %v", err)
+	}
+	defer km.Close()
+	clock := newFakeClock()
+	km.clock = clock
+	km.SetUnmappedWarnInterval(10 * time.Second)
+
+	if !km.shouldWarnUnmapped(0x91) {
+		t.Fatal("Expected the first unmapped press to warn")
+	}
+	clock.Advance(9 * time.Second)
+	if km.shouldWarnUnmapped(0x91) {
+		t.Error("Expected no warning within the interval")
+	}
+	clock.Advance(time.Second)
+	if !km.shouldWarnUnmapped(0x91) {
+		t.Error("Expected a warning again once the interval passed")
+	}
+}
+
+func TestNumberModeChannel_SubmitsAfterTimeout(t *testing.T) {
+	submitted := make(chan struct{})
+	mock := &MockKeyboardEmitter{EmitFunc: func(keyCodes []int) error {
+		if keyCodes[0] == keybd.VK_ENTER {
+			close(submitted)
+		}
+		return nil
+	}}
+	km, err := newKeyMapWithEmitter(nil, mock, nil)
+	if err != nil {
+		t.Fatalf("newKeyMapWithEmitter failed: %v", err)
+	}
+	clock := newFakeClock()
+	km.clock = clock
+	km.SetNumberMode(NumberModeChannel)
+
+	km.OnKeyPress(cec.GetKeyCodeByName("1"))
+	km.OnKeyPress(cec.GetKeyCodeByName("2"))
+	clock.waitForWaiters(t, 2)
+	clock.Advance(channelEntryTimeout)
+	select {
+	case <-submitted:
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for the channel number to be submitted")
+	}
+	km.Close()
+
+	want := [][]int{{keybd.VK_1}, {keybd.VK_2}, {keybd.VK_ENTER}}
+	if !reflect.DeepEqual(mock.EmitCalls, want) {
+		t.Errorf("Expected %v, got %v", want, mock.EmitCalls)
+	}
+}
+
+func TestNumberModeChannel_SubmitsOnKey(t *testing.T) {
+	mock := &MockKeyboardEmitter{}
+	km, err := newKeyMapWithEmitter(nil, mock, nil)
+	if err != nil {
+		t.Fatalf("newKeyMapWithEmitter failed: %v", err)
+	}
+	km.clock = newFakeClock()
+	km.SetNumberMode(NumberModeChannel)
+
+	for _, key := range []string{"4", "Select", "7", "Up"} {
+		km.OnKeyPress(cec.GetKeyCodeByName(key))
+	}
+	km.Close()
+
+	// Select submits the number and is consumed; Up submits it and is sent.
+	want := [][]int{{keybd.VK_4}, {keybd.VK_ENTER}, {keybd.VK_7}, {keybd.VK_ENTER}, {keybd.VK_UP}}
+	if !reflect.DeepEqual(mock.EmitCalls, want) {
+		t.Errorf("Expected %v, got %v", want, mock.EmitCalls)
+	}
+}
+
+func TestDoublePress(t *testing.T) {
+	mock := &MockKeyboardEmitter{}
+	km, err := newKeyMapWithEmitter(nil, mock, nil)
+	if err != nil {
+		t.Fatalf("newKeyMapWithEmitter failed: %v", err)
+	}
+	clock := newFakeClock()
+	km.clock = clock
+	power := &MockPowerController{}
+	km.SetPowerController(power)
+	km.SetActions(map[string]string{"Exit:double": "power:off-all"})
+
+	exit := cec.GetKeyCodeByName("Exit")
+	// A double press runs the action instead of sending Esc twice, the
+	// release in between does not end the window.
+	km.OnKeyPress(exit)
+	km.OnKeyEvent(exit, 90*time.Millisecond)
+	km.OnKeyPress(exit)
+	km.OnKeyEvent(exit, 90*time.Millisecond)
+	// A single press is sent once the window has passed.
+	km.OnKeyPress(exit)
+	km.OnKeyEvent(exit, 90*time.Millisecond)
+	// The timer of the first press is still pending.
+	clock.waitForWaiters(t, 2)
+	clock.Advance(defaultDoublePressWindow)
+	// A single press followed by another key is sent before it.
+	km.OnKeyPress(exit)
+	km.OnKeyEvent(exit, 90*time.Millisecond)
+	km.OnKeyPress(cec.GetKeyCodeByName("Up"))
+	km.OnKeyEvent(cec.GetKeyCodeByName("Up"), 90*time.Millisecond)
+	// A held key repeats its press without a release in between: it is sent
+	// as single presses, never taken for a double press.
+	km.OnKeyPress(exit)
+	km.OnKeyPress(exit)
+	km.OnKeyPress(exit)
+	km.OnKeyEvent(exit, 800*time.Millisecond)
+	km.Close()
+
+	if power.StandbyAllCalls != 1 {
+		t.Errorf("Expected 1 StandbyAll call, got %d", power.StandbyAllCalls)
+	}
+	want := [][]int{{keybd.VK_ESC}, {keybd.VK_ESC}, {keybd.VK_UP}, {keybd.VK_ESC}, {keybd.VK_ESC}, {keybd.VK_ESC}}
+	if !reflect.DeepEqual(mock.EmitCalls, want) {
+		t.Errorf("Expected %v, got %v", want, mock.EmitCalls)
+	}
+}
+
+func TestYdotoolEmitter_Emit(t *testing.T) {
+	var gotPath string
+	var gotArgs []string
+	y := &ydotoolEmitter{path: "/usr/bin/ydotool", run: func(path string, args ...string) error {
+		gotPath, gotArgs = path, args
+		return nil
+	}}
+
+	if err := y.Emit([]int{29, 46}); err != nil {
+		t.Fatalf("Emit failed: %v", err)
+	}
+
+	expected := []string{"key", "29:1", "46:1", "46:0", "29:0"}
+	if gotPath != "/usr/bin/ydotool" {
+		t.Errorf("Expected ydotool path, got %q", gotPath)
+	}
+	if !reflect.DeepEqual(gotArgs, expected) {
+		t.Errorf("Expected args %v, got %v", expected, gotArgs)
+	}
+}
+
+func TestNewKeyboardEmitter_UnknownBackend(t *testing.T) {
+	if _, err := newKeyboardEmitter("xdotool", ""); err == nil {
+		t.Error("Expected error for unknown key backend")
+	}
+}
+
+func TestNoopEmitter_KeepsVolumeKeys(t *testing.T) {
+	volume := &MockVolumeController{}
+	km, err := newKeyMapWithEmitter(nil, noopEmitter{}, volume)
+	if err != nil {
+		t.Fatalf("newKeyMapWithEmitter failed: %v", err)
+	}
+
+	km.OnKeyPress(cec.GetKeyCodeByName("Select"))
+	km.OnKeyPress(0x41)
+	km.Close()
+
+	if len(volume.Calls) != 1 || volume.Calls[0] != "VolumeUp" {
+		t.Errorf("Expected the volume key to reach the controller without a keyboard, got %v", volume.Calls)
+	}
+}
+
+func TestLookupAndIgnored(t *testing.T) {
+	km, err := newKeyMapWithEmitter(map[string][]int{"Select": {29, 28}}, &MockKeyboardEmitter{}, nil)
+	if err != nil {
+		t.Fatalf("newKeyMapWithEmitter failed: %v", err)
+	}
+	defer km.Close()
+	km.SetIgnoredKeys([]int{0x91})
+
+	codes, ok := km.Lookup(cec.GetKeyCodeByName("Select"))
+	if !ok || !reflect.DeepEqual(codes, []int{29, 28}) {
+		t.Errorf("Expected Select to map to [29 28], got %v (found=%v)", codes, ok)
+	}
+	if _, ok := km.Lookup(0x91); ok {
+		t.Error("Expected 0x91 to be unmapped")
+	}
+	if !km.Ignored(0x91) || km.Ignored(cec.GetKeyCodeByName("Select")) {
+		t.Error("Expected only 0x91 to be ignored")
+	}
+}
+
+func TestKeyActions_ProfileNextAndToggle(t *testing.T) {
+	mock := &MockKeyboardEmitter{}
+	km, err := newKeyMapWithEmitter(nil, mock, nil)
+	if err != nil {
+		t.Fatalf("newKeyMapWithEmitter failed: %v", err)
+	}
+	defer km.Close()
+	km.AddProfile("kodi", nil)
+	km.AddProfile("desktop", nil)
+	km.SetActions(map[string]string{"Blue": actionProfileNext, "Red": actionProfileToggle})
+
+	blue := cec.GetKeyCodeByName("Blue")
+	red := cec.GetKeyCodeByName("Red")
+
+	// Profiles cycle in name order: default -> desktop -> kodi -> default.
+	for _, expected := range []string{"desktop", "kodi", defaultProfile} {
+		km.handleKey(blue)
+		if got := km.Profile(); got != expected {
+			t.Fatalf("Expected profile %q after profile:next, got %q", expected, got)
+		}
+	}
+
+	km.handleKey(red)
+	if got := km.Profile(); got != "kodi" {
+		t.Errorf("Expected profile:toggle to go back to kodi, got %q", got)
+	}
+	km.handleKey(red)
+	if got := km.Profile(); got != defaultProfile {
+		t.Errorf("Expected profile:toggle to go back to default, got %q", got)
+	}
+
+	if len(mock.EmitCalls) != 0 {
+		t.Errorf("Expected no keystrokes for action keys, got %v", mock.EmitCalls)
+	}
+}
+
+// MockWindowActivator records activated window classes for testing.
+type MockWindowActivator struct {
+	Windows   map[string]bool
+	Activated []string
+}
+
+func (m *MockWindowActivator) Activate(class string) error {
+	if !m.Windows[class] {
+		return errWindowNotFound
+	}
+	m.Activated = append(m.Activated, class)
+	return nil
+}
+
+func TestParseWindowAction(t *testing.T) {
+	tests := []struct {
+		action   string
+		class    string
+		keyCodes []int
+		ok       bool
+	}{
+		{"win:kodi:28", "kodi", []int{28}, true},
+		{"win:firefox:29+20", "firefox", []int{29, 20}, true},
+		{"win:Navigator.firefox:28", "Navigator.firefox", []int{28}, true},
+		{"win:kodi", "", nil, false},
+		{"win::28", "", nil, false},
+		{"win:kodi:enter", "", nil, false},
+		{"profile:next", "", nil, false},
+	}
+	for _, tt := range tests {
+		class, keyCodes, ok := parseWindowAction(tt.action)
+		if ok != tt.ok || class != tt.class || !reflect.DeepEqual(keyCodes, tt.keyCodes) {
+			t.Errorf("parseWindowAction(%q) = %q, %v, %v; expected %q, %v, %v", tt.action, class, keyCodes, ok, tt.class, tt.keyCodes, tt.ok)
+		}
+		if validKeyAction(tt.action) != (tt.ok || tt.action == actionProfileNext) {
+			t.Errorf("Unexpected validKeyAction result for %q", tt.action)
+		}
+	}
+}
+
+func TestValidKeyAction_List(t *testing.T) {
+	tests := []struct {
+		action string
+		ok     bool
+	}{
+		{"key:28", true},
+		{"key:29+20", true},
+		{"key:", false},
+		{"key:28;profile:next", true},
+		{"key:28 ; win:kodi:28 ; power:off-all", true},
+		{"key:28;", false},
+		{"key:28;bogus", false},
+		{"scroll:down", true},
+		{"scroll:sideways", false},
+	}
+	for _, tt := range tests {
+		if got := validKeyAction(tt.action); got != tt.ok {
+			t.Errorf("validKeyAction(%q) = %v, expected %v", tt.action, got, tt.ok)
+		}
+	}
+}
+
+func TestKeyActions_List(t *testing.T) {
+	mock := &MockKeyboardEmitter{}
+	km, err := newKeyMapWithEmitter(nil, mock, nil)
+	if err != nil {
+		t.Fatalf("newKeyMapWithEmitter failed: %v", err)
+	}
+	defer km.Close()
+	power := &MockPowerController{}
+	km.SetPowerController(power)
+	km.AddProfile("kodi", nil)
+	km.SetActions(map[string]string{"Blue": "key:28;profile:next;power:off-all;key:29+20"})
+
+	km.handleKey(cec.GetKeyCodeByName("Blue"))
+	if !reflect.DeepEqual(mock.EmitCalls, [][]int{{28}, {29, 20}}) {
+		t.Errorf("Expected 28 then 29+20 sent in order, got %v", mock.EmitCalls)
+	}
+	if km.Profile() != "kodi" {
+		t.Errorf("Expected the profile to switch to kodi, got %q", km.Profile())
+	}
+	if power.StandbyAllCalls != 1 {
+		t.Errorf("Expected 1 StandbyAll call, got %d", power.StandbyAllCalls)
+	}
+}
+
+func TestKeyActions_Window(t *testing.T) {
+	mock := &MockKeyboardEmitter{}
+	km, err := newKeyMapWithEmitter(nil, mock, nil)
+	if err != nil {
+		t.Fatalf("newKeyMapWithEmitter failed: %v", err)
+	}
+	defer km.Close()
+	windows := &MockWindowActivator{Windows: map[string]bool{"kodi": true}}
+	km.windows = windows
+	km.SetActions(map[string]string{"Blue": "win:kodi:28", "Red": "win:vlc:57"})
+
+	km.handleKey(cec.GetKeyCodeByName("Blue"))
+	if !reflect.DeepEqual(windows.Activated, []string{"kodi"}) || !reflect.DeepEqual(mock.EmitCalls, [][]int{{28}}) {
+		t.Errorf("Expected kodi to be focused and 28 sent, got %v and %v", windows.Activated, mock.EmitCalls)
+	}
+
+	// No vlc window: the key press is dropped rather than sent elsewhere.
+	km.handleKey(cec.GetKeyCodeByName("Red"))
+	if len(mock.EmitCalls) != 1 {
+		t.Errorf("Expected nothing sent without a target window, got %v", mock.EmitCalls)
+	}
+}
+
+// MockPowerController counts StandbyAll and OneTouchPlay calls for testing.
+type MockPowerController struct {
+	StandbyAllCalls   int
+	OneTouchPlayCalls int
+}
+
+func (m *MockPowerController) StandbyAll() error {
+	m.StandbyAllCalls++
+	return nil
+}
+
+func (m *MockPowerController) OneTouchPlay() error {
+	m.OneTouchPlayCalls++
+	return nil
+}
+
+func TestKeyActions_PowerOffAll(t *testing.T) {
+	mock := &MockKeyboardEmitter{}
+	km, err := newKeyMapWithEmitter(nil, mock, nil)
+	if err != nil {
+		t.Fatalf("newKeyMapWithEmitter failed: %v", err)
+	}
+	defer km.Close()
+	km.SetActions(map[string]string{"Red": actionPowerOffAll})
+	red := cec.GetKeyCodeByName("Red")
+
+	// Without a power controller the action is a no-op.
+	km.handleKey(red)
+
+	power := &MockPowerController{}
+	km.SetPowerController(power)
+	km.handleKey(red)
+	if power.StandbyAllCalls != 1 {
+		t.Errorf("Expected one StandbyAll call, got %d", power.StandbyAllCalls)
+	}
+	if len(mock.EmitCalls) != 0 {
+		t.Errorf("Expected no keystrokes for power:off-all, got %v", mock.EmitCalls)
+	}
+}
+
+func TestKeyActions_OneTouchPlay(t *testing.T) {
+	mock := &MockKeyboardEmitter{}
+	km, err := newKeyMapWithEmitter(nil, mock, nil)
+	if err != nil {
+		t.Fatalf("newKeyMapWithEmitter failed: %v", err)
+	}
+	defer km.Close()
+	if !validKeyAction(actionOneTouchPlay) {
+		t.Fatalf("Expected %q to be a valid key action", actionOneTouchPlay)
+	}
+	km.SetActions(map[string]string{"Green": actionOneTouchPlay})
+
+	power := &MockPowerController{}
+	km.SetPowerController(power)
+	km.handleKey(cec.GetKeyCodeByName("Green"))
+	if power.OneTouchPlayCalls != 1 || power.StandbyAllCalls != 0 {
+		t.Errorf("Expected one OneTouchPlay call, got %d (StandbyAll %d)", power.OneTouchPlayCalls, power.StandbyAllCalls)
+	}
+	if len(mock.EmitCalls) != 0 {
+		t.Errorf("Expected no keystrokes for cec:onetouchplay, got %v", mock.EmitCalls)
+	}
+}
+
+// MockWheelEmitter records the scrolls sent to the virtual wheel.
+type MockWheelEmitter struct {
+	Scrolls [][2]int
+}
+
+func (m *MockWheelEmitter) Scroll(horizontal, vertical int) error {
+	m.Scrolls = append(m.Scrolls, [2]int{horizontal, vertical})
+	return nil
+}
+
+func TestKeyActions_Scroll(t *testing.T) {
+	mock := &MockKeyboardEmitter{}
+	km, err := newKeyMapWithEmitter(nil, mock, nil)
+	if err != nil {
+		t.Fatalf("newKeyMapWithEmitter failed: %v", err)
+	}
+	defer km.Close()
+	actions := map[string]string{"Up": "scroll:up", "Left": "scroll:left"}
+	if !usesScrollActions(actions) || usesScrollActions(map[string]string{"Up": "key:103"}) {
+		t.Error("Expected the wheel needed only for scroll actions")
+	}
+	km.SetActions(actions)
+
+	// Without a wheel the key is dropped rather than sent as an arrow.
+	km.handleKey(cec.GetKeyCodeByName("Up"))
+	if len(mock.EmitCalls) != 0 {
+		t.Errorf("Expected no keystrokes for a scroll action, got %v", mock.EmitCalls)
+	}
+
+	wheel := &MockWheelEmitter{}
+	km.SetWheelEmitter(wheel)
+	km.SetScrollAmount(3)
+	km.handleKey(cec.GetKeyCodeByName("Up"))
+	km.handleKey(cec.GetKeyCodeByName("Left"))
+	if len(wheel.Scrolls) != 2 || wheel.Scrolls[0] != [2]int{0, 3} || wheel.Scrolls[1] != [2]int{-3, 0} {
+		t.Errorf("Expected 3 clicks up then 3 to the left, got %v", wheel.Scrolls)
+	}
+}
+
+// keybdBenchEmitter does the work keybdEmitter does for a key event, with the
+// input events keybd_event writes to uinput (a press and a release of every
+// key, each followed by a sync) encoded the same way but written to
+// io.Discard. It signals every key event on delivered.
+type keybdBenchEmitter struct {
+	kb        keybd.KeyBonding
+	delivered chan struct{}
+}
+
+func (e *keybdBenchEmitter) Emit(keyCodes []int) error {
+	e.kb.SetKeys(keyCodes...)
+	for _, value := range []int32{1, 0} {
+		for _, code := range keyCodes {
+			if err := binary.Write(io.Discard, binary.LittleEndian, &inputEvent{Type: 0x01, Code: uint16(code), Value: value}); err != nil {
+				return err
+			}
+		}
+		if err := binary.Write(io.Discard, binary.LittleEndian, &inputEvent{Type: evSyn, Code: synReport}); err != nil {
+			return err
+		}
+	}
+	e.delivered <- struct{}{}
+	return nil
+}
+
+func BenchmarkOnKeyPress(b *testing.B) {
+	emitter := &keybdBenchEmitter{delivered: make(chan struct{}, 1)}
+	km, err := newKeyMapWithEmitter(nil, emitter, nil)
+	if err != nil {
+		b.Fatalf("newKeyMapWithEmitter failed: %v", err)
+	}
+	defer km.Close()
+	code := cec.GetKeyCodeByName("Select")
+	b.ReportAllocs()
+	for b.Loop() {
+		km.OnKeyEvent(code, 0)
+		<-emitter.delivered
+	}
+}
+
+func TestShiftLayer(t *testing.T) {
+	mock := &MockKeyboardEmitter{}
+	km, err := newKeyMapWithEmitter(nil, mock, nil)
+	if err != nil {
+		t.Fatalf("newKeyMapWithEmitter failed: %v", err)
+	}
+	power := &MockPowerController{}
+	km.SetPowerController(power)
+	km.SetShiftLayer(&ShiftLayer{
+		Key:     "Blue",
+		KeyMap:  map[string][]int{"Up": {keybd.VK_PAGEUP}},
+		Actions: map[string]string{"Select": "power:off-all"},
+	})
+
+	shift := cec.GetKeyCodeByName("Blue")
+	up := cec.GetKeyCodeByName("Up")
+	selectKey := cec.GetKeyCodeByName("Select")
+	down := cec.GetKeyCodeByName("Down")
+	km.OnKeyPress(up)
+	// libcec: the held modifier repeats, and is released when Up is pressed.
+	km.OnKeyPress(shift)
+	km.OnKeyPress(shift)
+	km.OnKeyEvent(shift, 600*time.Millisecond)
+	km.OnKeyPress(up)
+	km.OnKeyEvent(up, 200*time.Millisecond)
+	// The layer only applies to the key right after the modifier.
+	km.OnKeyPress(up)
+	km.OnKeyEvent(up, 200*time.Millisecond)
+	// cec-client: the release that follows Up is reported as Up's.
+	km.OnKeyPress(shift)
+	km.OnKeyPress(selectKey)
+	km.OnKeyEvent(selectKey, 300*time.Millisecond)
+	// A key the layer does not mention keeps its meaning and uses it up.
+	km.OnKeyPress(shift)
+	km.OnKeyPress(down)
+	km.OnKeyPress(up)
+	km.Close()
+
+	want := [][]int{{keybd.VK_UP}, {keybd.VK_PAGEUP}, {keybd.VK_UP}, {keybd.VK_DOWN}, {keybd.VK_UP}}
+	if !reflect.DeepEqual(mock.EmitCalls, want) {
+		t.Errorf("Expected %v, got %v", want, mock.EmitCalls)
+	}
+	if power.StandbyAllCalls != 1 {
+		t.Errorf("Expected the layer action to run once, got %d", power.StandbyAllCalls)
+	}
+}
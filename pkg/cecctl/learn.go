@@ -0,0 +1,221 @@
+package cecctl
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/claes/cec"
+	"github.com/spf13/cobra"
+)
+
+// newLearnCmd returns the learn subcommand, which builds a keymap file from
+// the buttons pressed on the remote.
+func newLearnCmd() *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:   "learn",
+		Short: "Build a keymap file by pressing the remote's buttons",
+		Long: `Opens the CEC adapter and, for each button pressed on the remote, prints its
+name and asks for the Linux key codes to send (e.g. 28, or 29+20 for Ctrl+T).
+An empty answer skips the button, "q" or end of input finishes. The answers
+are merged into the keymap file (--output, or keymap-file from the
+configuration), written as CSV for a .csv file and YAML otherwise. The
+profiles and actions of a file grouped in sections are kept, comments are not.
+
+Stop a running cec-controller first: the adapter can only be opened once.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			discardTempQueueDir(cfg)
+			if err := validateConfig(cfg); err != nil {
+				return err
+			}
+			if output == "" {
+				output = cfg.KeyMapFile
+			}
+			if output == "" {
+				return errors.New("no keymap file to write, use --output or set keymap-file")
+			}
+			file, err := loadKeyMapFile(output)
+			if errors.Is(err, fs.ErrNotExist) {
+				file = &keyMapFile{}
+			} else if err != nil {
+				return fmt.Errorf("failed to read keymap file %s: %w", output, err)
+			}
+
+			ctx, cancel := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGTERM)
+			defer cancel()
+
+			keyPresses := make(chan *cec.KeyPress, 32)
+			c, err := NewCEC(cfg.CECBackend, cfg.CECAdapter, cfg.HDMIPort, cfg.DeviceName, cfg.ConnectionRetries, cfg.PowerCommandRetries, keyPresses)
+			if err != nil {
+				return fmt.Errorf("failed to open CEC adapter: %w", err)
+			}
+			defer c.Close()
+			commands := make(chan *cec.Command, 32)
+			c.SetCommandsChan(commands)
+			go forwardVendorKeys(ctx, commands, keyPresses)
+
+			out := cmd.OutOrStdout()
+			if file.KeyMap == nil {
+				file.KeyMap = map[string][]int{}
+			}
+			n, err := runLearn(ctx, cmd.InOrStdin(), out, keyPresses, file.KeyMap)
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				fmt.Fprintln(out, "Nothing learned, keymap file left unchanged")
+				return nil
+			}
+			if err := writeKeyMapFile(output, file); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "Wrote %d key(s) to %s\n", n, output)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&output, "output", "", "Keymap file to update (default keymap-file from the configuration)")
+	return cmd
+}
+
+// runLearn asks, for each key press, which Linux key codes it should send and
+// records the answers in learned under the key's name. It returns the number
+// of keys learned once the user finishes, the input ends or ctx is done.
+func runLearn(ctx context.Context, in io.Reader, out io.Writer, keyPresses <-chan *cec.KeyPress, learned map[string][]int) (int, error) {
+	// Entries read from a YAML file have lowercased names; use the names
+	// printed below so a button learned again replaces its entry.
+	for cecKey, keyCodes := range learned {
+		if name, ok := keyName(keyCodeByName(cecKey)); ok && name != cecKey {
+			delete(learned, cecKey)
+			learned[name] = keyCodes
+		}
+	}
+
+	answers := bufio.NewScanner(in)
+	n := 0
+	fmt.Fprintln(out, "Press a button on the remote (Ctrl+C to finish)")
+	for {
+		var kp *cec.KeyPress
+		select {
+		case <-ctx.Done():
+			return n, nil
+		case kp = <-keyPresses:
+		}
+		if kp == nil || kp.Duration != 0 {
+			continue
+		}
+		name, ok := keyName(kp.KeyCode)
+		if !ok {
+			fmt.Fprintf(out, "Key 0x%02X has no name the keymap accepts, skipping it\n", kp.KeyCode)
+			continue
+		}
+
+		for {
+			fmt.Fprintf(out, "%s (0x%02X): Linux key codes (e.g. 28 or 29+20, empty to skip, q to finish)", name, kp.KeyCode)
+			if current, ok := learned[name]; ok {
+				fmt.Fprintf(out, " [%s]", formatKeyCodes(current))
+			}
+			fmt.Fprint(out, ": ")
+			if !answers.Scan() {
+				fmt.Fprintln(out)
+				return n, answers.Err()
+			}
+			answer := strings.TrimSpace(answers.Text())
+			if answer == "q" {
+				return n, nil
+			}
+			if answer == "" {
+				break
+			}
+			keyCodes, ok := parseActionKeyCodes(answer)
+			if !ok {
+				fmt.Fprintf(out, "Invalid key codes %q\n", answer)
+				continue
+			}
+			learned[name] = keyCodes
+			n++
+			break
+		}
+
+		// Drop the presses made while answering, e.g. a held button.
+		for len(keyPresses) > 0 {
+			<-keyPresses
+		}
+		fmt.Fprintln(out, "Press the next button")
+	}
+}
+
+// writeKeyMapFile writes file to path in the format loadKeyMapFile reads:
+// CSV for a .csv file, YAML otherwise, grouped in sections when it has
+// profiles or actions.
+func writeKeyMapFile(path string, file *keyMapFile) error {
+	var b strings.Builder
+	b.WriteString("# Keymap written by cec-controller learn\n")
+	switch {
+	case strings.EqualFold(filepath.Ext(path), ".csv"):
+		for _, name := range sortedKeys(file.KeyMap) {
+			fmt.Fprintf(&b, "%s,%s\n", name, formatKeyCodes(file.KeyMap[name]))
+		}
+	case file.grouped():
+		fmt.Fprintf(&b, "%s:\n", keyMapSectionKeymap)
+		writeYAMLKeyMap(&b, file.KeyMap, "  ")
+		fmt.Fprintf(&b, "%s:\n", keyMapSectionProfiles)
+		for _, profile := range sortedKeys(file.Profiles) {
+			fmt.Fprintf(&b, "  %s:\n", strconv.Quote(profile))
+			writeYAMLKeyMap(&b, file.Profiles[profile], "    ")
+		}
+		fmt.Fprintf(&b, "%s:\n", keyMapSectionActions)
+		for _, name := range sortedKeys(file.Actions) {
+			fmt.Fprintf(&b, "  %s: %s\n", strconv.Quote(name), strconv.Quote(file.Actions[name]))
+		}
+	default:
+		writeYAMLKeyMap(&b, file.KeyMap, "")
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+		return fmt.Errorf("failed to write keymap file: %w", err)
+	}
+	return nil
+}
+
+// writeYAMLKeyMap writes the mappings of keyMap as YAML, each line prefixed
+// with indent.
+func writeYAMLKeyMap(b *strings.Builder, keyMap map[string][]int, indent string) {
+	for _, name := range sortedKeys(keyMap) {
+		fmt.Fprintf(b, "%s%s: %s\n", indent, strconv.Quote(name), strconv.Quote(formatKeyCodes(keyMap[name])))
+	}
+}
+
+// sortedKeys returns the keys of m in order, so written files are stable.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatKeyCodes formats Linux key codes as in the keymap, e.g. "29+20".
+func formatKeyCodes(keyCodes []int) string {
+	parts := make([]string, len(keyCodes))
+	for i, code := range keyCodes {
+		parts[i] = strconv.Itoa(code)
+	}
+	return strings.Join(parts, "+")
+}
@@ -0,0 +1,86 @@
+package cecctl
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/claes/cec"
+)
+
+func TestRunLearn(t *testing.T) {
+	// Unbuffered, so no press is dropped as made while answering.
+	keyPresses := make(chan *cec.KeyPress)
+	go func() {
+		keyPresses <- &cec.KeyPress{KeyCode: cec.GetKeyCodeByName("Select")}
+		keyPresses <- &cec.KeyPress{KeyCode: cec.GetKeyCodeByName("Select"), Duration: 100}
+		keyPresses <- &cec.KeyPress{KeyCode: cec.GetKeyCodeByName("Blue")}
+		keyPresses <- &cec.KeyPress{KeyCode: cec.GetKeyCodeByName("Exit")}
+		keyPresses <- &cec.KeyPress{KeyCode: cec.GetKeyCodeByName("Up")}
+	}()
+
+	// Blue is skipped, Exit gets an invalid answer first, Up finishes.
+	in := strings.NewReader("28\n\nbogus\n1\nq\n")
+	learned := map[string][]int{"exit": {14}, "down": {108}}
+	var out bytes.Buffer
+	n, err := runLearn(context.Background(), in, &out, keyPresses, learned)
+	if err != nil {
+		t.Fatalf("runLearn failed: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Expected 2 keys learned, got %d", n)
+	}
+	want := map[string][]int{"Select": {28}, "Exit": {1}, "Down": {108}}
+	if !reflect.DeepEqual(learned, want) {
+		t.Errorf("Expected %v, got %v", want, learned)
+	}
+	if !strings.Contains(out.String(), `Invalid key codes "bogus"`) || !strings.Contains(out.String(), "Exit (0x0D): Linux key codes (e.g. 28 or 29+20, empty to skip, q to finish) [14]: ") {
+		t.Errorf("Unexpected output:\n%s", out.String())
+	}
+}
+
+func TestWriteKeyMapFile_RoundTrip(t *testing.T) {
+	keyMap := map[string][]int{"Select": {28}, "Blue": {29, 20}, "vendor:0091": {1}}
+	for _, name := range []string{"keymap.csv", "keymap.yaml"} {
+		path := filepath.Join(t.TempDir(), name)
+		if err := writeKeyMapFile(path, &keyMapFile{KeyMap: keyMap}); err != nil {
+			t.Fatalf("writeKeyMapFile failed: %v", err)
+		}
+		file, err := loadKeyMapFile(path)
+		if err != nil {
+			t.Fatalf("loadKeyMapFile failed: %v", err)
+		}
+		loaded := file.KeyMap
+		if len(loaded) != len(keyMap) {
+			t.Fatalf("%s: expected %d entries, got %v", name, len(keyMap), loaded)
+		}
+		for cecKey, linuxCodes := range loaded {
+			name, _ := keyName(keyCodeByName(cecKey))
+			if !reflect.DeepEqual(keyMap[name], linuxCodes) {
+				t.Errorf("%s: expected %s to map to %v, got %v", name, cecKey, keyMap[name], linuxCodes)
+			}
+		}
+	}
+}
+
+func TestWriteKeyMapFile_KeepsSections(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keymap.yaml")
+	file := &keyMapFile{
+		KeyMap:   map[string][]int{"select": {28}},
+		Profiles: map[string]map[string][]int{"kodi": {"exit": {14}}},
+		Actions:  map[string]string{"blue": "profile:next"},
+	}
+	if err := writeKeyMapFile(path, file); err != nil {
+		t.Fatalf("writeKeyMapFile failed: %v", err)
+	}
+	loaded, err := loadKeyMapFile(path)
+	if err != nil {
+		t.Fatalf("loadKeyMapFile failed: %v", err)
+	}
+	if !reflect.DeepEqual(loaded, file) {
+		t.Errorf("Expected %+v, got %+v", file, loaded)
+	}
+}
@@ -0,0 +1,94 @@
+package cecctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"log/syslog"
+	"os"
+)
+
+// multiHandler fans every record out to several handlers, each keeping its
+// own level and format.
+type multiHandler []slog.Handler
+
+func (m multiHandler) Enabled(ctx context.Context, lvl slog.Level) bool {
+	for _, h := range m {
+		if h.Enabled(ctx, lvl) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m multiHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range m {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(multiHandler, len(m))
+	for i, h := range m {
+		handlers[i] = h.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (m multiHandler) WithGroup(name string) slog.Handler {
+	handlers := make(multiHandler, len(m))
+	for i, h := range m {
+		handlers[i] = h.WithGroup(name)
+	}
+	return handlers
+}
+
+// dropTime removes the timestamp, for outputs where systemd or syslog
+// already adds one.
+func dropTime(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.TimeKey && len(groups) == 0 {
+		return slog.Attr{}
+	}
+	return a
+}
+
+// newLogHandler builds the handler writing to stderr and, when set, to a log
+// file and syslog. The returned closers must be closed on shutdown.
+func newLogHandler(lvl slog.Leveler, logFile string, useSyslog bool) (slog.Handler, []io.Closer, error) {
+	handlers := multiHandler{slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: lvl, ReplaceAttr: dropTime})}
+	var closers []io.Closer
+
+	if logFile != "" {
+		// O_APPEND keeps writes at the end of the file when logrotate
+		// truncates it (copytruncate).
+		f, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open log file: %w", err)
+		}
+		closers = append(closers, f)
+		handlers = append(handlers, slog.NewTextHandler(f, &slog.HandlerOptions{Level: lvl}))
+	}
+
+	if useSyslog {
+		w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, "cec-controller")
+		if err != nil {
+			for _, c := range closers {
+				c.Close()
+			}
+			return nil, nil, fmt.Errorf("failed to connect to syslog: %w", err)
+		}
+		closers = append(closers, w)
+		handlers = append(handlers, slog.NewTextHandler(w, &slog.HandlerOptions{Level: lvl, ReplaceAttr: dropTime}))
+	}
+
+	if len(handlers) == 1 {
+		return handlers[0], closers, nil
+	}
+	return handlers, closers, nil
+}
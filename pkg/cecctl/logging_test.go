@@ -0,0 +1,66 @@
+package cecctl
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMultiHandler_FansOut(t *testing.T) {
+	var info, debug bytes.Buffer
+	logger := slog.New(multiHandler{
+		slog.NewTextHandler(&info, &slog.HandlerOptions{Level: slog.LevelInfo}),
+		slog.NewTextHandler(&debug, &slog.HandlerOptions{Level: slog.LevelDebug}),
+	}).With("component", "test")
+
+	logger.Debug("only debug")
+	logger.Info("both")
+
+	if strings.Contains(info.String(), "only debug") || !strings.Contains(info.String(), "both") {
+		t.Errorf("Unexpected info output: %q", info.String())
+	}
+	if !strings.Contains(debug.String(), "only debug") || !strings.Contains(debug.String(), "component=test") {
+		t.Errorf("Unexpected debug output: %q", debug.String())
+	}
+}
+
+func TestNewLogHandler_LogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cec-controller.log")
+	if err := os.WriteFile(path, []byte("previous run\n"), 0o644); err != nil {
+		t.Fatalf("Failed to seed log file: %v", err)
+	}
+
+	handler, closers, err := newLogHandler(slog.LevelWarn, path, false)
+	if err != nil {
+		t.Fatalf("newLogHandler failed: %v", err)
+	}
+	logger := slog.New(handler)
+	logger.Info("filtered out")
+	logger.Warn("hello from the test")
+	for _, c := range closers {
+		c.Close()
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+	if !strings.HasPrefix(string(data), "previous run\n") {
+		t.Errorf("Expected the log file to be appended to, got %q", data)
+	}
+	if strings.Contains(string(data), "filtered out") {
+		t.Errorf("Expected records below the level to be dropped, got %q", data)
+	}
+	if !strings.Contains(string(data), "hello from the test") || !strings.Contains(string(data), "time=") {
+		t.Errorf("Expected a timestamped log line in the file, got %q", data)
+	}
+}
+
+func TestNewLogHandler_BadLogFile(t *testing.T) {
+	if _, _, err := newLogHandler(slog.LevelInfo, filepath.Join(t.TempDir(), "missing", "x.log"), false); err == nil {
+		t.Error("Expected error for a log file in a missing directory")
+	}
+}
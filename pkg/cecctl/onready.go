@@ -0,0 +1,45 @@
+package cecctl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// runOnReady runs the --on-ready shell command once the CEC connection is
+// established, killing it after timeout. Its output is logged line by line.
+func runOnReady(ctx context.Context, command string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// The command itself is not logged: it may embed secrets.
+	slog.Info("Running on-ready command")
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	// Kill the whole process group on timeout, not only the shell, so a
+	// command left running does not hold the output open.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) }
+	cmd.WaitDelay = time.Second
+	err := cmd.Run()
+	for _, line := range strings.Split(strings.TrimRight(out.String(), "\n"), "\n") {
+		if line != "" {
+			slog.Info("on-ready output", "line", line)
+		}
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("on-ready command timed out after %s", timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("on-ready command failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package cecctl
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunOnReady(t *testing.T) {
+	if err := runOnReady(context.Background(), "echo switching input; true", time.Second); err != nil {
+		t.Errorf("Expected success, got %v", err)
+	}
+	if err := runOnReady(context.Background(), "exit 3", time.Second); err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Errorf("Expected the exit status in the error, got %v", err)
+	}
+	if err := runOnReady(context.Background(), "sleep 5", 50*time.Millisecond); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+}
+
+func TestRunOnReady_CommandNotLogged(t *testing.T) {
+	var logs bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	defer slog.SetDefault(prev)
+
+	if err := runOnReady(context.Background(), "TOKEN=s3cret true", time.Second); err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	if strings.Contains(logs.String(), "s3cret") {
+		t.Errorf("Expected the on-ready command left out of the logs, got\n%s", logs.String())
+	}
+}
@@ -0,0 +1,317 @@
+package cecctl
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// PowerEventType is the kind of system power change a PowerEvent reports.
+type PowerEventType int
+
+const (
+	PowerOn PowerEventType = iota
+	PowerSleep
+	PowerResume
+	PowerShutdown
+)
+
+// PowerEvent is a system power change, as reported by logind.
+type PowerEvent struct {
+	Type   PowerEventType
+	Active bool // true if the event is starting (e.g., going to sleep), false if ending (e.g., resuming)
+}
+
+// Startup events, selected with --startup-event: the power event injected
+// when the service starts.
+const (
+	StartupEventOn     = "on"     // PowerOn
+	StartupEventResume = "resume" // PowerResume, e.g. to also switch to resume-input
+	StartupEventNone   = "none"   // nothing, devices are left as they are
+)
+
+// startupPowerEvent returns the power event injected on startup for the given
+// --startup-event value, if any.
+func startupPowerEvent(startupEvent string) (PowerEvent, bool) {
+	switch startupEvent {
+	case StartupEventResume:
+		return PowerEvent{Type: PowerResume, Active: true}, true
+	case StartupEventNone:
+		return PowerEvent{}, false
+	}
+	return PowerEvent{Type: PowerOn, Active: true}, true
+}
+
+// Policies for power events of an unknown type, e.g. read from a queue
+// written by a newer version, selected with --unknown-power-events.
+const (
+	// UnknownPowerEventsIgnore logs the event and does nothing.
+	UnknownPowerEventsIgnore = "ignore"
+	// UnknownPowerEventsStandby puts devices to standby when the event is
+	// starting, like sleep and shutdown, so the display is not left on.
+	UnknownPowerEventsStandby = "standby"
+)
+
+// standbyOnUnknownPowerEvent reports whether an event of an unknown type puts
+// devices to standby under the given policy.
+func standbyOnUnknownPowerEvent(policy string, ev PowerEvent) bool {
+	return policy == UnknownPowerEventsStandby && ev.Active
+}
+
+// PowerEventSources selects the logind signals PowerEventListener forwards,
+// see --no-sleep-events, --no-resume-events and --no-shutdown-events.
+type PowerEventSources struct {
+	Sleep    bool // PrepareForSleep(true)
+	Resume   bool // PrepareForSleep(false)
+	Shutdown bool // PrepareForShutdown
+}
+
+// allPowerEventSources forwards every power event.
+var allPowerEventSources = PowerEventSources{Sleep: true, Resume: true, Shutdown: true}
+
+// any reports whether at least one source is enabled.
+func (s PowerEventSources) any() bool {
+	return s.Sleep || s.Resume || s.Shutdown
+}
+
+// powerEventFromSignal converts a logind signal into a PowerEvent. It returns
+// false for malformed or unknown signals and for disabled sources.
+func powerEventFromSignal(sig *dbus.Signal, sources PowerEventSources) (PowerEvent, bool) {
+	if sig == nil || len(sig.Body) == 0 {
+		return PowerEvent{}, false
+	}
+	active, ok := sig.Body[0].(bool)
+	if !ok {
+		return PowerEvent{}, false
+	}
+	switch sig.Name {
+	case "org.freedesktop.login1.Manager.PrepareForSleep":
+		if active && sources.Sleep {
+			return PowerEvent{Type: PowerSleep, Active: true}, true
+		}
+		if !active && sources.Resume {
+			return PowerEvent{Type: PowerResume, Active: false}, true
+		}
+	case "org.freedesktop.login1.Manager.PrepareForShutdown":
+		if sources.Shutdown {
+			return PowerEvent{Type: PowerShutdown, Active: active}, true
+		}
+	}
+	return PowerEvent{}, false
+}
+
+// connectSystemBus connects to the D-Bus bus logind is reachable on. An empty
+// address uses the system bus, which honours DBUS_SYSTEM_BUS_ADDRESS. The
+// connection is private rather than godbus's shared one, so the caller owns
+// it and must close it.
+func connectSystemBus(address string) (*dbus.Conn, error) {
+	if address == "" {
+		conn, err := dbus.ConnectSystemBus()
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to system bus: %w", err)
+		}
+		return conn, nil
+	}
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to D-Bus at %q: %w", address, err)
+	}
+	return conn, nil
+}
+
+// Delays between attempts to reach logind, see retryPowerEventListener.
+const (
+	powerEventRetryMin = time.Second
+	powerEventRetryMax = 30 * time.Second
+)
+
+// PowerEventListener subscribes to systemd-logind D-Bus signals and sends events on the channel.
+// address selects the bus to use, see connectSystemBus. Only the signals
+// enabled in sources are subscribed to and forwarded. When the connection is
+// lost later on, e.g. the bus restarts, it is reopened in the background.
+func PowerEventListener(ctx context.Context, address string, sources PowerEventSources, events chan<- PowerEvent) error {
+	return listenPowerEvents(ctx, address, sources, events, realClock{})
+}
+
+func listenPowerEvents(ctx context.Context, address string, sources PowerEventSources, events chan<- PowerEvent, clock Clock) error {
+	if !sources.any() {
+		return nil
+	}
+	conn, err := connectSystemBus(address)
+	if err != nil {
+		return err
+	}
+
+	// Subscribe to PrepareForSleep and PrepareForShutdown signals from logind
+	if sources.Sleep || sources.Resume {
+		if err := conn.AddMatchSignal(dbus.WithMatchSender("org.freedesktop.login1"),
+			dbus.WithMatchInterface("org.freedesktop.login1.Manager"),
+			dbus.WithMatchMember("PrepareForSleep"),
+		); err != nil {
+			conn.Close()
+			return fmt.Errorf("failed to add match for sleep signals: %w", err)
+		}
+	}
+	if sources.Shutdown {
+		if err := conn.AddMatchSignal(dbus.WithMatchSender("org.freedesktop.login1"),
+			dbus.WithMatchInterface("org.freedesktop.login1.Manager"),
+			dbus.WithMatchMember("PrepareForShutdown"),
+		); err != nil {
+			conn.Close()
+			return fmt.Errorf("failed to add match for shutdown signals: %w", err)
+		}
+	}
+
+	signalCh := make(chan *dbus.Signal, 10)
+	conn.Signal(signalCh)
+
+	go func() {
+		lost := forwardPowerSignals(ctx, signalCh, sources, events)
+		conn.Close()
+		if lost {
+			slog.Warn("Lost the D-Bus connection, reconnecting to receive power events")
+			retryPowerEventListener(ctx, address, sources, events, clock)
+		}
+	}()
+
+	return nil
+}
+
+// forwardPowerSignals sends the power events of the signals received on
+// signalCh until ctx is done. It returns true when signalCh is closed first,
+// which godbus does when the connection is lost.
+func forwardPowerSignals(ctx context.Context, signalCh <-chan *dbus.Signal, sources PowerEventSources, events chan<- PowerEvent) bool {
+	for {
+		select {
+		case sig, ok := <-signalCh:
+			if !ok {
+				return true
+			}
+			ev, ok := powerEventFromSignal(sig, sources)
+			if !ok {
+				continue
+			}
+			select {
+			case events <- ev:
+			default:
+				slog.Warn("Power event channel full, dropping power event", "type", ev.Type)
+			}
+			slog.Debug("Power event", "type", ev.Type, "active", ev.Active)
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// retryPowerEventListener starts the power event listener once logind is
+// reachable, for services started before the system bus. Attempts are spaced
+// from powerEventRetryMin, doubling up to powerEventRetryMax, until one
+// succeeds or ctx is done.
+func retryPowerEventListener(ctx context.Context, address string, sources PowerEventSources, events chan<- PowerEvent, clock Clock) {
+	delay := powerEventRetryMin
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-clock.After(delay):
+		}
+		err := listenPowerEvents(ctx, address, sources, events, clock)
+		if err == nil {
+			slog.Info("Power event listener started")
+			return
+		}
+		delay = min(delay*2, powerEventRetryMax)
+		slog.Debug("Power event listener still unavailable", "error", err, "retry-in", delay)
+	}
+}
+
+// powerSequence orders the power events of a shutdown. logind may report a
+// resume while a shutdown is in progress, and handling it would power the
+// devices back on after their standby; once a shutdown starts, events that
+// power devices on are ignored until it is cancelled.
+type powerSequence struct {
+	shuttingDown bool
+}
+
+// accept reports whether ev should be handled, and records the shutdowns
+// starting or being cancelled.
+func (s *powerSequence) accept(ev PowerEvent) bool {
+	switch ev.Type {
+	case PowerShutdown:
+		s.shuttingDown = ev.Active
+	case PowerOn, PowerResume:
+		return !s.shuttingDown
+	}
+	return true
+}
+
+// powerAction is what is done for a power event. It is decided by
+// planPowerAction, for the main loop and for replay alike.
+type powerAction struct {
+	// Skip tells why nothing is done for the event, if so.
+	Skip string
+	// Unknown is set for an event of an unknown type.
+	Unknown bool
+	// PowerOn powers Devices on, rather than putting them to standby.
+	PowerOn bool
+	// OneTouchPlay wakes the TV with One Touch Play, before powering on
+	// Devices.
+	OneTouchPlay bool
+	Devices      []int
+	// StandbyAll puts every device to standby with a broadcast, in place of
+	// Devices.
+	StandbyAll bool
+	// StreamPath, if set, is the physical address of the input the TV is
+	// switched to once powered on, e.g. 2.0.0.0.
+	StreamPath string
+}
+
+// targets returns the addresses the power commands of a are sent to.
+func (a powerAction) targets() []int {
+	switch {
+	case a.OneTouchPlay:
+		return append([]int{cecAddressTV}, a.Devices...)
+	case a.StandbyAll:
+		return []int{broadcastAddress}
+	}
+	return a.Devices
+}
+
+// planPowerAction decides what is done for ev under cfg, which has been
+// validated, when it is handled at now. A zero now, for a replayed event of
+// unknown time, is never within the quiet hours.
+func planPowerAction(cfg *Config, ev PowerEvent, now time.Time) powerAction {
+	switch ev.Type {
+	case PowerOn, PowerResume:
+		quiet, _ := parseQuietHours(cfg.QuietHours) // already checked by validateConfig
+		if quiet != nil && !now.IsZero() && quiet.Contains(now) {
+			return powerAction{Skip: "quiet hours " + cfg.QuietHours}
+		}
+		action := powerAction{PowerOn: true, Devices: cfg.PowerDevices}
+		if ev.Type == PowerResume {
+			if cfg.ResumeOneTouchPlay {
+				// One Touch Play wakes the TV, the other devices still get
+				// a plain power on.
+				action.OneTouchPlay = true
+				action.Devices = withoutAddress(cfg.PowerDevices, cecAddressTV)
+			}
+			action.StreamPath = cfg.ResumeInput
+		}
+		return action
+	case PowerSleep, PowerShutdown:
+		if ev.Type == PowerShutdown && !ev.Active {
+			return powerAction{Skip: "shutdown cancelled"}
+		}
+		if ev.Type == PowerShutdown && cfg.StandbyAllOnShutdown {
+			return powerAction{StandbyAll: true}
+		}
+		return powerAction{Devices: cfg.PowerDevices}
+	}
+	if !standbyOnUnknownPowerEvent(cfg.UnknownPowerEvents, ev) {
+		return powerAction{Skip: "unknown-power-events " + cfg.UnknownPowerEvents, Unknown: true}
+	}
+	return powerAction{Devices: cfg.PowerDevices, Unknown: true}
+}
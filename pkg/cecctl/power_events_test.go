@@ -1,4 +1,4 @@
-package main
+package cecctl
 
 import (
 	"context"
@@ -298,3 +298,171 @@ func TestMockPowerEventListener_ContextCancellation(t *testing.T) {
 		// Expected - goroutine should have stopped
 	}
 }
+
+func TestPowerEventFromSignal(t *testing.T) {
+	sleep := &dbus.Signal{Name: "org.freedesktop.login1.Manager.PrepareForSleep", Body: []interface{}{true}}
+	resume := &dbus.Signal{Name: "org.freedesktop.login1.Manager.PrepareForSleep", Body: []interface{}{false}}
+	shutdown := &dbus.Signal{Name: "org.freedesktop.login1.Manager.PrepareForShutdown", Body: []interface{}{true}}
+
+	tests := []struct {
+		name    string
+		sig     *dbus.Signal
+		sources PowerEventSources
+		want    PowerEvent
+		wantOk  bool
+	}{
+		{"sleep", sleep, allPowerEventSources, PowerEvent{Type: PowerSleep, Active: true}, true},
+		{"resume", resume, allPowerEventSources, PowerEvent{Type: PowerResume}, true},
+		{"shutdown", shutdown, allPowerEventSources, PowerEvent{Type: PowerShutdown, Active: true}, true},
+		{"sleep disabled", sleep, PowerEventSources{Resume: true, Shutdown: true}, PowerEvent{}, false},
+		{"resume kept without sleep", resume, PowerEventSources{Resume: true}, PowerEvent{Type: PowerResume}, true},
+		{"resume disabled", resume, PowerEventSources{Sleep: true, Shutdown: true}, PowerEvent{}, false},
+		{"shutdown disabled", shutdown, PowerEventSources{Sleep: true, Resume: true}, PowerEvent{}, false},
+		{"nil signal", nil, allPowerEventSources, PowerEvent{}, false},
+		{"unknown signal", &dbus.Signal{Name: "org.freedesktop.login1.Manager.SessionNew", Body: []interface{}{true}}, allPowerEventSources, PowerEvent{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := powerEventFromSignal(tt.sig, tt.sources)
+			if ok != tt.wantOk || got != tt.want {
+				t.Errorf("powerEventFromSignal() = %+v, %v, want %+v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestStartupPowerEvent(t *testing.T) {
+	tests := []struct {
+		startupEvent string
+		want         PowerEvent
+		ok           bool
+	}{
+		{"", PowerEvent{Type: PowerOn, Active: true}, true},
+		{StartupEventOn, PowerEvent{Type: PowerOn, Active: true}, true},
+		{StartupEventResume, PowerEvent{Type: PowerResume, Active: true}, true},
+		{StartupEventNone, PowerEvent{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := startupPowerEvent(tt.startupEvent)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("startupPowerEvent(%q) = %+v, %v; expected %+v, %v", tt.startupEvent, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestStandbyOnUnknownPowerEvent(t *testing.T) {
+	starting := PowerEvent{Type: PowerEventType(42), Active: true}
+	ending := PowerEvent{Type: PowerEventType(42), Active: false}
+	if standbyOnUnknownPowerEvent(UnknownPowerEventsIgnore, starting) {
+		t.Error("Expected the ignore policy not to standby")
+	}
+	if !standbyOnUnknownPowerEvent(UnknownPowerEventsStandby, starting) {
+		t.Error("Expected the standby policy to standby on a starting event")
+	}
+	if standbyOnUnknownPowerEvent(UnknownPowerEventsStandby, ending) {
+		t.Error("Expected the standby policy to ignore an ending event")
+	}
+}
+
+func TestPowerEventListener_NoSources(t *testing.T) {
+	events := make(chan PowerEvent, 1)
+	if err := PowerEventListener(context.Background(), "unix:path=/nonexistent/cec-controller-test-bus", PowerEventSources{}, events); err != nil {
+		t.Errorf("Expected no bus connection with every source disabled, got %v", err)
+	}
+}
+
+func TestConnectSystemBus_InvalidAddress(t *testing.T) {
+	if _, err := connectSystemBus("unix:path=/nonexistent/cec-controller-test-bus"); err == nil {
+		t.Error("Expected error when connecting to an unreachable bus address")
+	}
+}
+
+func TestPowerEventListener_UnreachableBus(t *testing.T) {
+	events := make(chan PowerEvent, 1)
+	if err := PowerEventListener(context.Background(), "unix:path=/nonexistent/cec-controller-test-bus", allPowerEventSources, events); err == nil {
+		t.Error("Expected PowerEventListener to return an error for an unreachable bus")
+	}
+}
+
+func TestForwardPowerSignals_ConnectionLost(t *testing.T) {
+	signalCh := make(chan *dbus.Signal, 2)
+	events := make(chan PowerEvent, 1)
+	signalCh <- &dbus.Signal{Name: "org.freedesktop.login1.Manager.PrepareForSleep", Body: []interface{}{true}}
+	close(signalCh)
+	if !forwardPowerSignals(context.Background(), signalCh, allPowerEventSources, events) {
+		t.Error("Expected a closed signal channel reported as a lost connection")
+	}
+	if ev := <-events; ev.Type != PowerSleep {
+		t.Errorf("Expected the sleep event forwarded before the loss, got %+v", ev)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if forwardPowerSignals(ctx, make(chan *dbus.Signal), allPowerEventSources, events) {
+		t.Error("Expected a cancelled context not reported as a lost connection")
+	}
+}
+
+func TestRetryPowerEventListener_Backoff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	clock := newFakeClock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		retryPowerEventListener(ctx, "unix:path=/nonexistent/cec-controller-test-bus", allPowerEventSources, make(chan PowerEvent, 1), clock)
+	}()
+	nextAttempt := func() time.Duration {
+		clock.waitForWaiters(t, 1)
+		clock.mu.Lock()
+		defer clock.mu.Unlock()
+		return clock.waiters[0].at.Sub(clock.now)
+	}
+
+	// Each failed attempt doubles the delay before the next one, up to the
+	// maximum.
+	want := powerEventRetryMin
+	for range 7 {
+		if got := nextAttempt(); got != want {
+			t.Fatalf("Expected the next attempt in %s, got %s", want, got)
+		}
+		clock.Advance(want)
+		want = min(want*2, powerEventRetryMax)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected retries to stop once the context is done")
+	}
+}
+
+func TestPowerSequence(t *testing.T) {
+	var s powerSequence
+	// Even with a bus-wide standby on shutdown, cancelling one does nothing.
+	cfg := &Config{PowerDevices: []int{0}, StandbyAllOnShutdown: true}
+	steps := []struct {
+		ev   PowerEvent
+		want bool
+		skip bool
+	}{
+		{PowerEvent{Type: PowerResume}, true, false},
+		{PowerEvent{Type: PowerShutdown, Active: true}, true, false},
+		{PowerEvent{Type: PowerResume}, false, false},
+		{PowerEvent{Type: PowerOn, Active: true}, false, false},
+		{PowerEvent{Type: PowerSleep, Active: true}, true, false},
+		{PowerEvent{Type: PowerShutdown, Active: false}, true, true}, // cancelled
+		{PowerEvent{Type: PowerResume}, true, false},
+	}
+	for i, step := range steps {
+		if got := s.accept(step.ev); got != step.want {
+			t.Errorf("Step %d (%s): expected accept %v, got %v", i, powerEventLabel(step.ev), step.want, got)
+		}
+		if !step.want {
+			continue
+		}
+		if action := planPowerAction(cfg, step.ev, time.Time{}); (action.Skip != "") != step.skip {
+			t.Errorf("Step %d (%s): expected skip %v, got %+v", i, powerEventLabel(step.ev), step.skip, action)
+		}
+	}
+}
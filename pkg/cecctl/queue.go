@@ -0,0 +1,367 @@
+package cecctl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/beeker1121/goque"
+	"github.com/claes/cec"
+)
+
+// Queue buffers power and key events on disk between their sources and the
+// main loop, so the events received while the CEC adapter is being reopened,
+// or before a restart, are not lost. Events are sent on the In channels and
+// received, in order, on the Out channels.
+type Queue struct {
+	InPowerEvents chan PowerEvent
+	InKeyEvents   chan *cec.KeyPress
+
+	OutPowerEvents chan PowerEvent
+	OutKeyEvents   chan *cec.KeyPress
+
+	fsQueue          *goque.Queue
+	dir              string
+	cancel           context.CancelFunc
+	wg               sync.WaitGroup
+	cleanupOnce      sync.Once
+	notify           chan struct{} // closed/signalled by writer when an item is enqueued
+	clock            Clock
+	keepOnClose      bool // see SetKeepOnClose
+	discardOnRestart bool // see SetDiscardOnRestart
+}
+
+// staleKeyEventAge is how long a key press may wait in the queue. Older ones
+// are dropped rather than replayed late, e.g. after the main loop was stuck
+// in a reopen or the process restarted. Power events are never dropped.
+const staleKeyEventAge = 5 * time.Second
+
+type queueItem struct {
+	Type   string          `json:"type"`
+	Data   json.RawMessage `json:"data"`
+	Queued time.Time       `json:"queued,omitzero"`
+}
+
+// decode returns the event held by the item: a PowerEvent or a *cec.KeyPress.
+func (item queueItem) decode() (any, error) {
+	switch item.Type {
+	case "power":
+		var powerEvent PowerEvent
+		if err := json.Unmarshal(item.Data, &powerEvent); err != nil {
+			return nil, fmt.Errorf("parsing power event: %w", err)
+		}
+		return powerEvent, nil
+	case "key":
+		var keyEvent cec.KeyPress
+		if err := json.Unmarshal(item.Data, &keyEvent); err != nil {
+			return nil, fmt.Errorf("parsing key event: %w", err)
+		}
+		return &keyEvent, nil
+	default:
+		return nil, fmt.Errorf("unknown queue item type %q", item.Type)
+	}
+}
+
+// NewQueue opens the persistent queue in dir. With recoverCorrupt, a store
+// that cannot be opened is moved aside and replaced by an empty one instead of
+// failing, so a store damaged by a crash cannot keep the daemon from starting.
+func NewQueue(ctx context.Context, dir string, recoverCorrupt bool) (*Queue, error) {
+	return newQueueWithClock(ctx, dir, recoverCorrupt, realClock{})
+}
+
+func newQueueWithClock(ctx context.Context, dir string, recoverCorrupt bool, clock Clock) (*Queue, error) {
+	queue, err := openQueueStore(dir, recoverCorrupt)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	inPowerEvents := make(chan PowerEvent, 10)
+	inKeyEvents := make(chan *cec.KeyPress, 100)
+	// The Out channels are unbuffered: an event is only removed from disk
+	// once the main loop took it, so a restart never loses one and a key
+	// press waiting for a busy main loop is dropped once stale.
+	outPowerEvents := make(chan PowerEvent)
+	outKeyEvents := make(chan *cec.KeyPress)
+
+	q := &Queue{
+		InPowerEvents:  inPowerEvents,
+		InKeyEvents:    inKeyEvents,
+		OutPowerEvents: outPowerEvents,
+		OutKeyEvents:   outKeyEvents,
+		fsQueue:        queue,
+		dir:            dir,
+		cancel:         cancel,
+		notify:         make(chan struct{}, 1),
+		clock:          clock,
+	}
+
+	// signal wakes the reader goroutine after an item is written to disk.
+	// The channel is buffered(1): if a signal is already pending the send is
+	// dropped, which is fine — the reader will drain all available items.
+	signal := func() {
+		select {
+		case q.notify <- struct{}{}:
+		default:
+		}
+	}
+
+	// enqueue persists one event to disk.
+	enqueue := func(kind string, event any) {
+		data, err := json.Marshal(event)
+		if err != nil {
+			slog.Error("Error marshaling "+kind+" event", "error", err)
+			return
+		}
+		if _, err := queue.EnqueueObjectAsJSON(queueItem{Type: kind, Data: data, Queued: clock.Now()}); err != nil {
+			slog.Error("Error enqueuing "+kind+" event", "error", err)
+			return
+		}
+		signal()
+	}
+
+	// Writer goroutine: drains InPowerEvents and InKeyEvents to disk.
+	// Blocking select ensures no busy-wait when idle. On cancellation the
+	// events still buffered are persisted before returning, so a standby sent
+	// just before a restart is replayed by the next process.
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				for {
+					select {
+					case pe := <-inPowerEvents:
+						enqueue("power", pe)
+					case ke := <-inKeyEvents:
+						enqueue("key", ke)
+					default:
+						return
+					}
+				}
+			case pe := <-inPowerEvents:
+				enqueue("power", pe)
+			case ke := <-inKeyEvents:
+				enqueue("key", ke)
+			}
+		}
+	}()
+
+	// Reader goroutine: peeks items from disk and sends them to out channels.
+	// An item is only removed from disk once the main loop received it (or
+	// it was dropped), so an event interrupted by shutdown is replayed by the
+	// next process.
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+
+			item, err := queue.Peek()
+			if errors.Is(err, goque.ErrEmpty) {
+				select {
+				case <-ctx.Done():
+					return
+				case <-q.notify:
+				}
+				continue
+			}
+			if err != nil {
+				slog.Error("Error peeking item", "error", err)
+				q.discardHead()
+				continue
+			}
+
+			var qItem queueItem
+			if err := json.Unmarshal(item.Value, &qItem); err != nil {
+				slog.Error("Error parsing queued item", "error", err)
+				q.discardHead()
+				continue
+			}
+
+			event, err := qItem.decode()
+			if err != nil {
+				slog.Error("Error decoding queued item", "error", err)
+			}
+			switch event := event.(type) {
+			case PowerEvent:
+				select {
+				case outPowerEvents <- event:
+				case <-ctx.Done():
+					return
+				}
+			case *cec.KeyPress:
+				if !sendKeyEvent(ctx, clock, outKeyEvents, event, qItem.Queued) {
+					return
+				}
+			}
+			q.discardHead()
+		}
+	}()
+
+	return q, nil
+}
+
+// discardHead removes the item at the head of the store, once it has been
+// delivered or cannot be.
+func (q *Queue) discardHead() {
+	if _, err := q.fsQueue.Dequeue(); err != nil && !errors.Is(err, goque.ErrEmpty) {
+		slog.Error("Error dequeuing item", "error", err)
+	}
+}
+
+// sendKeyEvent delivers a key press queued at the given time, giving up once
+// it is older than staleKeyEventAge so a stuck consumer gets fresh keys when
+// it recovers. It returns false if ctx is done before the key was handled.
+func sendKeyEvent(ctx context.Context, clock Clock, out chan<- *cec.KeyPress, keyEvent *cec.KeyPress, queued time.Time) bool {
+	var stale <-chan time.Time
+	if !queued.IsZero() {
+		age := clock.Now().Sub(queued)
+		if age >= staleKeyEventAge {
+			slog.Warn("Dropping stale key event", "cec-key-code", keyEvent.KeyCode, "age", age.Round(time.Millisecond))
+			return true
+		}
+		stale = clock.After(staleKeyEventAge - age)
+	}
+
+	select {
+	case out <- keyEvent:
+	case <-stale:
+		slog.Warn("Dropping stale key event, consumer is not keeping up", "cec-key-code", keyEvent.KeyCode)
+	case <-ctx.Done():
+		return false
+	}
+	return true
+}
+
+func openQueueStore(dir string, recoverCorrupt bool) (*goque.Queue, error) {
+	queue, err := goque.OpenQueue(dir)
+	if err == nil {
+		return queue, nil
+	}
+	if !recoverCorrupt {
+		return nil, fmt.Errorf("failed to open queue store (use --recover-queue to start with a fresh one): %w", err)
+	}
+
+	aside := fmt.Sprintf("%s.corrupt-%d", dir, time.Now().Unix())
+	slog.Error("Queue store cannot be opened, moving it aside and starting with an empty queue", "dir", dir, "moved-to", aside, "error", err)
+	if err := os.Rename(dir, aside); err != nil {
+		return nil, fmt.Errorf("failed to move corrupt queue store aside: %w", err)
+	}
+	return goque.OpenQueue(dir)
+}
+
+// RestartProcess sometimes the cec library gets stuck and stops receiving events.
+// This function restarts the entire process making sure the queue is preserved between processes.
+// The reason is recorded in the queue directory for the restarted process to log.
+// Returns true if restart was attempted, false if no retries left.
+func (q *Queue) RestartProcess(retriesLeft int, reason string) bool {
+	if retriesLeft <= 0 {
+		slog.Error("No process restarts remaining, cannot restart")
+		return false
+	}
+
+	execPath, err := os.Executable()
+	if err != nil {
+		slog.Error("Failed to get executable path, cannot restart", "error", err)
+		return false
+	}
+
+	slog.Warn("Restarting process", "reason", reason, "retriesLeft", retriesLeft-1)
+	q.cleanup()
+	if q.discardOnRestart {
+		slog.Warn("Discarding pending events before restarting", "dir", q.dir)
+		if err := discardQueueStore(q.dir); err != nil {
+			slog.Error("Failed to discard queue store", "dir", q.dir, "error", err)
+		}
+	}
+	if err := recordRestart(q.dir, restartRecord{Reason: reason, At: q.clock.Now(), RetriesLeft: retriesLeft - 1}); err != nil {
+		slog.Warn("Failed to record restart reason", "error", err)
+	}
+
+	// Pass the decremented retry count via environment variable
+	env := os.Environ()
+	env = append(env, queueDirEnvVar+"="+q.dir)
+	env = append(env, restartRetriesEnvVar+"="+fmt.Sprintf("%d", retriesLeft-1))
+
+	if err := syscall.Exec(execPath, os.Args, env); err != nil {
+		slog.Error("Failed to restart", "error", err)
+		return false
+	}
+	// syscall.Exec only returns on failure - success replaces the current process
+	return true
+}
+
+// Depth returns the number of events persisted on disk and not yet dispatched.
+func (q *Queue) Depth() uint64 {
+	return q.fsQueue.Length()
+}
+
+// SetKeepOnClose makes Close leave the queue directory in place, so a process
+// started on the same directory resumes the pending events as after a
+// restart.
+func (q *Queue) SetKeepOnClose(keep bool) {
+	q.keepOnClose = keep
+}
+
+// SetDiscardOnRestart makes RestartProcess delete the pending events instead
+// of handing them to the restarted process, e.g. when a damaged store is
+// suspected. The restart log is kept.
+func (q *Queue) SetDiscardOnRestart(discard bool) {
+	q.discardOnRestart = discard
+}
+
+// discardQueueStore removes the queue store in dir, keeping the restart log
+// written next to it.
+func discardQueueStore(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, entry := range entries {
+		if entry.Name() == restartLogFile {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close stops the queue and removes its directory, unless SetKeepOnClose was
+// used.
+func (q *Queue) Close() {
+	q.cleanup()
+	if q.keepOnClose {
+		slog.Debug("Keeping queue directory", "dir", q.dir)
+		return
+	}
+	if err := os.RemoveAll(q.dir); err != nil {
+		slog.Error("Failed to remove queue directory", "dir", q.dir, "error", err)
+	}
+}
+
+// cleanup cancels the internal context, waits for goroutines to exit, and
+// closes the underlying store. Safe to call multiple times.
+func (q *Queue) cleanup() {
+	q.cleanupOnce.Do(func() {
+		q.cancel()
+		q.wg.Wait()
+		q.fsQueue.Close()
+	})
+}
@@ -0,0 +1,565 @@
+package cecctl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/beeker1121/goque"
+	"github.com/claes/cec"
+)
+
+func TestPowerEventChannel(t *testing.T) {
+	ch := make(chan PowerEvent, 10)
+
+	testEvent := PowerEvent{Type: PowerSleep, Active: true}
+	ch <- testEvent
+
+	receivedEvent := <-ch
+	if receivedEvent.Type != testEvent.Type {
+		t.Errorf("Expected event type %d, got %d", testEvent.Type, receivedEvent.Type)
+	}
+	if receivedEvent.Active != testEvent.Active {
+		t.Errorf("Expected Active to be %v, got %v", testEvent.Active, receivedEvent.Active)
+	}
+}
+
+func TestMultiplePowerEvents(t *testing.T) {
+	ch := make(chan PowerEvent, 10)
+
+	events := []PowerEvent{
+		{Type: PowerOn, Active: true},
+		{Type: PowerSleep, Active: true},
+		{Type: PowerResume, Active: false},
+		{Type: PowerShutdown, Active: true},
+	}
+
+	for _, event := range events {
+		ch <- event
+	}
+
+	for i, expected := range events {
+		received := <-ch
+		if received.Type != expected.Type {
+			t.Errorf("Event %d: expected type %d, got %d", i, expected.Type, received.Type)
+		}
+		if received.Active != expected.Active {
+			t.Errorf("Event %d: expected Active %v, got %v", i, expected.Active, received.Active)
+		}
+	}
+}
+
+func TestQueueItemSerialization(t *testing.T) {
+	item := queueItem{
+		Type: "power",
+		Data: []byte(`{"Type":1,"Active":true}`),
+	}
+	if item.Type != "power" {
+		t.Errorf("Expected type 'power', got '%s'", item.Type)
+	}
+	if item.Data == nil {
+		t.Error("Expected Data to be non-nil")
+	}
+}
+
+func TestChannelBuffering(t *testing.T) {
+	powerCh := make(chan PowerEvent, 10)
+
+	for i := 0; i < 5; i++ {
+		powerCh <- PowerEvent{Type: PowerOn, Active: true}
+	}
+
+	select {
+	case powerCh <- PowerEvent{Type: PowerSleep, Active: true}:
+	default:
+		t.Error("Channel should not be full after 6 events")
+	}
+
+	count := 0
+	for len(powerCh) > 0 {
+		<-powerCh
+		count++
+	}
+	if count != 6 {
+		t.Errorf("Expected to drain 6 events, got %d", count)
+	}
+}
+
+func TestContextCancellationPattern(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan bool)
+	go func() {
+		<-ctx.Done()
+		done <- true
+	}()
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(100 * time.Millisecond):
+		t.Error("Goroutine did not respond to context cancellation")
+	}
+}
+
+func TestTemporaryDirectory(t *testing.T) {
+	tempDir := filepath.Join(os.TempDir(), "queue-test-temp")
+
+	err := os.MkdirAll(tempDir, 0755)
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+
+	if _, err := os.Stat(tempDir); os.IsNotExist(err) {
+		t.Error("Expected directory to exist")
+	}
+
+	err = os.RemoveAll(tempDir)
+	if err != nil {
+		t.Errorf("Failed to remove temp directory: %v", err)
+	}
+
+	if _, err := os.Stat(tempDir); !os.IsNotExist(err) {
+		t.Error("Expected directory to be removed")
+	}
+}
+
+func TestRestartProcessRetryLogic(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+
+	queue, err := NewQueue(ctx, tempDir, false)
+	if err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+	defer queue.Close()
+
+	if result := queue.RestartProcess(0, "test"); result {
+		t.Error("Expected RestartProcess to return false when retriesLeft is 0")
+	}
+	if result := queue.RestartProcess(-1, "test"); result {
+		t.Error("Expected RestartProcess to return false when retriesLeft is negative")
+	}
+}
+
+func TestRestartProcessPositiveRetries(t *testing.T) {
+	if os.Getenv("CI") != "" {
+		t.Skip("Skipping test that would restart process in CI environment")
+	}
+}
+
+func TestRestartProcessRetryDecrement(t *testing.T) {
+	testCases := []struct {
+		retriesLeft      int
+		shouldAttempt    bool
+		expectedNewValue int
+	}{
+		{0, false, 0},
+		{1, true, 0},
+		{5, true, 4},
+		{10, true, 9},
+		{-1, false, 0},
+	}
+
+	for _, tc := range testCases {
+		if tc.retriesLeft <= 0 {
+			if tc.shouldAttempt {
+				t.Errorf("retriesLeft=%d: expected shouldAttempt=false", tc.retriesLeft)
+			}
+		} else {
+			if !tc.shouldAttempt {
+				t.Errorf("retriesLeft=%d: expected shouldAttempt=true", tc.retriesLeft)
+			}
+			newValue := tc.retriesLeft - 1
+			if newValue != tc.expectedNewValue {
+				t.Errorf("retriesLeft=%d: expected new value %d, got %d",
+					tc.retriesLeft, tc.expectedNewValue, newValue)
+			}
+		}
+	}
+}
+
+// TestQueueEventRouting verifies that events sent to InPowerEvents and
+// InKeyEvents arrive on OutPowerEvents and OutKeyEvents respectively.
+func TestQueueEventRouting(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	q, err := NewQueue(ctx, t.TempDir(), false)
+	if err != nil {
+		t.Fatalf("NewQueue failed: %v", err)
+	}
+	defer q.Close()
+
+	// Power event round-trip
+	q.InPowerEvents <- PowerEvent{Type: PowerSleep, Active: true}
+	select {
+	case ev := <-q.OutPowerEvents:
+		if ev.Type != PowerSleep || !ev.Active {
+			t.Errorf("Unexpected power event: %+v", ev)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("Timeout waiting for power event")
+	}
+}
+
+// TestQueuePreservesOrder verifies that multiple events arrive in FIFO order.
+func TestQueuePreservesOrder(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	q, err := NewQueue(ctx, t.TempDir(), false)
+	if err != nil {
+		t.Fatalf("NewQueue failed: %v", err)
+	}
+	defer q.Close()
+
+	events := []PowerEvent{
+		{Type: PowerOn, Active: true},
+		{Type: PowerSleep, Active: true},
+		{Type: PowerResume, Active: false},
+	}
+
+	for _, ev := range events {
+		q.InPowerEvents <- ev
+	}
+
+	for i, expected := range events {
+		select {
+		case got := <-q.OutPowerEvents:
+			if got.Type != expected.Type || got.Active != expected.Active {
+				t.Errorf("Event %d: expected %+v, got %+v", i, expected, got)
+			}
+		case <-time.After(500 * time.Millisecond):
+			t.Fatalf("Timeout waiting for event %d", i)
+		}
+	}
+}
+
+// corruptQueueStore leaves a store in dir that goque cannot open.
+func corruptQueueStore(t *testing.T, dir string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("Failed to create queue dir: %v", err)
+	}
+	files := map[string]string{
+		"CURRENT":         "MANIFEST-000001\n",
+		"MANIFEST-000001": "not a leveldb manifest",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to corrupt queue store: %v", err)
+		}
+	}
+}
+
+func TestNewQueue_CorruptStoreFails(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "queue")
+	corruptQueueStore(t, dir)
+
+	if _, err := NewQueue(context.Background(), dir, false); err == nil {
+		t.Fatal("Expected NewQueue to fail on a corrupt store without recovery")
+	}
+}
+
+func TestNewQueue_RecoversCorruptStore(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "queue")
+	corruptQueueStore(t, dir)
+
+	q, err := NewQueue(context.Background(), dir, true)
+	if err != nil {
+		t.Fatalf("Expected NewQueue to recover, got %v", err)
+	}
+	defer q.Close()
+
+	moved, _ := filepath.Glob(filepath.Join(parent, "queue.corrupt-*"))
+	if len(moved) != 1 {
+		t.Fatalf("Expected the corrupt store to be moved aside, found %v", moved)
+	}
+	if _, err := os.Stat(filepath.Join(moved[0], "CURRENT")); err != nil {
+		t.Errorf("Expected the moved store to keep its files: %v", err)
+	}
+
+	q.InPowerEvents <- PowerEvent{Type: PowerOn, Active: true}
+	select {
+	case <-q.OutPowerEvents:
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for an event through the recovered queue")
+	}
+}
+
+func TestQueue_DropsStaleKeyEvents(t *testing.T) {
+	dir := t.TempDir()
+	store, err := goque.OpenQueue(dir)
+	if err != nil {
+		t.Fatalf("OpenQueue failed: %v", err)
+	}
+	old := time.Now().Add(-time.Minute)
+	items := []queueItem{
+		{Type: "key", Data: json.RawMessage(`{"KeyCode":1}`), Queued: old},
+		{Type: "power", Data: json.RawMessage(`{"Type":0,"Active":true}`), Queued: old},
+		{Type: "key", Data: json.RawMessage(`{"KeyCode":2}`), Queued: time.Now()},
+	}
+	for _, item := range items {
+		if _, err := store.EnqueueObjectAsJSON(item); err != nil {
+			t.Fatalf("Enqueue failed: %v", err)
+		}
+	}
+	store.Close()
+
+	q, err := NewQueue(context.Background(), dir, false)
+	if err != nil {
+		t.Fatalf("NewQueue failed: %v", err)
+	}
+	defer q.Close()
+
+	// Power events are replayed however old they are.
+	select {
+	case <-q.OutPowerEvents:
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for the old power event")
+	}
+	select {
+	case kp := <-q.OutKeyEvents:
+		if kp.KeyCode != 2 {
+			t.Errorf("Expected only the fresh key event, got key code %d", kp.KeyCode)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for the fresh key event")
+	}
+}
+
+func TestQueue_DropsKeysWaitingForBusyConsumer(t *testing.T) {
+	// Keys pressed while the main loop is stuck, e.g. in a reopen.
+	clock := newFakeClock()
+	dir := t.TempDir()
+	store, err := goque.OpenQueue(dir)
+	if err != nil {
+		t.Fatalf("OpenQueue failed: %v", err)
+	}
+	for code := 1; code <= 3; code++ {
+		item := queueItem{Type: "key", Data: json.RawMessage(fmt.Sprintf(`{"KeyCode":%d}`, code)), Queued: clock.Now()}
+		if _, err := store.EnqueueObjectAsJSON(item); err != nil {
+			t.Fatalf("Enqueue failed: %v", err)
+		}
+	}
+	store.Close()
+
+	q, err := newQueueWithClock(context.Background(), dir, false, clock)
+	if err != nil {
+		t.Fatalf("newQueueWithClock failed: %v", err)
+	}
+	defer q.Close()
+	clock.waitForWaiters(t, 1)
+	clock.Advance(staleKeyEventAge)
+
+	// Once it recovers, it gets the next key rather than the stale ones.
+	q.InKeyEvents <- &cec.KeyPress{KeyCode: 4}
+	select {
+	case kp := <-q.OutKeyEvents:
+		if kp.KeyCode != 4 {
+			t.Errorf("Expected the stale keys dropped, got key code %d", kp.KeyCode)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for the fresh key event")
+	}
+}
+
+func TestQueue_CloseRemovesDir(t *testing.T) {
+	for _, keep := range []bool{false, true} {
+		dir := filepath.Join(t.TempDir(), "queue")
+		q, err := NewQueue(context.Background(), dir, false)
+		if err != nil {
+			t.Fatalf("NewQueue failed: %v", err)
+		}
+		q.SetKeepOnClose(keep)
+		q.Close()
+
+		_, err = os.Stat(dir)
+		if keep && err != nil {
+			t.Errorf("Expected the queue directory to be kept, got %v", err)
+		}
+		if !keep && !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Expected the queue directory to be removed, got %v", err)
+		}
+	}
+}
+
+func TestDiscardQueueStore(t *testing.T) {
+	dir := t.TempDir()
+	q, err := NewQueue(context.Background(), dir, false)
+	if err != nil {
+		t.Fatalf("NewQueue failed: %v", err)
+	}
+	q.InPowerEvents <- PowerEvent{Type: PowerSleep, Active: true}
+	q.cleanup()
+	if err := recordRestart(dir, restartRecord{Reason: "test", At: time.Now()}); err != nil {
+		t.Fatalf("recordRestart failed: %v", err)
+	}
+
+	if err := discardQueueStore(dir); err != nil {
+		t.Fatalf("discardQueueStore failed: %v", err)
+	}
+	if records, err := loadRestarts(dir, time.Now()); err != nil || len(records) != 1 {
+		t.Errorf("Expected the restart log to be kept, got %v, %v", records, err)
+	}
+	q, err = NewQueue(context.Background(), dir, false)
+	if err != nil {
+		t.Fatalf("NewQueue failed: %v", err)
+	}
+	defer q.Close()
+	if q.Depth() != 0 {
+		t.Errorf("Expected an empty queue, got %d events", q.Depth())
+	}
+}
+
+func TestQueue_KeepsUndeliveredEventOnShutdown(t *testing.T) {
+	dir := t.TempDir()
+	q, err := NewQueue(context.Background(), dir, false)
+	if err != nil {
+		t.Fatalf("NewQueue failed: %v", err)
+	}
+
+	// Nobody reads: every event stays on disk while the reader waits to
+	// deliver the first one.
+	const sent = 12
+	for i := 0; i < sent; i++ {
+		q.InPowerEvents <- PowerEvent{Type: PowerOn, Active: true}
+	}
+	// The writer persists the events still buffered when it stops.
+	q.cleanup()
+
+	q, err = NewQueue(context.Background(), dir, false)
+	if err != nil {
+		t.Fatalf("NewQueue failed: %v", err)
+	}
+	defer q.Close()
+	for i := 0; i < sent; i++ {
+		select {
+		case <-q.OutPowerEvents:
+		case <-time.After(time.Second):
+			t.Fatalf("Expected every undelivered event to be replayed after a restart, got %d of %d", i, sent)
+		}
+	}
+	select {
+	case <-q.OutPowerEvents:
+		t.Error("Expected no more events than were sent")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestQueue_PersistsBufferedEventsOnShutdown(t *testing.T) {
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	q, err := NewQueue(ctx, dir, false)
+	if err != nil {
+		t.Fatalf("NewQueue failed: %v", err)
+	}
+
+	// An event sent right before the cancellation may still sit in the In
+	// channel when the writer notices it. It must be delivered or persisted,
+	// never lost.
+	q.InPowerEvents <- PowerEvent{Type: PowerSleep, Active: true}
+	cancel()
+	q.cleanup()
+
+	q, err = NewQueue(context.Background(), dir, false)
+	if err != nil {
+		t.Fatalf("NewQueue failed: %v", err)
+	}
+	defer q.Close()
+	select {
+	case ev := <-q.OutPowerEvents:
+		if ev.Type != PowerSleep {
+			t.Errorf("Expected the standby to be replayed, got %+v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the buffered event to be persisted and replayed after a restart")
+	}
+}
+
+func TestQueue_RepeatedCloseUnderLoad(t *testing.T) {
+	dir := t.TempDir()
+	sent, delivered := 0, 0
+	for i := 0; i < 20; i++ {
+		q, err := NewQueue(context.Background(), dir, false)
+		if err != nil {
+			t.Fatalf("NewQueue failed: %v", err)
+		}
+
+		// The reader is stopped while events are still being written and
+		// delivered.
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for j := 0; j < 50; j++ {
+				q.InPowerEvents <- PowerEvent{Type: PowerOn, Active: true}
+				sent++
+			}
+		}()
+		for j := 0; j < 10; j++ {
+			<-q.OutPowerEvents
+			delivered++
+		}
+		<-done
+		// Events not received yet stay on disk for the next run.
+		q.cleanup()
+	}
+
+	q, err := NewQueue(context.Background(), dir, false)
+	if err != nil {
+		t.Fatalf("NewQueue failed: %v", err)
+	}
+	defer q.Close()
+	for delivered < sent {
+		select {
+		case <-q.OutPowerEvents:
+			delivered++
+		case <-time.After(time.Second):
+			t.Fatalf("Expected every event to be delivered once, got %d of %d", delivered, sent)
+		}
+	}
+	select {
+	case <-q.OutPowerEvents:
+		t.Errorf("Expected every event to be delivered once, got more than %d", sent)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func BenchmarkQueueRoundtrip(b *testing.B) {
+	q, err := NewQueue(context.Background(), b.TempDir(), false)
+	if err != nil {
+		b.Fatalf("NewQueue failed: %v", err)
+	}
+	defer q.Close()
+	kp := &cec.KeyPress{KeyCode: 0x00}
+	b.ReportAllocs()
+	for b.Loop() {
+		q.InKeyEvents <- kp
+		<-q.OutKeyEvents
+	}
+}
+
+func BenchmarkQueueItemEncoding(b *testing.B) {
+	kp := &cec.KeyPress{KeyCode: 0x00}
+	b.ReportAllocs()
+	for b.Loop() {
+		data, _ := json.Marshal(kp)
+		item, _ := json.Marshal(queueItem{Type: "key", Data: data, Queued: time.Now()})
+		var qItem queueItem
+		if err := json.Unmarshal(item, &qItem); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := qItem.decode(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
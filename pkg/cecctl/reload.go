@@ -0,0 +1,201 @@
+package cecctl
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// configWatchDebounce is how long the configuration file must stay unchanged
+// before a change is applied: editors often write a file twice, or truncate
+// it before writing the new content.
+const configWatchDebounce = 500 * time.Millisecond
+
+// reloadableKeys are the configuration keys applied without a restart, on
+// SIGHUP or, with --watch-config, when the file changes.
+var reloadableKeys = []string{
+	"device-name", "debug", "log-level",
+	"keymap", "keymap-profiles", "keymap-profile", "key-actions", "layer-shift", "ignore-keys", "allow-keys",
+	"unmapped-warn-interval", "double-press-window", "scroll-amount", "number-mode", "tv-speakers", "volume-accel",
+}
+
+// reloadConfig reads the configuration again and applies its reloadable
+// settings to cfg, c, keyMapObj and logLevel. An invalid configuration, e.g.
+// a file caught halfway through a write, is ignored as a whole so the running
+// settings stay consistent.
+func reloadConfig(cfg *Config, c *CEC, keyMapObj *KeyMap, logLevel *slog.LevelVar) {
+	reloaded, err := loadConfig()
+	if err != nil {
+		slog.Error("Failed to reload configuration", "error", err)
+		return
+	}
+	// loadConfig makes a new temporary queue directory when none is
+	// configured; the running one is kept.
+	discardTempQueueDir(reloaded)
+	if reloaded.QueueDir == "" {
+		reloaded.QueueDir = cfg.QueueDir
+	}
+	if err := validateConfig(reloaded); err != nil {
+		slog.Error("Invalid configuration, keeping the current one", "error", err)
+		return
+	}
+	applyConfig(cfg, reloaded, c, keyMapObj, logLevel)
+}
+
+// applyConfig applies the reloadable settings of reloaded and copies them to
+// cfg. Other changed settings are only reported: they apply on restart.
+func applyConfig(cfg, reloaded *Config, c *CEC, keyMapObj *KeyMap, logLevel *slog.LevelVar) {
+	if reloaded.DeviceName != cfg.DeviceName {
+		if err := c.SetOSDName(reloaded.DeviceName); err != nil {
+			slog.Error("Failed to update OSD name", "name", reloaded.DeviceName, "error", err)
+			reloaded.DeviceName = cfg.DeviceName
+		}
+	}
+
+	if reloaded.LogLevel != cfg.LogLevel {
+		lvl, _ := parseLogLevel(reloaded.LogLevel) // already checked by validateConfig
+		logLevel.Set(lvl)
+		slog.Info("Log level changed", "log-level", reloaded.LogLevel)
+	}
+
+	if !reflect.DeepEqual(reloaded.KeyMapOverrides, cfg.KeyMapOverrides) || !reflect.DeepEqual(reloaded.KeyMapProfiles, cfg.KeyMapProfiles) {
+		keyMapObj.ReplaceKeyMap(reloaded.KeyMapOverrides, reloaded.KeyMapProfiles)
+	}
+	if reloaded.KeyMapProfile != cfg.KeyMapProfile {
+		profile := reloaded.KeyMapProfile
+		if profile == "" {
+			profile = defaultProfile
+		}
+		if err := keyMapObj.SetProfile(profile); err != nil {
+			slog.Error("Failed to activate keymap profile", "error", err)
+		}
+	}
+	keyMapObj.SetActions(reloaded.KeyActions)
+	keyMapObj.SetShiftLayer(reloaded.LayerShift)
+	keyMapObj.SetIgnoredKeys(reloaded.IgnoreKeys)
+	keyMapObj.SetAllowedKeys(reloaded.AllowKeys)
+	keyMapObj.SetUnmappedWarnInterval(reloaded.UnmappedWarnInterval)
+	keyMapObj.SetDoublePressWindow(reloaded.DoublePressWindow)
+	keyMapObj.SetScrollAmount(reloaded.ScrollAmount)
+	keyMapObj.SetNumberMode(reloaded.NumberMode)
+	keyMapObj.SetVolumeAccel(reloaded.VolumeAccel)
+	if reloaded.TVSpeakers != cfg.TVSpeakers {
+		if reloaded.TVSpeakers {
+			keyMapObj.SetVolumeController(c)
+		} else {
+			keyMapObj.SetVolumeController(nil)
+		}
+	}
+
+	if changed := changedConfigKeys(cfg, reloaded); len(changed) > 0 {
+		slog.Warn("Configuration changes that only apply on restart were ignored", "keys", strings.Join(changed, ","))
+	}
+	reloadable := *cfg
+	copyConfigKeys(&reloadable, reloaded, reloadableKeys)
+	*cfg = reloadable
+	slog.Info("Configuration reloaded", "applied", strings.Join(reloadableKeys, ","))
+}
+
+// changedConfigKeys returns the keys, other than reloadableKeys, whose value
+// differs between the two configurations.
+func changedConfigKeys(before, after *Config) []string {
+	var changed []string
+	b, a := reflect.ValueOf(before).Elem(), reflect.ValueOf(after).Elem()
+	for i := 0; i < b.NumField(); i++ {
+		key := configKey(b.Type().Field(i))
+		if key == "" || slices.Contains(reloadableKeys, key) {
+			continue
+		}
+		if !reflect.DeepEqual(b.Field(i).Interface(), a.Field(i).Interface()) {
+			changed = append(changed, key)
+		}
+	}
+	return changed
+}
+
+// copyConfigKeys copies the fields of the given configuration keys from src
+// to dst.
+func copyConfigKeys(dst, src *Config, keys []string) {
+	d, s := reflect.ValueOf(dst).Elem(), reflect.ValueOf(src).Elem()
+	for i := 0; i < d.NumField(); i++ {
+		if slices.Contains(keys, configKey(d.Type().Field(i))) {
+			d.Field(i).Set(s.Field(i))
+		}
+	}
+}
+
+// configKey returns the configuration key of a Config field, from its json
+// tag, or "" for fields without one.
+func configKey(field reflect.StructField) string {
+	key, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if key == "-" {
+		return ""
+	}
+	return key
+}
+
+// watchConfigFile sends on changed once path has been written, created or
+// replaced and then left alone for debounce. The directory is watched rather
+// than the file so that editors replacing the file are noticed too.
+func watchConfigFile(ctx context.Context, path string, debounce time.Duration, clock Clock, changed chan<- struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create config file watcher: %w", err)
+	}
+	path = filepath.Clean(path)
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to watch config file directory: %w", err)
+	}
+
+	events := make(chan struct{}, 1)
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev := <-watcher.Events:
+				if filepath.Clean(ev.Name) != path || !ev.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) {
+					continue
+				}
+				select {
+				case events <- struct{}{}:
+				default:
+				}
+			case err := <-watcher.Errors:
+				slog.Warn("Config file watcher error", "error", err)
+			}
+		}
+	}()
+	go debounceEvents(ctx, events, changed, debounce, clock)
+	return nil
+}
+
+// debounceEvents sends on out once no event has been received on in for
+// delay, so a burst of events results in a single send.
+func debounceEvents(ctx context.Context, in <-chan struct{}, out chan<- struct{}, delay time.Duration, clock Clock) {
+	var fire <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-in:
+			fire = clock.After(delay)
+		case <-fire:
+			fire = nil
+			select {
+			case out <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
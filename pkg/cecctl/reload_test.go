@@ -0,0 +1,141 @@
+package cecctl
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/claes/cec"
+)
+
+func TestDebounceEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := newFakeClock()
+	in := make(chan struct{})
+	out := make(chan struct{}, 1)
+	go debounceEvents(ctx, in, out, 500*time.Millisecond, clock)
+
+	// An editor writing twice: the second write restarts the delay.
+	in <- struct{}{}
+	clock.waitForWaiters(t, 1)
+	clock.Advance(300 * time.Millisecond)
+	in <- struct{}{}
+	clock.waitForWaiters(t, 2)
+	clock.Advance(300 * time.Millisecond)
+	select {
+	case <-out:
+		t.Fatal("Expected no reload before the file settled")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	clock.Advance(200 * time.Millisecond)
+	select {
+	case <-out:
+	case <-time.After(time.Second):
+		t.Fatal("Expected a single reload once the file settled")
+	}
+	select {
+	case <-out:
+		t.Fatal("Expected only one reload for both writes")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestWatchConfigFile(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cec-controller.yaml")
+	changed := make(chan struct{}, 1)
+	if err := watchConfigFile(ctx, path, 10*time.Millisecond, realClock{}, changed); err != nil {
+		t.Fatalf("watchConfigFile failed: %v", err)
+	}
+
+	// Other files in the directory are ignored.
+	if err := os.WriteFile(filepath.Join(dir, "other.yaml"), []byte("x: 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changed:
+		t.Fatal("Expected no reload for another file")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := os.WriteFile(path, []byte("log-level: debug\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected a reload after writing the config file")
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	mock := &MockKeyboardEmitter{}
+	km, err := newKeyMapWithEmitter(nil, mock, nil)
+	if err != nil {
+		t.Fatalf("newKeyMapWithEmitter failed: %v", err)
+	}
+	defer km.Close()
+	conn := &MockCECConnection{}
+	c := newTestCEC(conn, nil)
+	logLevel := new(slog.LevelVar)
+
+	cfg := &Config{DeviceName: "HTPC", LogLevel: "info", CECAdapter: "/dev/ttyACM0"}
+	reloaded := &Config{
+		DeviceName:      "Den",
+		LogLevel:        "debug",
+		CECAdapter:      "/dev/ttyACM1",
+		KeyMapOverrides: map[string][]int{"Select": {57}},
+		KeyMapProfiles:  map[string]map[string][]int{"kodi": {"Exit": {14}}},
+		KeyMapProfile:   "kodi",
+		TVSpeakers:      true,
+	}
+	applyConfig(cfg, reloaded, c, km, logLevel)
+
+	if logLevel.Level() != slog.LevelDebug {
+		t.Errorf("Expected the debug log level, got %v", logLevel.Level())
+	}
+	if len(conn.Transmitted) != 1 {
+		t.Errorf("Expected the new OSD name sent, got %v", conn.Transmitted)
+	}
+	if km.Profile() != "kodi" {
+		t.Errorf("Expected the kodi profile active, got %q", km.Profile())
+	}
+	if got, _ := km.Lookup(cec.GetKeyCodeByName("Select")); len(got) != 1 || got[0] != 57 {
+		t.Errorf("Expected Select mapped to 57 in the profile, got %v", got)
+	}
+	if got, _ := km.Lookup(cec.GetKeyCodeByName("Exit")); len(got) != 1 || got[0] != 14 {
+		t.Errorf("Expected Exit mapped to 14 in the profile, got %v", got)
+	}
+
+	// Volume keys now go to the TV instead of the keyboard.
+	km.mu.RLock()
+	volume := km.volume
+	km.mu.RUnlock()
+	if volume != VolumeController(c) {
+		t.Errorf("Expected volume keys sent over CEC, got %v", volume)
+	}
+
+	if cfg.DeviceName != "Den" || cfg.LogLevel != "debug" || !cfg.TVSpeakers {
+		t.Errorf("Expected the reloadable settings copied, got %+v", cfg)
+	}
+	// The adapter cannot change without a restart.
+	if cfg.CECAdapter != "/dev/ttyACM0" {
+		t.Errorf("Expected cec-adapter unchanged, got %q", cfg.CECAdapter)
+	}
+}
+
+func TestChangedConfigKeys(t *testing.T) {
+	before := &Config{CECAdapter: "/dev/ttyACM0", LogLevel: "info"}
+	after := &Config{CECAdapter: "/dev/ttyACM1", LogLevel: "debug", PowerDevices: []int{0}}
+	got := changedConfigKeys(before, after)
+	if len(got) != 2 || got[0] != "cec-adapter" || got[1] != "devices" {
+		t.Errorf("Expected cec-adapter and devices, got %v", got)
+	}
+}
@@ -0,0 +1,316 @@
+package cecctl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/beeker1121/goque"
+	"github.com/claes/cec"
+	"github.com/spf13/cobra"
+)
+
+// newReplayCmd returns the replay subcommand, which runs the events saved in
+// a queue directory through the keymap and power handling without an adapter.
+func newReplayCmd() *cobra.Command {
+	var queueDir string
+	var dryRun bool
+	cmd := &cobra.Command{
+		Use:   "replay --queue-dir <path>",
+		Short: "Replay the events saved in a queue directory",
+		Long: `Reads the key and power events saved in a queue directory (e.g. one left by
+an automatic restart or --keep-queue) and runs them, oldest first, through the
+configured keymap and power handling, printing what is done for each one. The
+directory is not modified, so a reported sequence can be replayed again.
+Quiet hours are checked against the time each event was queued.
+
+No CEC adapter is opened: power commands are only printed. Key presses are
+sent to the virtual keyboard, or only printed with --dry-run.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			discardTempQueueDir(cfg)
+			if err := validateConfig(cfg); err != nil {
+				return err
+			}
+			items, err := loadQueueItems(queueDir)
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			printer := replayPrinter{out: out}
+			if !dryRun {
+				if printer.keyboard, err = newKeyboardEmitter(cfg.KeyBackend, cfg.TargetTTY); err != nil {
+					return fmt.Errorf("failed to initialize virtual keyboard (use --dry-run to only print key presses): %w", err)
+				}
+			}
+			return runReplay(out, cfg, items, printer)
+		},
+	}
+	cmd.Flags().StringVar(&queueDir, "queue-dir", "", "Queue directory holding the events to replay")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the key presses instead of sending them")
+	cmd.MarkFlagRequired("queue-dir")
+	return cmd
+}
+
+// loadQueueItems reads every item of the queue store in dir, oldest first,
+// without removing them.
+func loadQueueItems(dir string) ([]queueItem, error) {
+	// goque creates missing directories, which would hide a typo.
+	if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("queue directory %s does not exist", dir)
+	}
+	store, err := goque.OpenQueue(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open queue store: %w", err)
+	}
+	defer store.Close()
+
+	items := make([]queueItem, 0, store.Length())
+	for offset := uint64(0); offset < store.Length(); offset++ {
+		item, err := store.PeekByOffset(offset)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read queue item %d: %w", offset, err)
+		}
+		var qItem queueItem
+		if err := json.Unmarshal(item.Value, &qItem); err != nil {
+			return nil, fmt.Errorf("failed to parse queue item %d: %w", offset, err)
+		}
+		items = append(items, qItem)
+	}
+	return items, nil
+}
+
+// runReplay dispatches items like the main loop does, with printer standing
+// in for the CEC adapter and, in a dry run, the virtual keyboard.
+func runReplay(out io.Writer, cfg *Config, items []queueItem, printer replayPrinter) error {
+	var volume VolumeController
+	if cfg.TVSpeakers {
+		volume = printer
+	}
+	keyMapObj, err := newKeyMapWithPreset(cfg.KeyMapOverrides, cfg.RemotePreset, printer, volume)
+	if err != nil {
+		return err
+	}
+	defer keyMapObj.Close()
+	clock := &replayClock{}
+	keyMapObj.clock = clock
+	if err := configureKeyMap(keyMapObj, cfg); err != nil {
+		return err
+	}
+	keyMapObj.SetPowerController(printer)
+	keyMapObj.SetWheelEmitter(printer)
+	if printer.keyboard == nil {
+		keyMapObj.windows = printer
+	}
+
+	fmt.Fprintf(out, "Replaying %d event(s)\n", len(items))
+	var powerEvents powerSequence
+	for _, item := range items {
+		queued := "unknown time"
+		if !item.Queued.IsZero() {
+			queued = item.Queued.Format(time.RFC3339Nano)
+			// Keys waiting for a second press or the rest of a channel
+			// number are sent if their window ended before this event.
+			clock.advance(item.Queued)
+			keyMapObj.flush()
+		}
+		event, err := item.decode()
+		if err != nil {
+			fmt.Fprintf(out, "%s: skipped: %v\n", queued, err)
+			continue
+		}
+		switch event := event.(type) {
+		case PowerEvent:
+			fmt.Fprintf(out, "%s: power event %s\n", queued, powerEventLabel(event))
+			if !powerEvents.accept(event) {
+				fmt.Fprintln(out, "  ignored, shutdown in progress")
+				continue
+			}
+			action := planPowerAction(cfg, event, item.Queued)
+			switch {
+			case action.Skip != "":
+				fmt.Fprintf(out, "  ignored, %s\n", action.Skip)
+				continue
+			case action.OneTouchPlay:
+				printer.OneTouchPlay()
+				if len(action.Devices) > 0 {
+					fmt.Fprintf(out, "  power on devices %v\n", action.Devices)
+				}
+			case action.PowerOn:
+				fmt.Fprintf(out, "  power on devices %v\n", action.Devices)
+			case action.StandbyAll:
+				printer.StandbyAll()
+			case action.Unknown:
+				fmt.Fprintf(out, "  standby devices %v (unknown-power-events)\n", action.Devices)
+			default:
+				fmt.Fprintf(out, "  standby devices %v\n", action.Devices)
+			}
+			if action.StreamPath != "" {
+				fmt.Fprintf(out, "  switch TV input to %s\n", action.StreamPath)
+			}
+		case *cec.KeyPress:
+			label := fmt.Sprintf("0x%02X", event.KeyCode)
+			if name, ok := vendorKeyName(event.KeyCode); ok {
+				label = name
+			}
+			duration := time.Duration(event.Duration) * time.Millisecond
+			if duration > 0 {
+				fmt.Fprintf(out, "%s: release of key %s held %s\n", queued, label, duration)
+			} else {
+				fmt.Fprintf(out, "%s: key %s (profile %q)\n", queued, label, keyMapObj.Profile())
+			}
+			keyMapObj.OnKeyEvent(event.KeyCode, duration)
+			keyMapObj.flush()
+		}
+	}
+	// Send what is still waiting, like the daemon does when it stops.
+	keyMapObj.Close()
+	return nil
+}
+
+// replayClock is the keymap clock of a replay. It stands at the time the
+// event being replayed was queued, so the double press window and the channel
+// number timeout end as they did when the events were received, without
+// waiting for them.
+type replayClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []replayTimer
+}
+
+type replayTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func (c *replayClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *replayClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := replayTimer{at: c.now.Add(d), ch: make(chan time.Time, 1)}
+	c.timers = append(c.timers, t)
+	return t.ch
+}
+
+// NewTicker is not used by the keymap.
+func (c *replayClock) NewTicker(d time.Duration) Ticker {
+	return realClock{}.NewTicker(d)
+}
+
+// advance moves the clock to t, unless it is already past it, and fires the
+// timers due.
+func (c *replayClock) advance(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t.After(c.now) {
+		c.now = t
+	}
+	pending := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.at.After(c.now) {
+			pending = append(pending, timer)
+			continue
+		}
+		timer.ch <- c.now
+	}
+	c.timers = pending
+}
+
+// powerEventLabel names a power event for the replay output.
+func powerEventLabel(ev PowerEvent) string {
+	switch ev.Type {
+	case PowerOn:
+		return "startup"
+	case PowerSleep:
+		return "sleep"
+	case PowerResume:
+		return "resume"
+	case PowerShutdown:
+		if !ev.Active {
+			return "shutdown cancelled"
+		}
+		return "shutdown"
+	}
+	return fmt.Sprintf("unknown (%d)", ev.Type)
+}
+
+// replayPrinter stands in for the CEC adapter and the window manager during a
+// replay, printing what would be done. Key presses are also sent to keyboard
+// unless it is nil.
+type replayPrinter struct {
+	out      io.Writer
+	keyboard KeyboardEmitter
+}
+
+func (p replayPrinter) Emit(keyCodes []int) error {
+	fmt.Fprintf(p.out, "  send Linux key codes %v\n", keyCodes)
+	if p.keyboard == nil {
+		return nil
+	}
+	return p.keyboard.Emit(keyCodes)
+}
+
+// Close closes the virtual keyboard, if it holds a device. The keymap calls
+// it when it is closed.
+func (p replayPrinter) Close() error {
+	if closer, ok := p.keyboard.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (p replayPrinter) VolumeUp() error {
+	fmt.Fprintln(p.out, "  send CEC volume up")
+	return nil
+}
+
+func (p replayPrinter) VolumeDown() error {
+	fmt.Fprintln(p.out, "  send CEC volume down")
+	return nil
+}
+
+func (p replayPrinter) Mute() error {
+	fmt.Fprintln(p.out, "  send CEC mute")
+	return nil
+}
+
+func (p replayPrinter) SetMute(muted bool) error {
+	fmt.Fprintf(p.out, "  send CEC mute %v\n", muted)
+	return nil
+}
+
+func (p replayPrinter) StandbyAll() error {
+	fmt.Fprintln(p.out, "  standby every device")
+	return nil
+}
+
+func (p replayPrinter) OneTouchPlay() error {
+	fmt.Fprintln(p.out, "  one touch play")
+	return nil
+}
+
+func (p replayPrinter) Scroll(horizontal, vertical int) error {
+	fmt.Fprintf(p.out, "  scroll horizontally %d, vertically %d\n", horizontal, vertical)
+	return nil
+}
+
+func (p replayPrinter) Activate(class string) error {
+	fmt.Fprintf(p.out, "  focus window %s\n", class)
+	return nil
+}
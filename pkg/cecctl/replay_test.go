@@ -0,0 +1,326 @@
+package cecctl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beeker1121/goque"
+)
+
+func TestReplay(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "queue")
+	store, err := goque.OpenQueue(dir)
+	if err != nil {
+		t.Fatalf("OpenQueue failed: %v", err)
+	}
+	queued := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	items := []queueItem{
+		{Type: "power", Data: json.RawMessage(`{"Type":2,"Active":false}`), Queued: queued},
+		{Type: "key", Data: json.RawMessage(`{"KeyCode":0,"Duration":0}`), Queued: queued},
+		{Type: "key", Data: json.RawMessage(`{"KeyCode":0,"Duration":120}`), Queued: queued},
+		{Type: "bogus", Data: json.RawMessage(`{}`)},
+		{Type: "key", Data: json.RawMessage(`{"KeyCode":13,"Duration":0}`), Queued: queued},
+	}
+	for _, item := range items {
+		if _, err := store.EnqueueObjectAsJSON(item); err != nil {
+			t.Fatalf("Enqueue failed: %v", err)
+		}
+	}
+	store.Close()
+
+	loaded, err := loadQueueItems(dir)
+	if err != nil {
+		t.Fatalf("loadQueueItems failed: %v", err)
+	}
+	if len(loaded) != len(items) {
+		t.Fatalf("Expected %d items, got %d", len(items), len(loaded))
+	}
+	// The directory is left as is, so it can be replayed again.
+	if again, err := loadQueueItems(dir); err != nil || len(again) != len(items) {
+		t.Fatalf("Expected the queue to be unchanged, got %d items, %v", len(again), err)
+	}
+
+	var out bytes.Buffer
+	cfg := &Config{PowerDevices: []int{0, 5}, KeyMapOverrides: map[string][]int{"Select": {29, 28}}, KeyActions: map[string]string{"Exit": actionPowerOffAll}}
+	if err := runReplay(&out, cfg, loaded, replayPrinter{out: &out}); err != nil {
+		t.Fatalf("runReplay failed: %v", err)
+	}
+
+	want := []string{
+		"Replaying 5 event(s)",
+		"2026-01-02T03:04:05Z: power event resume",
+		"  power on devices [0 5]",
+		`2026-01-02T03:04:05Z: key 0x00 (profile "default")`,
+		"  send Linux key codes [29 28]",
+		"2026-01-02T03:04:05Z: release of key 0x00 held 120ms",
+		`unknown time: skipped: unknown queue item type "bogus"`,
+		`2026-01-02T03:04:05Z: key 0x0D (profile "default")`,
+		"  standby every device",
+	}
+	if got := strings.Split(strings.TrimSpace(out.String()), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unexpected replay output:\n%s\nwant:\n%s", out.String(), strings.Join(want, "\n"))
+	}
+}
+
+func TestReplay_StandbyAllOnShutdown(t *testing.T) {
+	items := []queueItem{
+		{Type: "power", Data: json.RawMessage(`{"Type":1,"Active":true}`)},
+		{Type: "power", Data: json.RawMessage(`{"Type":3,"Active":true}`)},
+	}
+	var out bytes.Buffer
+	cfg := &Config{PowerDevices: []int{0}, StandbyAllOnShutdown: true}
+	if err := runReplay(&out, cfg, items, replayPrinter{out: &out}); err != nil {
+		t.Fatalf("runReplay failed: %v", err)
+	}
+
+	want := []string{
+		"Replaying 2 event(s)",
+		"unknown time: power event sleep",
+		"  standby devices [0]",
+		"unknown time: power event shutdown",
+		"  standby every device",
+	}
+	if got := strings.Split(strings.TrimSpace(out.String()), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unexpected replay output:\n%s\nwant:\n%s", out.String(), strings.Join(want, "\n"))
+	}
+}
+
+func TestReplay_ResumeOneTouchPlay(t *testing.T) {
+	items := []queueItem{
+		{Type: "power", Data: json.RawMessage(`{"Type":0,"Active":true}`)},
+		{Type: "power", Data: json.RawMessage(`{"Type":2,"Active":false}`)},
+	}
+	var out bytes.Buffer
+	cfg := &Config{PowerDevices: []int{0, 5}, ResumeOneTouchPlay: true}
+	if err := runReplay(&out, cfg, items, replayPrinter{out: &out}); err != nil {
+		t.Fatalf("runReplay failed: %v", err)
+	}
+
+	want := []string{
+		"Replaying 2 event(s)",
+		"unknown time: power event startup",
+		"  power on devices [0 5]",
+		"unknown time: power event resume",
+		"  one touch play",
+		"  power on devices [5]",
+	}
+	if got := strings.Split(strings.TrimSpace(out.String()), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unexpected replay output:\n%s\nwant:\n%s", out.String(), strings.Join(want, "\n"))
+	}
+}
+
+func TestReplay_QuietHours(t *testing.T) {
+	night := time.Date(2026, 1, 2, 23, 30, 0, 0, time.UTC)
+	morning := time.Date(2026, 1, 3, 8, 0, 0, 0, time.UTC)
+	items := []queueItem{
+		{Type: "power", Data: json.RawMessage(`{"Type":2,"Active":false}`), Queued: night},
+		{Type: "power", Data: json.RawMessage(`{"Type":1,"Active":true}`), Queued: night},
+		{Type: "power", Data: json.RawMessage(`{"Type":2,"Active":false}`), Queued: morning},
+	}
+	var out bytes.Buffer
+	cfg := &Config{PowerDevices: []int{0}, QuietHours: "23:00-07:00", ResumeInput: "2.0.0.0"}
+	if err := runReplay(&out, cfg, items, replayPrinter{out: &out}); err != nil {
+		t.Fatalf("runReplay failed: %v", err)
+	}
+
+	want := []string{
+		"Replaying 3 event(s)",
+		"2026-01-02T23:30:00Z: power event resume",
+		"  ignored, quiet hours 23:00-07:00",
+		"2026-01-02T23:30:00Z: power event sleep",
+		"  standby devices [0]",
+		"2026-01-03T08:00:00Z: power event resume",
+		"  power on devices [0]",
+		"  switch TV input to 2.0.0.0",
+	}
+	if got := strings.Split(strings.TrimSpace(out.String()), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unexpected replay output:\n%s\nwant:\n%s", out.String(), strings.Join(want, "\n"))
+	}
+}
+
+func TestReplay_ResumeDuringShutdown(t *testing.T) {
+	// logind reporting a resume in the middle of a shutdown.
+	items := []queueItem{
+		{Type: "power", Data: json.RawMessage(`{"Type":3,"Active":true}`)},
+		{Type: "power", Data: json.RawMessage(`{"Type":2,"Active":false}`)},
+		{Type: "power", Data: json.RawMessage(`{"Type":1,"Active":true}`)},
+		{Type: "power", Data: json.RawMessage(`{"Type":2,"Active":false}`)},
+	}
+	var out bytes.Buffer
+	cfg := &Config{PowerDevices: []int{0}}
+	if err := runReplay(&out, cfg, items, replayPrinter{out: &out}); err != nil {
+		t.Fatalf("runReplay failed: %v", err)
+	}
+
+	want := []string{
+		"Replaying 4 event(s)",
+		"unknown time: power event shutdown",
+		"  standby devices [0]",
+		"unknown time: power event resume",
+		"  ignored, shutdown in progress",
+		"unknown time: power event sleep",
+		"  standby devices [0]",
+		"unknown time: power event resume",
+		"  ignored, shutdown in progress",
+	}
+	if got := strings.Split(strings.TrimSpace(out.String()), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unexpected replay output:\n%s\nwant:\n%s", out.String(), strings.Join(want, "\n"))
+	}
+}
+
+func TestReplay_ShutdownCancelled(t *testing.T) {
+	items := []queueItem{
+		{Type: "power", Data: json.RawMessage(`{"Type":3,"Active":true}`)},
+		{Type: "power", Data: json.RawMessage(`{"Type":3,"Active":false}`)},
+		{Type: "power", Data: json.RawMessage(`{"Type":2,"Active":false}`)},
+	}
+	var out bytes.Buffer
+	cfg := &Config{PowerDevices: []int{0}, StandbyAllOnShutdown: true}
+	if err := runReplay(&out, cfg, items, replayPrinter{out: &out}); err != nil {
+		t.Fatalf("runReplay failed: %v", err)
+	}
+
+	want := []string{
+		"Replaying 3 event(s)",
+		"unknown time: power event shutdown",
+		"  standby every device",
+		"unknown time: power event shutdown cancelled",
+		"  ignored, shutdown cancelled",
+		"unknown time: power event resume",
+		"  power on devices [0]",
+	}
+	if got := strings.Split(strings.TrimSpace(out.String()), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unexpected replay output:\n%s\nwant:\n%s", out.String(), strings.Join(want, "\n"))
+	}
+}
+
+func TestReplay_ShutdownThenResumeEndsInStandby(t *testing.T) {
+	// Whatever the resume handling, a resume reported after an active
+	// shutdown must not undo the standby.
+	items := []queueItem{
+		{Type: "power", Data: json.RawMessage(`{"Type":3,"Active":true}`)},
+		{Type: "power", Data: json.RawMessage(`{"Type":2,"Active":false}`)},
+	}
+	tests := []struct {
+		name string
+		cfg  *Config
+		want string
+	}{
+		{"power devices", &Config{PowerDevices: []int{0, 5}}, "  standby devices [0 5]"},
+		{"one touch play", &Config{PowerDevices: []int{0, 5}, ResumeOneTouchPlay: true}, "  standby devices [0 5]"},
+		{"standby all", &Config{PowerDevices: []int{0}, StandbyAllOnShutdown: true, ResumeOneTouchPlay: true}, "  standby every device"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := runReplay(&out, tt.cfg, items, replayPrinter{out: &out}); err != nil {
+				t.Fatalf("runReplay failed: %v", err)
+			}
+			var commands []string
+			for _, line := range strings.Split(out.String(), "\n") {
+				if strings.HasPrefix(line, "  ") && line != "  ignored, shutdown in progress" {
+					commands = append(commands, line)
+				}
+			}
+			if len(commands) == 0 || commands[len(commands)-1] != tt.want {
+				t.Errorf("Expected the last command to be %q, got %q", tt.want, commands)
+			}
+		})
+	}
+}
+
+func TestReplay_KeyTiming(t *testing.T) {
+	// The double press window and the channel number timeout follow the
+	// times the events were queued.
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	key := func(code int, after time.Duration) queueItem {
+		return queueItem{Type: "key", Data: json.RawMessage(fmt.Sprintf(`{"KeyCode":%d,"Duration":0}`, code)), Queued: start.Add(after)}
+	}
+	release := func(code int, after time.Duration) queueItem {
+		return queueItem{Type: "key", Data: json.RawMessage(fmt.Sprintf(`{"KeyCode":%d,"Duration":50}`, code)), Queued: start.Add(after)}
+	}
+	items := []queueItem{
+		key(0x0D, 0),
+		release(0x0D, 50*time.Millisecond),
+		key(0x0D, 100*time.Millisecond),
+		release(0x0D, 150*time.Millisecond),
+		key(0x0D, 2*time.Second),
+		key(0x21, 3*time.Second),
+		key(0x22, 3100*time.Millisecond),
+		key(0x00, 6*time.Second),
+		key(0x23, 7*time.Second),
+	}
+	var out bytes.Buffer
+	cfg := &Config{
+		KeyActions:        map[string]string{"Exit" + doublePressSuffix: actionPowerOffAll},
+		DoublePressWindow: 400 * time.Millisecond,
+		NumberMode:        NumberModeChannel,
+	}
+	if err := runReplay(&out, cfg, items, replayPrinter{out: &out}); err != nil {
+		t.Fatalf("runReplay failed: %v", err)
+	}
+
+	want := []string{
+		"Replaying 9 event(s)",
+		`2026-01-02T03:04:05Z: key 0x0D (profile "default")`,
+		"2026-01-02T03:04:05.05Z: release of key 0x0D held 50ms",
+		`2026-01-02T03:04:05.1Z: key 0x0D (profile "default")`,
+		"  standby every device",
+		"2026-01-02T03:04:05.15Z: release of key 0x0D held 50ms",
+		`2026-01-02T03:04:07Z: key 0x0D (profile "default")`,
+		"  send Linux key codes [1]",
+		`2026-01-02T03:04:08Z: key 0x21 (profile "default")`,
+		`2026-01-02T03:04:08.1Z: key 0x22 (profile "default")`,
+		"  send Linux key codes [2]",
+		"  send Linux key codes [3]",
+		"  send Linux key codes [28]",
+		`2026-01-02T03:04:11Z: key 0x00 (profile "default")`,
+		"  send Linux key codes [28]",
+		`2026-01-02T03:04:12Z: key 0x23 (profile "default")`,
+		"  send Linux key codes [4]",
+		"  send Linux key codes [28]",
+	}
+	if got := strings.Split(strings.TrimSpace(out.String()), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unexpected replay output:\n%s\nwant:\n%s", out.String(), strings.Join(want, "\n"))
+	}
+}
+
+func TestReplay_ShiftLayer(t *testing.T) {
+	// The layer applies to the key pressed right after the modifier, whose
+	// release libcec reports first.
+	items := []queueItem{
+		{Type: "key", Data: json.RawMessage(`{"KeyCode":113,"Duration":0}`)},
+		{Type: "key", Data: json.RawMessage(`{"KeyCode":113,"Duration":500}`)},
+		{Type: "key", Data: json.RawMessage(`{"KeyCode":1,"Duration":0}`)},
+		{Type: "key", Data: json.RawMessage(`{"KeyCode":1,"Duration":0}`)},
+	}
+	var out bytes.Buffer
+	cfg := &Config{LayerShift: &ShiftLayer{Key: "Blue", KeyMap: map[string][]int{"Up": {104}}}}
+	if err := runReplay(&out, cfg, items, replayPrinter{out: &out}); err != nil {
+		t.Fatalf("runReplay failed: %v", err)
+	}
+
+	want := []string{
+		"Replaying 4 event(s)",
+		`unknown time: key 0x71 (profile "default")`,
+		"unknown time: release of key 0x71 held 500ms",
+		`unknown time: key 0x01 (profile "default")`,
+		"  send Linux key codes [104]",
+		`unknown time: key 0x01 (profile "default")`,
+		"  send Linux key codes [103]",
+	}
+	if got := strings.Split(strings.TrimSpace(out.String()), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unexpected replay output:\n%s\nwant:\n%s", out.String(), strings.Join(want, "\n"))
+	}
+}
+
+func TestLoadQueueItems_MissingDir(t *testing.T) {
+	if _, err := loadQueueItems(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("Expected an error for a missing queue directory")
+	}
+}
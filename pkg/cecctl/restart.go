@@ -0,0 +1,81 @@
+package cecctl
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// restartLogFile is the file in the queue directory recording why the
+// process restarted itself, read back by the restarted process.
+const restartLogFile = "restarts.json"
+
+// restartLogWindow is how long restarts are remembered for.
+const restartLogWindow = time.Hour
+
+// restartRecord is one self-restart of the process.
+type restartRecord struct {
+	Reason      string    `json:"reason"`
+	At          time.Time `json:"at"`
+	RetriesLeft int       `json:"retries-left"`
+}
+
+// loadRestarts returns the restarts recorded in dir during the last
+// restartLogWindow, oldest first.
+func loadRestarts(dir string, now time.Time) ([]restartRecord, error) {
+	data, err := os.ReadFile(filepath.Join(dir, restartLogFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []restartRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+	recent := records[:0]
+	for _, r := range records {
+		if now.Sub(r.At) < restartLogWindow {
+			recent = append(recent, r)
+		}
+	}
+	return recent, nil
+}
+
+// recordRestart appends a restart to the log in dir, dropping the ones older
+// than restartLogWindow.
+func recordRestart(dir string, r restartRecord) error {
+	records, err := loadRestarts(dir, r.At)
+	if err != nil {
+		slog.Warn("Discarding unreadable restart log", "error", err)
+		records = nil
+	}
+	data, err := json.Marshal(append(records, r))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, restartLogFile), data, 0o644)
+}
+
+// logRestarts reports, in a process started by RestartProcess, why the
+// previous one restarted and how often it happened recently. It returns the
+// number of restarts in the last restartLogWindow.
+func logRestarts(dir string, now time.Time) int {
+	records, err := loadRestarts(dir, now)
+	if err != nil {
+		slog.Warn("Failed to read restart log", "error", err)
+		return 0
+	}
+	if len(records) == 0 {
+		return 0
+	}
+	last := records[len(records)-1]
+	slog.Warn("Process was restarted", "reason", last.Reason, "at", last.At.Format(time.RFC3339),
+		"retries-left", last.RetriesLeft, "restarts-last-hour", len(records))
+	return len(records)
+}
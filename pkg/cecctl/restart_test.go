@@ -0,0 +1,57 @@
+package cecctl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecordRestart(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Unix(1_000_000, 0)
+
+	if records, err := loadRestarts(dir, now); err != nil || len(records) != 0 {
+		t.Fatalf("Expected no restarts without a log, got %v (%v)", records, err)
+	}
+
+	steps := []restartRecord{
+		{Reason: "old", At: now.Add(-2 * time.Hour), RetriesLeft: 2},
+		{Reason: "watchdog", At: now.Add(-10 * time.Minute), RetriesLeft: 1},
+		{Reason: "power", At: now, RetriesLeft: 0},
+	}
+	for _, r := range steps {
+		if err := recordRestart(dir, r); err != nil {
+			t.Fatalf("recordRestart failed: %v", err)
+		}
+	}
+
+	records, err := loadRestarts(dir, now)
+	if err != nil {
+		t.Fatalf("loadRestarts failed: %v", err)
+	}
+	if len(records) != 2 || records[0].Reason != "watchdog" || records[1].Reason != "power" {
+		t.Errorf("Expected the two restarts of the last hour, got %+v", records)
+	}
+	if n := logRestarts(dir, now); n != 2 {
+		t.Errorf("Expected logRestarts to report 2 restarts, got %d", n)
+	}
+}
+
+func TestRecordRestart_ReplacesCorruptLog(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, restartLogFile), []byte("not json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if n := logRestarts(dir, time.Now()); n != 0 {
+		t.Errorf("Expected an unreadable log to count no restarts, got %d", n)
+	}
+
+	now := time.Now()
+	if err := recordRestart(dir, restartRecord{Reason: "power", At: now}); err != nil {
+		t.Fatalf("recordRestart failed: %v", err)
+	}
+	if records, err := loadRestarts(dir, now); err != nil || len(records) != 1 {
+		t.Errorf("Expected the corrupt log to be replaced, got %v (%v)", records, err)
+	}
+}
@@ -0,0 +1,118 @@
+package cecctl
+
+import (
+	"log/slog"
+	"runtime"
+	"time"
+)
+
+// stateSnapshot is a point-in-time view of the running daemon, dumped to the
+// log on SIGUSR1 to help diagnose a misbehaving instance without a debugger.
+type stateSnapshot struct {
+	Config           Config         // copy, as the main loop reloads the live one
+	ConfigValues     map[string]any // Config as returned by configValues
+	CECConnected     bool
+	QueueDepth       uint64
+	LastPowerEvent   *PowerEvent
+	LastPowerEventAt time.Time
+	Goroutines       int
+	RecentEvents     []eventRecord
+	KeyEventFailures uint64 // key events the keyboard failed to emit since startup
+	RecentRestarts   int    // self-restarts in the hour before this process started
+}
+
+// snapshotState gathers the current daemon state. Each component is read
+// behind its own lock so this is safe to call from the main loop. The
+// configuration is copied since reloads replace it in place.
+func snapshotState(cfg *Config, c *CEC, queue *Queue, history *EventHistory, lastPower *PowerEvent, lastPowerAt time.Time) stateSnapshot {
+	return stateSnapshot{
+		Config:           *cfg,
+		ConfigValues:     configValues(cfg),
+		CECConnected:     c.Connected(),
+		QueueDepth:       queue.Depth(),
+		LastPowerEvent:   lastPower,
+		LastPowerEventAt: lastPowerAt,
+		Goroutines:       runtime.NumGoroutine(),
+		RecentEvents:     history.Recent(),
+	}
+}
+
+func (s stateSnapshot) log() {
+	attrs := []any{
+		"version", Version,
+		"devices", s.Config.PowerDevices,
+		"names", deviceLabels(s.Config.PowerDevices, s.Config.DeviceAliases),
+		"cec-adapter", s.Config.CECAdapter,
+		"device-name", s.Config.DeviceName,
+		"no-power-events", s.Config.NoPowerEvents,
+		"cec-connected", s.CECConnected,
+		"queue-depth", s.QueueDepth,
+		"goroutines", s.Goroutines,
+		"key-event-failures", s.KeyEventFailures,
+		"recent-restarts", s.RecentRestarts,
+	}
+	if s.LastPowerEvent != nil {
+		attrs = append(attrs,
+			"last-power-event", s.LastPowerEvent.Type,
+			"last-power-event-active", s.LastPowerEvent.Active,
+			"last-power-event-at", s.LastPowerEventAt.Format(time.RFC3339))
+	}
+	slog.Info("State dump", attrs...)
+	for _, ev := range s.RecentEvents {
+		if ev.Kind == "key" {
+			slog.Info("Recent event", "at", ev.Time.Format(time.RFC3339), "kind", ev.Kind, "cec-key-code", ev.KeyCode)
+		} else {
+			slog.Info("Recent event", "at", ev.Time.Format(time.RFC3339), "kind", ev.Kind, "type", ev.Power, "active", ev.Active)
+		}
+	}
+}
+
+// snapshotDocument is the JSON form of a stateSnapshot, printed by the
+// snapshot subcommand.
+type snapshotDocument struct {
+	Version          string          `json:"version"`
+	Config           map[string]any  `json:"config"`
+	CECConnected     bool            `json:"cec-connected"`
+	QueueDepth       uint64          `json:"queue-depth"`
+	LastPowerEvent   string          `json:"last-power-event,omitempty"`
+	LastPowerEventAt time.Time       `json:"last-power-event-at,omitzero"`
+	Goroutines       int             `json:"goroutines"`
+	KeyEventFailures uint64          `json:"key-event-failures"`
+	RecentRestarts   int             `json:"recent-restarts"`
+	RecentEvents     []snapshotEvent `json:"recent-events"`
+}
+
+// snapshotEvent is the JSON form of an eventRecord.
+type snapshotEvent struct {
+	Time       time.Time `json:"time"`
+	Kind       string    `json:"kind"`
+	KeyCode    *int      `json:"cec-key-code,omitempty"`
+	PowerEvent string    `json:"power-event,omitempty"`
+}
+
+func (s stateSnapshot) document() snapshotDocument {
+	doc := snapshotDocument{
+		Version:          Version,
+		Config:           s.ConfigValues,
+		CECConnected:     s.CECConnected,
+		QueueDepth:       s.QueueDepth,
+		Goroutines:       s.Goroutines,
+		KeyEventFailures: s.KeyEventFailures,
+		RecentRestarts:   s.RecentRestarts,
+		RecentEvents:     make([]snapshotEvent, 0, len(s.RecentEvents)),
+	}
+	if s.LastPowerEvent != nil {
+		doc.LastPowerEvent = powerEventLabel(*s.LastPowerEvent)
+		doc.LastPowerEventAt = s.LastPowerEventAt
+	}
+	for _, ev := range s.RecentEvents {
+		event := snapshotEvent{Time: ev.Time, Kind: ev.Kind}
+		if ev.Kind == "key" {
+			event.KeyCode = &ev.KeyCode
+		} else {
+			event.PowerEvent = powerEventLabel(PowerEvent{Type: ev.Power, Active: ev.Active})
+		}
+		doc.RecentEvents = append(doc.RecentEvents, event)
+	}
+	return doc
+}
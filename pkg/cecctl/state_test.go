@@ -0,0 +1,81 @@
+package cecctl
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshotState(t *testing.T) {
+	q, err := NewQueue(context.Background(), t.TempDir(), false)
+	if err != nil {
+		t.Fatalf("NewQueue failed: %v", err)
+	}
+	defer q.Close()
+
+	c := newTestCEC(&MockCECConnection{}, nil)
+	cfg := &Config{PowerDevices: []int{0}}
+	ev := PowerEvent{Type: PowerSleep, Active: true}
+	at := time.Now()
+
+	history := NewEventHistory(10)
+	history.RecordPower(ev)
+
+	s := snapshotState(cfg, c, q, history, &ev, at)
+	if !s.CECConnected {
+		t.Error("Expected CEC to be reported as connected")
+	}
+	if s.QueueDepth != 0 {
+		t.Errorf("Expected empty queue, got depth %d", s.QueueDepth)
+	}
+	if s.LastPowerEvent == nil || s.LastPowerEvent.Type != PowerSleep {
+		t.Errorf("Expected last power event PowerSleep, got %+v", s.LastPowerEvent)
+	}
+	if len(s.RecentEvents) != 1 || s.RecentEvents[0].Power != PowerSleep {
+		t.Errorf("Expected the recorded power event in recent events, got %+v", s.RecentEvents)
+	}
+	if s.Goroutines == 0 {
+		t.Error("Expected a non-zero goroutine count")
+	}
+
+	c.Close()
+	if snapshotState(cfg, c, q, history, nil, time.Time{}).CECConnected {
+		t.Error("Expected CEC to be reported as disconnected after Close")
+	}
+}
+
+func TestSnapshotDocument_Config(t *testing.T) {
+	cfg := &Config{
+		DeviceName:    "HTPC",
+		OnReady:       "curl -H 'Authorization: Bearer s3cret' https://example.com/ready",
+		StartupSettle: 2 * time.Second,
+	}
+	s := stateSnapshot{ConfigValues: configValues(cfg)}
+	// The snapshot must not follow later changes to the live configuration.
+	cfg.DeviceName = "reloaded"
+
+	data, err := json.Marshal(s.document())
+	if err != nil {
+		t.Fatalf("Failed to encode the snapshot: %v", err)
+	}
+	if strings.Contains(string(data), "s3cret") {
+		t.Fatalf("Expected on-ready redacted, got %s", data)
+	}
+	var doc struct {
+		Config map[string]any `json:"config"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("Invalid snapshot JSON: %v", err)
+	}
+	if doc.Config["on-ready"] != redactedValue {
+		t.Errorf("Expected on-ready %q, got %v", redactedValue, doc.Config["on-ready"])
+	}
+	if doc.Config["startup-settle-ms"] != float64(2000) {
+		t.Errorf("Expected startup-settle-ms 2000, got %v", doc.Config["startup-settle-ms"])
+	}
+	if doc.Config["device-name"] != "HTPC" {
+		t.Errorf("Expected device-name HTPC, got %v", doc.Config["device-name"])
+	}
+}
@@ -0,0 +1,106 @@
+package cecctl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// newTestKeyCmd returns the test-key subcommand, which fires a single CEC key
+// through the configured keymap without needing a remote.
+func newTestKeyCmd() *cobra.Command {
+	var delay time.Duration
+	cmd := &cobra.Command{
+		Use:   "test-key <cec-key-name|code>",
+		Short: "Resolve a CEC key through the configured keymap and send it once",
+		Long: `Loads the configuration, resolves the given CEC key (by name, e.g. "Select",
+or by code, e.g. 0x91) through the active keymap profile, prints the mapping
+and sends the resulting keystroke once, exactly as a remote press would.
+
+A key bound to an action runs it. power: and cec: actions are the exception:
+the CEC adapter is held by the running daemon, so they are only printed.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runTestKey(cmd.OutOrStdout(), args[0], delay)
+		},
+	}
+	cmd.Flags().DurationVar(&delay, "delay", 2*time.Second, "Time to wait before sending, so the desktop picks up the virtual keyboard and you can focus the target app")
+	return cmd
+}
+
+func runTestKey(out io.Writer, key string, delay time.Duration) error {
+	codes := parseCECKeys([]string{key})
+	if len(codes) != 1 {
+		return fmt.Errorf("unknown CEC key %q", key)
+	}
+	code := codes[0]
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	discardTempQueueDir(cfg)
+	if err := validateConfig(cfg); err != nil {
+		return err
+	}
+
+	keyMapObj, err := NewKeyMap(cfg.KeyMapOverrides, cfg.RemotePreset, cfg.KeyBackend, cfg.TargetTTY, nil)
+	if err != nil {
+		var uerr *uinputError
+		if errors.As(err, &uerr) && uerr.Hint != "" {
+			return fmt.Errorf("failed to initialize virtual keyboard: %w; to fix it, %s", err, uerr.Hint)
+		}
+		return fmt.Errorf("failed to initialize virtual keyboard: %w", err)
+	}
+	defer keyMapObj.Close()
+	if err := configureKeyMap(keyMapObj, cfg); err != nil {
+		return err
+	}
+
+	label := fmt.Sprintf("%q (0x%02X)", key, code)
+	linuxKeyCodes, ok := keyMapObj.Lookup(code)
+	action, isAction := keyMapObj.Action(code)
+	switch {
+	case keyMapObj.Ignored(code):
+		// Both settings drop keys: name the one that did.
+		setting := "not in allow-keys"
+		if slices.Contains(cfg.IgnoreKeys, code) {
+			setting = "ignore-keys"
+		}
+		fmt.Fprintf(out, "CEC key %s is ignored (%s), nothing to send\n", label, setting)
+		return nil
+	case isAction:
+		fmt.Fprintf(out, "CEC key %s is bound to action %s\n", label, action)
+		for _, single := range splitActions(action) {
+			if single == actionPowerOffAll || single == actionOneTouchPlay {
+				fmt.Fprintf(out, "Action %s needs the CEC adapter, held by the running daemon: not run\n", single)
+			}
+		}
+		if usesScrollActions(map[string]string{key: action}) {
+			wheel, err := newUinputWheel(uinputPath)
+			if err != nil {
+				return fmt.Errorf("failed to create the virtual wheel: %w", err)
+			}
+			defer wheel.Close()
+			keyMapObj.SetWheelEmitter(wheel)
+		}
+	case !ok:
+		fmt.Fprintf(out, "CEC key %s is not mapped in profile %q\n", label, keyMapObj.Profile())
+		return nil
+	default:
+		fmt.Fprintf(out, "CEC key %s -> Linux key codes %v (profile %q, backend %s)\n", label, linuxKeyCodes, keyMapObj.Profile(), cfg.KeyBackend)
+	}
+
+	if delay > 0 {
+		fmt.Fprintf(out, "Sending in %s...\n", delay)
+		time.Sleep(delay)
+	}
+	keyMapObj.OnKeyEvent(code, 0)
+	// Wait for the key to be handled while the wheel is still open.
+	keyMapObj.Close()
+	return nil
+}
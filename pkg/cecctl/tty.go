@@ -0,0 +1,156 @@
+package cecctl
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	keybd "github.com/micmonay/keybd_event"
+)
+
+// Linux key codes of the modifiers, which keybd_event does not export.
+const (
+	linuxKeyLeftCtrl   = 29
+	linuxKeyLeftShift  = 42
+	linuxKeyRightShift = 54
+	linuxKeyRightCtrl  = 97
+)
+
+// ttySequences are the bytes a terminal receives for keys that do not type a
+// character, as sent by the Linux console.
+var ttySequences = map[int]string{
+	keybd.VK_ENTER:     "\r",
+	keybd.VK_ESC:       "\x1b",
+	keybd.VK_BACKSPACE: "\x7f",
+	keybd.VK_TAB:       "\t",
+	keybd.VK_SPACE:     " ",
+	keybd.VK_UP:        "\x1b[A",
+	keybd.VK_DOWN:      "\x1b[B",
+	keybd.VK_RIGHT:     "\x1b[C",
+	keybd.VK_LEFT:      "\x1b[D",
+	keybd.VK_HOME:      "\x1b[1~",
+	keybd.VK_INSERT:    "\x1b[2~",
+	keybd.VK_DELETE:    "\x1b[3~",
+	keybd.VK_END:       "\x1b[4~",
+	keybd.VK_PAGEUP:    "\x1b[5~",
+	keybd.VK_PAGEDOWN:  "\x1b[6~",
+}
+
+// ttyCharacters are the characters typed by the letter and digit keys.
+var ttyCharacters = map[int]byte{
+	keybd.VK_A: 'a', keybd.VK_B: 'b', keybd.VK_C: 'c', keybd.VK_D: 'd', keybd.VK_E: 'e',
+	keybd.VK_F: 'f', keybd.VK_G: 'g', keybd.VK_H: 'h', keybd.VK_I: 'i', keybd.VK_J: 'j',
+	keybd.VK_K: 'k', keybd.VK_L: 'l', keybd.VK_M: 'm', keybd.VK_N: 'n', keybd.VK_O: 'o',
+	keybd.VK_P: 'p', keybd.VK_Q: 'q', keybd.VK_R: 'r', keybd.VK_S: 's', keybd.VK_T: 't',
+	keybd.VK_U: 'u', keybd.VK_V: 'v', keybd.VK_W: 'w', keybd.VK_X: 'x', keybd.VK_Y: 'y',
+	keybd.VK_Z: 'z',
+	keybd.VK_0: '0', keybd.VK_1: '1', keybd.VK_2: '2', keybd.VK_3: '3', keybd.VK_4: '4',
+	keybd.VK_5: '5', keybd.VK_6: '6', keybd.VK_7: '7', keybd.VK_8: '8', keybd.VK_9: '9',
+	keybd.VK_MINUS: '-', keybd.VK_EQUAL: '=', keybd.VK_LEFTBRACE: '[', keybd.VK_RIGHTBRACE: ']',
+	keybd.VK_SEMICOLON: ';', keybd.VK_APOSTROPHE: '\'', keybd.VK_GRAVE: '`', keybd.VK_BACKSLASH: '\\',
+	keybd.VK_COMMA: ',', keybd.VK_DOT: '.', keybd.VK_SLASH: '/',
+}
+
+// ttyShiftedCharacters are the characters typed with Shift, on the US layout
+// the Linux console uses by default. Letters are only capitalized.
+var ttyShiftedCharacters = map[byte]byte{
+	'1': '!', '2': '@', '3': '#', '4': '$', '5': '%', '6': '^', '7': '&', '8': '*', '9': '(', '0': ')',
+	'-': '_', '=': '+', '[': '{', ']': '}', ';': ':', '\'': '"', '`': '~', '\\': '|',
+	',': '<', '.': '>', '/': '?',
+}
+
+// ttyInput returns the bytes a terminal receives for a key combination, e.g.
+// Ctrl+C for 29+46. Only Ctrl and Shift are supported as modifiers, and Ctrl
+// only with letters.
+func ttyInput(keyCodes []int) ([]byte, error) {
+	var ctrl, shift bool
+	var out []byte
+	for _, code := range keyCodes {
+		switch code {
+		case linuxKeyLeftCtrl, linuxKeyRightCtrl:
+			ctrl = true
+			continue
+		case linuxKeyLeftShift, linuxKeyRightShift:
+			shift = true
+			continue
+		}
+		if seq, ok := ttySequences[code]; ok {
+			out = append(out, seq...)
+			continue
+		}
+		c, ok := ttyCharacters[code]
+		if !ok {
+			return nil, fmt.Errorf("no terminal input for Linux key code %d", code)
+		}
+		letter := c >= 'a' && c <= 'z'
+		switch {
+		case ctrl && !letter:
+			return nil, fmt.Errorf("no terminal input for Ctrl with Linux key code %d", code)
+		case ctrl:
+			c &= 0x1F
+		case shift && letter:
+			c -= 'a' - 'A'
+		case shift:
+			c = ttyShiftedCharacters[c]
+		}
+		out = append(out, c)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no terminal input for Linux key codes %v", keyCodes)
+	}
+	return out, nil
+}
+
+// ttyEmitter is a KeyboardEmitter that types into a given virtual terminal
+// with the TIOCSTI ioctl, for console setups pinned to one tty. Keys without
+// terminal input, e.g. media keys, cannot be sent.
+type ttyEmitter struct {
+	mu     sync.Mutex
+	path   string
+	file   *os.File // nil in tests
+	inject func(b byte) error
+}
+
+// newTTYEmitter opens the terminal at path. Injecting into a terminal other
+// than the controlling one needs CAP_SYS_ADMIN, and kernels built without
+// legacy TIOCSTI support need dev.tty.legacy_tiocsti=1.
+func newTTYEmitter(path string) (*ttyEmitter, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open target tty: %w", err)
+	}
+	inject := func(b byte) error {
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TIOCSTI, uintptr(unsafe.Pointer(&b))); errno != 0 {
+			return errno
+		}
+		return nil
+	}
+	return &ttyEmitter{path: path, file: f, inject: inject}, nil
+}
+
+func (t *ttyEmitter) Emit(keyCodes []int) error {
+	input, err := ttyInput(keyCodes)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, b := range input {
+		if err := t.inject(b); err != nil {
+			return fmt.Errorf("failed to type into %s: %w", t.path, err)
+		}
+	}
+	return nil
+}
+
+// Close closes the terminal. The keymap calls it when it is closed.
+func (t *ttyEmitter) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.file == nil {
+		return nil
+	}
+	return t.file.Close()
+}
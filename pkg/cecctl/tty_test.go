@@ -0,0 +1,71 @@
+package cecctl
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	keybd "github.com/micmonay/keybd_event"
+)
+
+func TestTTYInput(t *testing.T) {
+	tests := []struct {
+		keyCodes []int
+		want     string
+	}{
+		{[]int{keybd.VK_ENTER}, "\r"},
+		{[]int{keybd.VK_UP}, "\x1b[A"},
+		{[]int{keybd.VK_Q}, "q"},
+		{[]int{linuxKeyLeftShift, keybd.VK_Q}, "Q"},
+		{[]int{linuxKeyLeftCtrl, keybd.VK_C}, "\x03"},
+		{[]int{keybd.VK_1, keybd.VK_2}, "12"},
+		{[]int{linuxKeyLeftShift, keybd.VK_1}, "!"},
+		{[]int{linuxKeyRightShift, keybd.VK_SLASH}, "?"},
+		{[]int{keybd.VK_MINUS, keybd.VK_DOT}, "-."},
+		{[]int{linuxKeyLeftShift, keybd.VK_SEMICOLON, keybd.VK_0}, ":)"},
+	}
+	for _, tt := range tests {
+		got, err := ttyInput(tt.keyCodes)
+		if err != nil || string(got) != tt.want {
+			t.Errorf("ttyInput(%v) = %q, %v; expected %q", tt.keyCodes, got, err, tt.want)
+		}
+	}
+	for _, keyCodes := range [][]int{{keybd.VK_PLAY}, {linuxKeyLeftCtrl}, {linuxKeyLeftCtrl, keybd.VK_1}} {
+		if got, err := ttyInput(keyCodes); err == nil {
+			t.Errorf("Expected an error for %v, got %q", keyCodes, got)
+		}
+	}
+}
+
+func TestTTYEmitter_Emit(t *testing.T) {
+	var typed bytes.Buffer
+	e := &ttyEmitter{path: "/dev/tty3", inject: typed.WriteByte}
+	if err := e.Emit([]int{keybd.VK_L, keybd.VK_S, keybd.VK_ENTER}); err != nil {
+		t.Fatalf("Emit failed: %v", err)
+	}
+	if typed.String() != "ls\r" {
+		t.Errorf("Expected %q typed, got %q", "ls\r", typed.String())
+	}
+
+	e.inject = func(byte) error { return errors.New("operation not permitted") }
+	if err := e.Emit([]int{keybd.VK_ENTER}); err == nil {
+		t.Error("Expected the injection error to be returned")
+	}
+}
+
+func TestTTYEmitter_ClosedWithKeyMap(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "tty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &ttyEmitter{path: f.Name(), file: f, inject: func(byte) error { return nil }}
+	km, err := newKeyMapWithEmitter(nil, e, nil)
+	if err != nil {
+		t.Fatalf("newKeyMapWithEmitter failed: %v", err)
+	}
+	km.Close()
+	if _, err := f.Write([]byte("x")); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Expected the terminal closed with the keymap, got %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package cecctl
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// uinputPath is the device keybd_event opens for the virtual keyboard.
+const uinputPath = "/dev/uinput"
+
+// uinputError reports why the uinput device cannot be used, along with the
+// steps that usually fix it. Wrapping fs.ErrPermission or fs.ErrNotExist lets
+// callers tell a permission problem from a missing module.
+type uinputError struct {
+	Path string
+	Err  error
+	Hint string
+}
+
+func (e *uinputError) Error() string {
+	return fmt.Sprintf("cannot use %s: %v", e.Path, e.Err)
+}
+
+func (e *uinputError) Unwrap() error { return e.Err }
+
+// checkUinput verifies that path is a character device the current user can
+// open for writing, which is all keybd_event needs.
+func checkUinput(path string) error {
+	fi, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &uinputError{Path: path, Err: err,
+			Hint: "load the uinput module with 'sudo modprobe uinput' and add 'uinput' to /etc/modules-load.d/uinput.conf to load it at boot"}
+	}
+	if err != nil {
+		return &uinputError{Path: path, Err: err}
+	}
+	if fi.Mode()&fs.ModeCharDevice == 0 {
+		return &uinputError{Path: path, Err: errors.New("not a character device"),
+			Hint: "remove it and load the uinput module with 'sudo modprobe uinput'"}
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if errors.Is(err, fs.ErrPermission) {
+		return &uinputError{Path: path, Err: fmt.Errorf("%w (%s)", err, describeOwnership(fi)),
+			Hint: `add a udev rule such as KERNEL=="uinput", GROUP="input", MODE="0660" to /etc/udev/rules.d/99-uinput.rules, ` +
+				"run 'sudo udevadm control --reload && sudo udevadm trigger', add the user to the group with " +
+				"'sudo usermod -aG input $USER' and log in again"}
+	}
+	if err != nil {
+		return &uinputError{Path: path, Err: err}
+	}
+	return f.Close()
+}
+
+// describeOwnership returns the mode and group of a file, e.g.
+// "mode crw------- group root", for permission error messages.
+func describeOwnership(fi fs.FileInfo) string {
+	desc := "mode " + fi.Mode().String()
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return desc
+	}
+	gid := strconv.FormatUint(uint64(st.Gid), 10)
+	if g, err := user.LookupGroupId(gid); err == nil {
+		return desc + " group " + g.Name
+	}
+	return desc + " group " + gid
+}
@@ -0,0 +1,51 @@
+package cecctl
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckUinput_Missing(t *testing.T) {
+	err := checkUinput(filepath.Join(t.TempDir(), "uinput"))
+
+	var uerr *uinputError
+	if !errors.As(err, &uerr) {
+		t.Fatalf("Expected a uinputError, got %v", err)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected the error to wrap fs.ErrNotExist, got %v", err)
+	}
+	if !strings.Contains(uerr.Hint, "modprobe uinput") {
+		t.Errorf("Expected a modprobe hint, got %q", uerr.Hint)
+	}
+}
+
+func TestCheckUinput_NotADevice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "uinput")
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var uerr *uinputError
+	if err := checkUinput(path); !errors.As(err, &uerr) {
+		t.Fatalf("Expected a uinputError for a regular file, got %v", err)
+	}
+	if errors.Is(uerr, fs.ErrPermission) {
+		t.Error("Expected a regular file not to be reported as a permission problem")
+	}
+}
+
+func TestDescribeOwnership(t *testing.T) {
+	fi, err := os.Stat(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc := describeOwnership(fi)
+	if !strings.HasPrefix(desc, "mode d") || !strings.Contains(desc, " group ") {
+		t.Errorf("Expected mode and group in %q", desc)
+	}
+}
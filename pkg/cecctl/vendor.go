@@ -0,0 +1,175 @@
+package cecctl
+
+import (
+	"context"
+	"encoding/hex"
+	"log/slog"
+	"strings"
+
+	"github.com/claes/cec"
+)
+
+// Vendor specific opcodes some TVs (e.g. Samsung) use for remote buttons that
+// have no standard user control code.
+const (
+	opcodeVendorCommand          = 0x89
+	opcodeVendorRemoteButtonDown = 0x8A
+	opcodeVendorCommandWithID    = 0xA0
+)
+
+// vendorKeyPrefix marks a vendor payload in place of a CEC key name, e.g.
+// "vendor:0091" in the keymap.
+const vendorKeyPrefix = "vendor:"
+
+// Vendor payloads of up to maxVendorPayload bytes are turned into synthetic
+// key codes above the CEC user control range, so they flow through the queue
+// and the keymap like any other key press. The payload length is part of the
+// code so that 0x05 and 0x0005 stay distinct.
+const (
+	vendorKeyBase    = 1 << 28
+	maxVendorPayload = 3
+)
+
+// vendorKeyCode returns the synthetic key code of a vendor payload.
+func vendorKeyCode(payload []byte) (int, bool) {
+	if len(payload) == 0 || len(payload) > maxVendorPayload {
+		return 0, false
+	}
+	code := vendorKeyBase | len(payload)<<24
+	for i, b := range payload {
+		code |= int(b) << (8 * (len(payload) - 1 - i))
+	}
+	return code, true
+}
+
+// vendorKeyName returns the "vendor:<hex payload>" name of a synthetic key
+// code, so unmapped vendor buttons can be reported in keymap syntax.
+func vendorKeyName(code int) (string, bool) {
+	if code&^(1<<28-1) != vendorKeyBase {
+		return "", false
+	}
+	n := code >> 24 & 0xF
+	if n == 0 || n > maxVendorPayload {
+		return "", false
+	}
+	payload := make([]byte, n)
+	for i := range payload {
+		payload[i] = byte(code >> (8 * (n - 1 - i)))
+	}
+	return vendorKeyPrefix + hex.EncodeToString(payload), true
+}
+
+// parseVendorKeyName parses a "vendor:<hex payload>" key name; an optional
+// 0x prefix and colon separators are accepted.
+func parseVendorKeyName(name string) (int, bool) {
+	payloadHex, ok := strings.CutPrefix(strings.ToLower(name), vendorKeyPrefix)
+	if !ok {
+		return 0, false
+	}
+	payloadHex = strings.ReplaceAll(strings.TrimPrefix(payloadHex, "0x"), ":", "")
+	payload, err := hex.DecodeString(payloadHex)
+	if err != nil {
+		return 0, false
+	}
+	return vendorKeyCode(payload)
+}
+
+// keyCodeByName resolves a CEC key name, or a vendor payload key, to its key
+// code. It returns -1 for unknown names, like cec.GetKeyCodeByName.
+func keyCodeByName(name string) int {
+	if strings.HasPrefix(strings.ToLower(name), vendorKeyPrefix) {
+		if code, ok := parseVendorKeyName(name); ok {
+			return code
+		}
+		return -1
+	}
+	return cec.GetKeyCodeByName(name)
+}
+
+// cecKeyNames are the user control names known to the cec package, which
+// only resolves names to codes; keyName inverts it.
+var cecKeyNames = []string{
+	"Select", "Up", "Down", "Left", "Right", "RightUp", "RightDown", "LeftUp", "LeftDown",
+	"RootMenu", "SetupMenu", "ContentsMenu", "FavoriteMenu", "Exit",
+	"0", "1", "2", "3", "4", "5", "6", "7", "8", "9", "Dot", "Enter", "Clear",
+	"NextFavorite", "ChannelUp", "ChannelDown", "PreviousChannel", "SoundSelect",
+	"InputSelect", "DisplayInformation", "Help", "PageUp", "PageDown",
+	"Power", "VolumeUp", "VolumeDown", "Mute", "Play", "Stop", "Pause", "Record",
+	"Rewind", "FastForward", "Eject", "Forward", "Backward", "StopRecord", "PauseRecord",
+	"Angle", "SubPicture", "VideoOnDemand", "ElectronicProgramGuide", "TimerProgramming",
+	"InitialConfiguration", "PlayFunction", "PausePlay", "RecordFunction",
+	"PauseRecordFunction", "StopFunction", "RestoreVolume", "Tune", "SelectMedia",
+	"SelectAvInput", "SelectAudioInput", "PowerToggle", "PowerOff", "PowerOn",
+	"Blue", "Red", "Green", "Yellow", "F5", "Data", "AnReturn",
+}
+
+// keyNamesByCode maps the codes of cecKeyNames back to their names.
+var keyNamesByCode = func() map[int]string {
+	names := make(map[int]string, len(cecKeyNames))
+	for _, name := range cecKeyNames {
+		if code := cec.GetKeyCodeByName(name); code != -1 {
+			names[code] = name
+		}
+	}
+	return names
+}()
+
+// keyName returns the name of a key code as accepted in the keymap: the CEC
+// key name, or the "vendor:<hex payload>" name of a vendor key.
+func keyName(code int) (string, bool) {
+	if name, ok := vendorKeyName(code); ok {
+		return name, true
+	}
+	name, ok := keyNamesByCode[code]
+	return name, ok
+}
+
+// vendorPayload extracts the button payload of a vendor command frame.
+func vendorPayload(cmd *cec.Command) ([]byte, bool) {
+	if cmd == nil || cmd.OpcodeSet == 0 {
+		return nil, false
+	}
+	frame, err := hex.DecodeString(strings.ReplaceAll(cmd.CommandString, ":", ""))
+	if err != nil || len(frame) < 2 {
+		return nil, false
+	}
+	// The command string may carry trailing bytes past the parameters.
+	params := frame[2:]
+	if cmd.Parameters.Size < len(params) {
+		params = params[:cmd.Parameters.Size]
+	}
+
+	switch cmd.Opcode {
+	case opcodeVendorCommand, opcodeVendorRemoteButtonDown:
+		return params, len(params) > 0
+	case opcodeVendorCommandWithID:
+		// The first three bytes are the vendor ID.
+		if len(params) <= 3 {
+			return nil, false
+		}
+		return params[3:], true
+	}
+	return nil, false
+}
+
+// forwardVendorKeys drains the raw command stream and turns vendor remote
+// buttons into key presses. It must keep draining until commands is closed:
+// libcec blocks on a full channel, so after ctx is done frames are dropped.
+func forwardVendorKeys(ctx context.Context, commands <-chan *cec.Command, keyPresses chan<- *cec.KeyPress) {
+	for cmd := range commands {
+		payload, ok := vendorPayload(cmd)
+		if !ok {
+			continue
+		}
+		code, ok := vendorKeyCode(payload)
+		if !ok {
+			slog.Debug("Ignoring vendor command with an unsupported payload", "command", cmd.CommandString)
+			continue
+		}
+		slog.Debug("Vendor key press", "command", cmd.CommandString, "key", vendorKeyPrefix+hex.EncodeToString(payload))
+		select {
+		case keyPresses <- &cec.KeyPress{KeyCode: code}:
+		case <-ctx.Done():
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package cecctl
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/claes/cec"
+)
+
+func TestVendorKeyCode_RoundTrip(t *testing.T) {
+	for _, name := range []string{"vendor:05", "vendor:0005", "vendor:8a0102"} {
+		code, ok := parseVendorKeyName(name)
+		if !ok {
+			t.Fatalf("Failed to parse %q", name)
+		}
+		if got, ok := vendorKeyName(code); !ok || got != name {
+			t.Errorf("Expected %q back from code %#x, got %q", name, code, got)
+		}
+	}
+
+	short, _ := parseVendorKeyName("vendor:05")
+	long, _ := parseVendorKeyName("vendor:0005")
+	if short == long {
+		t.Error("Expected payloads of different lengths to get different codes")
+	}
+	if code, _ := parseVendorKeyName("Vendor:0x00:91"); code != keyCodeByName("vendor:0091") {
+		t.Error("Expected 0x prefix, separators and case to be accepted")
+	}
+	for _, bad := range []string{"vendor:", "vendor:zz", "vendor:01020304"} {
+		if keyCodeByName(bad) != -1 {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+	if _, ok := vendorKeyName(cec.GetKeyCodeByName("Select")); ok {
+		t.Error("Expected a standard key code not to be a vendor key")
+	}
+	if keyCodeByName("Select") != cec.GetKeyCodeByName("Select") {
+		t.Error("Expected standard key names to resolve as before")
+	}
+}
+
+func TestKeyName(t *testing.T) {
+	for _, name := range []string{"Select", "Exit", "0", "Blue", "vendor:0091"} {
+		code := keyCodeByName(name)
+		if got, ok := keyName(code); !ok || got != name {
+			t.Errorf("keyName(0x%02X) = %q, %v; expected %q", code, got, ok, name)
+		}
+	}
+	if name, ok := keyName(0xFE); ok {
+		t.Errorf("Expected no name for 0xFE, got %q", name)
+	}
+}
+
+func TestVendorPayload(t *testing.T) {
+	tests := []struct {
+		name     string
+		cmd      *cec.Command
+		expected []byte
+	}{
+		{"vendor command", &cec.Command{OpcodeSet: 1, Opcode: 0x89, CommandString: "01:89:91", Parameters: cec.DataPacket{Size: 1}}, []byte{0x91}},
+		{"remote button down", &cec.Command{OpcodeSet: 1, Opcode: 0x8A, CommandString: "01:8A:05:00:00", Parameters: cec.DataPacket{Size: 1}}, []byte{0x05}},
+		{"with vendor id", &cec.Command{OpcodeSet: 1, Opcode: 0xA0, CommandString: "01:A0:00:00:F0:12:34", Parameters: cec.DataPacket{Size: 5}}, []byte{0x12, 0x34}},
+		{"with vendor id only", &cec.Command{OpcodeSet: 1, Opcode: 0xA0, CommandString: "01:A0:00:00:F0", Parameters: cec.DataPacket{Size: 3}}, nil},
+		{"user control", &cec.Command{OpcodeSet: 1, Opcode: 0x44, CommandString: "01:44:00", Parameters: cec.DataPacket{Size: 1}}, nil},
+		{"poll", &cec.Command{CommandString: "01"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, ok := vendorPayload(tt.cmd)
+			if ok != (tt.expected != nil) || string(payload) != string(tt.expected) {
+				t.Errorf("Expected payload %x, got %x (ok=%v)", tt.expected, payload, ok)
+			}
+		})
+	}
+}
+
+func TestForwardVendorKeys(t *testing.T) {
+	commands := make(chan *cec.Command, 4)
+	keyPresses := make(chan *cec.KeyPress, 4)
+	done := make(chan struct{})
+	go func() {
+		forwardVendorKeys(context.Background(), commands, keyPresses)
+		close(done)
+	}()
+
+	commands <- &cec.Command{OpcodeSet: 1, Opcode: 0x44, CommandString: "01:44:00", Parameters: cec.DataPacket{Size: 1}}
+	commands <- &cec.Command{OpcodeSet: 1, Opcode: 0x89, CommandString: "01:89:91", Parameters: cec.DataPacket{Size: 1}}
+	close(commands)
+
+	select {
+	case kp := <-keyPresses:
+		if kp.KeyCode != keyCodeByName("vendor:91") {
+			t.Errorf("Expected the vendor key code, got %#x", kp.KeyCode)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for the vendor key press")
+	}
+	<-done
+	if len(keyPresses) != 0 {
+		t.Errorf("Expected only the vendor command to be forwarded, got %d extra", len(keyPresses))
+	}
+}
+
+func TestOnKeyPress_VendorKeyMapping(t *testing.T) {
+	mock := &MockKeyboardEmitter{}
+	km, err := newKeyMapWithEmitter(map[string][]int{"vendor:91": {28}}, mock, nil)
+	if err != nil {
+		t.Fatalf("newKeyMapWithEmitter failed: %v", err)
+	}
+	km.OnKeyPress(keyCodeByName("vendor:91"))
+	km.Close()
+
+	if len(mock.EmitCalls) != 1 || mock.EmitCalls[0][0] != 28 {
+		t.Errorf("Expected the vendor key to emit 28, got %v", mock.EmitCalls)
+	}
+}
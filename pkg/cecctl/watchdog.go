@@ -0,0 +1,61 @@
+package cecctl
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// idleWatchdog is a coarse safety net for a libcec that went silent: it fires
+// when no event was processed for maxIdle and the adapter does not answer a
+// ping either. A quiet evening with a healthy adapter never triggers it.
+type idleWatchdog struct {
+	maxIdle      time.Duration
+	alive        func() bool
+	clock        Clock
+	lastActivity atomic.Int64 // unix nanoseconds
+}
+
+func newIdleWatchdog(maxIdle time.Duration, alive func() bool) *idleWatchdog {
+	w := &idleWatchdog{maxIdle: maxIdle, alive: alive, clock: realClock{}}
+	w.Touch()
+	return w
+}
+
+// Touch records that an event was just processed.
+func (w *idleWatchdog) Touch() {
+	w.lastActivity.Store(w.clock.Now().UnixNano())
+}
+
+// idle returns how long it has been since the last processed event.
+func (w *idleWatchdog) idle() time.Duration {
+	return w.clock.Now().Sub(time.Unix(0, w.lastActivity.Load()))
+}
+
+// expired reports whether the daemon has been idle for too long and the
+// adapter cannot confirm it is still alive.
+func (w *idleWatchdog) expired() bool {
+	return w.idle() >= w.maxIdle && !w.alive()
+}
+
+// Run checks the watchdog periodically until ctx is done. The returned
+// channel receives a value once the watchdog expires.
+func (w *idleWatchdog) Run(ctx context.Context) <-chan struct{} {
+	fired := make(chan struct{}, 1)
+	go func() {
+		ticker := w.clock.NewTicker(w.maxIdle / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C():
+				if w.expired() {
+					fired <- struct{}{}
+					return
+				}
+			}
+		}
+	}()
+	return fired
+}
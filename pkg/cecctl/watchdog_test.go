@@ -0,0 +1,61 @@
+package cecctl
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestIdleWatchdog_Expired(t *testing.T) {
+	clock := newFakeClock()
+	alive := true
+	w := &idleWatchdog{maxIdle: time.Minute, alive: func() bool { return alive }, clock: clock}
+	w.Touch()
+
+	clock.Advance(30 * time.Second)
+	alive = false
+	if w.expired() {
+		t.Error("Expected the watchdog not to expire before maxIdle")
+	}
+
+	clock.Advance(time.Minute)
+	alive = true
+	if w.expired() {
+		t.Error("Expected the watchdog not to expire while the adapter answers")
+	}
+
+	alive = false
+	if !w.expired() {
+		t.Error("Expected the watchdog to expire when idle and the adapter does not answer")
+	}
+
+	w.Touch()
+	if w.expired() {
+		t.Error("Expected Touch to reset the idle time")
+	}
+}
+
+func TestIdleWatchdog_RunFires(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := newFakeClock()
+	w := &idleWatchdog{maxIdle: time.Minute, alive: func() bool { return false }, clock: clock}
+	w.Touch()
+	fired := w.Run(ctx)
+	clock.waitForWaiters(t, 1)
+
+	clock.Advance(45 * time.Second)
+	select {
+	case <-fired:
+		t.Fatal("Expected the watchdog not to fire before maxIdle")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clock.Advance(15 * time.Second)
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for the watchdog to fire")
+	}
+}
@@ -0,0 +1,147 @@
+package cecctl
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// uinput ioctls and input event codes, from linux/uinput.h and
+// linux/input-event-codes.h.
+const (
+	uiDevCreate  = 0x5501
+	uiDevDestroy = 0x5502
+	uiSetEvBit   = 0x40045564 // _IOW('U', 100, int)
+	uiSetRelBit  = 0x40045566 // _IOW('U', 102, int)
+
+	evSyn     = 0x00
+	evRel     = 0x02
+	synReport = 0
+	relHWheel = 0x06
+	relWheel  = 0x08
+
+	uinputMaxNameSize = 80
+	absCount          = 64
+)
+
+// wheelDeviceName is the name of the virtual wheel, e.g. in libinput list-devices.
+const wheelDeviceName = "cec-controller wheel"
+
+// uinputUserDev is struct uinput_user_dev, written to set up the device.
+// binary.Write packs the fields, which matches the kernel layout.
+type uinputUserDev struct {
+	Name         [uinputMaxNameSize]byte
+	BusType      uint16
+	Vendor       uint16
+	Product      uint16
+	Version      uint16
+	FFEffectsMax uint32
+	AbsMax       [absCount]int32
+	AbsMin       [absCount]int32
+	AbsFuzz      [absCount]int32
+	AbsFlat      [absCount]int32
+}
+
+// inputEvent is struct input_event.
+type inputEvent struct {
+	Time  syscall.Timeval
+	Type  uint16
+	Code  uint16
+	Value int32
+}
+
+// uinputWheel is a WheelEmitter backed by its own uinput device, which only
+// has a vertical and a horizontal wheel: keybd_event only creates keyboards.
+type uinputWheel struct {
+	mu  sync.Mutex
+	dev io.WriteCloser
+}
+
+// newUinputWheel creates the virtual wheel on the uinput device at path.
+func newUinputWheel(path string) (*uinputWheel, error) {
+	if err := checkUinput(path); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open uinput: %w", err)
+	}
+	ioctl := func(request, arg uintptr) error {
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), request, arg); errno != 0 {
+			return errno
+		}
+		return nil
+	}
+	for _, setup := range [][2]uintptr{{uiSetEvBit, evRel}, {uiSetRelBit, relWheel}, {uiSetRelBit, relHWheel}} {
+		if err := ioctl(setup[0], setup[1]); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to set up the virtual wheel: %w", err)
+		}
+	}
+	dev := uinputUserDev{BusType: 0x06, Vendor: 0x4711, Product: 0x0816, Version: 1} // BUS_VIRTUAL
+	copy(dev.Name[:], wheelDeviceName)
+	if err := binary.Write(f, binary.NativeEndian, &dev); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to set up the virtual wheel: %w", err)
+	}
+	if err := ioctl(uiDevCreate, 0); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to create the virtual wheel: %w", err)
+	}
+	// Like keybd_event, give udev and the compositor time to pick up the new
+	// device before the first event.
+	time.Sleep(time.Second)
+	return &uinputWheel{dev: &uinputDevice{File: f, destroy: func() error { return ioctl(uiDevDestroy, 0) }}}, nil
+}
+
+func (w *uinputWheel) Scroll(horizontal, vertical int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return writeScroll(w.dev, horizontal, vertical)
+}
+
+func (w *uinputWheel) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.dev.Close()
+}
+
+// writeScroll writes the wheel events of a scroll, followed by the report
+// that makes the input layer deliver them together.
+func writeScroll(dev io.Writer, horizontal, vertical int) error {
+	var events []inputEvent
+	if horizontal != 0 {
+		events = append(events, inputEvent{Type: evRel, Code: relHWheel, Value: int32(horizontal)})
+	}
+	if vertical != 0 {
+		events = append(events, inputEvent{Type: evRel, Code: relWheel, Value: int32(vertical)})
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	events = append(events, inputEvent{Type: evSyn, Code: synReport})
+	for _, ev := range events {
+		if err := binary.Write(dev, binary.NativeEndian, &ev); err != nil {
+			return fmt.Errorf("failed to send wheel event: %w", err)
+		}
+	}
+	return nil
+}
+
+// uinputDevice is an open uinput device, destroyed when closed.
+type uinputDevice struct {
+	*os.File
+	destroy func() error
+}
+
+func (d *uinputDevice) Close() error {
+	if err := d.destroy(); err != nil {
+		d.File.Close()
+		return fmt.Errorf("failed to destroy uinput device: %w", err)
+	}
+	return d.File.Close()
+}
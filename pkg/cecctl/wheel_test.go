@@ -0,0 +1,51 @@
+package cecctl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestWriteScroll(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeScroll(&buf, -2, 1); err != nil {
+		t.Fatalf("writeScroll failed: %v", err)
+	}
+	var events []inputEvent
+	for buf.Len() > 0 {
+		var ev inputEvent
+		if err := binary.Read(&buf, binary.NativeEndian, &ev); err != nil {
+			t.Fatalf("Failed to read input event: %v", err)
+		}
+		events = append(events, ev)
+	}
+	want := []inputEvent{
+		{Type: evRel, Code: relHWheel, Value: -2},
+		{Type: evRel, Code: relWheel, Value: 1},
+		{Type: evSyn, Code: synReport},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("Expected %d events, got %+v", len(want), events)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("Event %d: expected %+v, got %+v", i, want[i], events[i])
+		}
+	}
+
+	buf.Reset()
+	if err := writeScroll(&buf, 0, 0); err != nil || buf.Len() != 0 {
+		t.Errorf("Expected nothing written for an empty scroll, got %d bytes (%v)", buf.Len(), err)
+	}
+}
+
+func TestInputEventSize(t *testing.T) {
+	// struct input_event on 64-bit Linux: a 16 byte timeval, type, code and value.
+	if got := binary.Size(inputEvent{}); got != 24 {
+		t.Errorf("Expected 24 byte input events, got %d", got)
+	}
+	// struct uinput_user_dev: name, input_id, ff_effects_max and four abs arrays.
+	if got := binary.Size(uinputUserDev{}); got != 80+8+4+4*64*4 {
+		t.Errorf("Expected a 1116 byte uinput_user_dev, got %d", got)
+	}
+}